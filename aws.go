@@ -2,16 +2,207 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// defaultAWSRetryBaseDelay is the backoff of the first retry of a throttled AWS API call. It
+	// doubles with every retry, up to maxAWSRetryDelay.
+	defaultAWSRetryBaseDelay = 200 * time.Millisecond
+	maxAWSRetryDelay         = 10 * time.Second
+
+	// maxAWSTagsPerCall is the maximum number of tags of a CreateTags or DeleteTags call, the
+	// maximum number of tags of an EC2 resource
+	maxAWSTagsPerCall = 50
+
+	// maxAWSTagKeyLength is the maximum length of an AWS tag key, in characters
+	maxAWSTagKeyLength = 128
+
+	// maxAWSTagValueLength is the maximum length of an AWS tag value, in characters
+	maxAWSTagValueLength = 256
+
+	// reservedAWSTagPrefix is the tag key prefix reserved for AWS use, tags with it can't be
+	// created or deleted
+	reservedAWSTagPrefix = "aws:"
 )
 
 // ec2Client is the minimum interface we need from the AWS SDK to manage node tags
 type ec2Client interface {
 	DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error)
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
 	CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
 	DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error)
 }
 
 // aws-sdk-go v2's ec2.Client implements our ec2Client interface, so we can use it directly
 var _ ec2Client = (*ec2.Client)(nil)
+
+// awsInstance is the state of an EC2 instance read with DescribeInstances: its tags and the IDs
+// of its attached resources.
+type awsInstance struct {
+	tags                []types.TagDescription
+	volumeIDs           []string
+	networkInterfaceIDs []string
+}
+
+// newAWSInstance extracts the tags, as returned by DescribeTags, and the attached volume and
+// network interface IDs of instance. Only the volumes deleted on the instance's termination, eg:
+// its root volume, are the instance's: volumes attached later, eg: of PVCs, move between
+// instances, and their tags would flip with each node they're attached to.
+func newAWSInstance(instance types.Instance) *awsInstance {
+	res := &awsInstance{}
+	for _, tag := range instance.Tags {
+		res.tags = append(res.tags, types.TagDescription{
+			ResourceId:   instance.InstanceId,
+			ResourceType: types.ResourceTypeInstance,
+			Key:          tag.Key,
+			Value:        tag.Value,
+		})
+	}
+	for _, m := range instance.BlockDeviceMappings {
+		if m.Ebs != nil && m.Ebs.VolumeId != nil && aws.ToBool(m.Ebs.DeleteOnTermination) {
+			res.volumeIDs = append(res.volumeIDs, aws.ToString(m.Ebs.VolumeId))
+		}
+	}
+	for _, ni := range instance.NetworkInterfaces {
+		if ni.NetworkInterfaceId != nil {
+			res.networkInterfaceIDs = append(res.networkInterfaceIDs, aws.ToString(ni.NetworkInterfaceId))
+		}
+	}
+	return res
+}
+
+// azToRegionFuncs are the supported --az-to-region-func values. A nil func disables per-region
+// clients and tags every instance through the controller's home region.
+var azToRegionFuncs = map[string]func(az string) (string, error){
+	"suffix": regionFromAZ,
+	"none":   nil,
+}
+
+// parseAWSProviderID parses the availability zone and instance ID from an AWS provider ID of the
+// form aws:///<az>/<instance-id>. The availability zone is empty if the provider ID has none,
+// eg: aws:///<instance-id>.
+func parseAWSProviderID(providerID string) (string, string, error) {
+	trimmed, ok := strings.CutPrefix(providerID, "aws://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid AWS provider ID format: %q", providerID)
+	}
+	parts := strings.Split(strings.TrimLeft(trimmed, "/"), "/")
+	instanceID := parts[len(parts)-1]
+	if instanceID == "" {
+		return "", "", fmt.Errorf("invalid AWS provider ID format: %q", providerID)
+	}
+	if len(parts) == 1 {
+		return "", instanceID, nil
+	}
+	return parts[len(parts)-2], instanceID, nil
+}
+
+// regionFromAZ derives the region of an availability zone by dropping the zone suffix, eg:
+// us-east-1a -> us-east-1. Local and Wavelength zones are supported too, eg: us-west-2-lax-1a
+// -> us-west-2.
+func regionFromAZ(az string) (string, error) {
+	parts := strings.Split(az, "-")
+	for i, p := range parts {
+		if i == 0 || p == "" || !unicode.IsDigit(rune(p[0])) {
+			continue
+		}
+		// the first numeric part ends the region, eg: "1a" in us-east-1a
+		num := strings.TrimRightFunc(p, unicode.IsLetter)
+		if strings.TrimLeftFunc(num, unicode.IsDigit) != "" {
+			break
+		}
+		return strings.Join(append(parts[:i:i], num), "-"), nil
+	}
+	return "", fmt.Errorf("unable to derive region from availability zone %q", az)
+}
+
+// awsRetryables classifies the AWS API errors worth retrying: throttling and server errors.
+// Other errors, eg: validation errors, aren't retried.
+var awsRetryables = retry.IsErrorRetryables{
+	retry.NoRetryCanceledError{},
+	retry.RetryableErrorCode{Codes: retry.DefaultThrottleErrorCodes},
+	retry.RetryableHTTPStatusCode{Codes: retry.DefaultRetryableHTTPStatusCodes},
+}
+
+// isNotFoundAWSError returns whether err is an EC2 API error of a missing instance, eg: a
+// terminated instance.
+func isNotFoundAWSError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound"
+}
+
+// retryAWS calls fn until it succeeds, fails with an error that isn't retryable or maxRetries
+// retries are used up. Retries are delayed by an exponential backoff from baseDelay with full
+// jitter, so throttled reconciles don't retry in lockstep.
+func retryAWS(ctx context.Context, maxRetries int, baseDelay time.Duration, fn func() error) error {
+	retryable := func(err error) bool {
+		return awsRetryables.IsErrorRetryable(err) == aws.TrueTernary
+	}
+	return retryCloudCall(ctx, "aws", maxRetries, baseDelay, maxAWSRetryDelay, retryable, fn)
+}
+
+// orderAWSTags splits tags into those whose key is in order, sorted as in order, and the others.
+func orderAWSTags(tags []types.Tag, order []string) ([]types.Tag, []types.Tag) {
+	var ordered, unordered []types.Tag
+	for _, tag := range tags {
+		if slices.Contains(order, aws.ToString(tag.Key)) {
+			ordered = append(ordered, tag)
+		} else {
+			unordered = append(unordered, tag)
+		}
+	}
+	slices.SortStableFunc(ordered, func(a, b types.Tag) int {
+		return slices.Index(order, aws.ToString(a.Key)) - slices.Index(order, aws.ToString(b.Key))
+	})
+	return ordered, unordered
+}
+
+// isReservedAWSTagKey returns whether key starts with the reserved aws: prefix, in any case.
+func isReservedAWSTagKey(key string) bool {
+	return len(key) >= len(reservedAWSTagPrefix) && strings.EqualFold(key[:len(reservedAWSTagPrefix)], reservedAWSTagPrefix)
+}
+
+// validateAWSTagKey returns why key can't be an AWS tag key, or nil. Such keys would fail the
+// whole CreateTags call they're part of.
+func validateAWSTagKey(key string) error {
+	switch {
+	case key == "":
+		return errors.New("the key is empty")
+	case isReservedAWSTagKey(key):
+		return fmt.Errorf("the key uses the reserved prefix %q", reservedAWSTagPrefix)
+	case utf8.RuneCountInString(key) > maxAWSTagKeyLength:
+		return fmt.Errorf("the key is longer than %d characters", maxAWSTagKeyLength)
+	case strings.ContainsFunc(key, func(r rune) bool { return !isAWSTagRune(r) }):
+		return errors.New("the key contains characters AWS doesn't allow")
+	}
+	return nil
+}
+
+// isAWSTagRune returns whether AWS allows r in tag keys and values: letters, digits, spaces and
+// +-=._:/@ are.
+func isAWSTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || strings.ContainsRune("+-=._:/@", r)
+}
+
+// sanitizeValueForAWS replaces invalid UTF-8, which EC2 rejects in tag values, with underscores
+// and truncates the value to maxAWSTagValueLength. EC2 allows any other character in values.
+func sanitizeValueForAWS(value string) string {
+	value = strings.ToValidUTF8(value, "_")
+	if runes := []rune(value); len(runes) > maxAWSTagValueLength {
+		value = string(runes[:maxAWSTagValueLength])
+	}
+	return value
+}
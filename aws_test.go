@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAWSProviderID(t *testing.T) {
+	tests := []struct {
+		name           string
+		providerID     string
+		wantAZ         string
+		wantInstanceID string
+		wantErr        bool
+	}{
+		{
+			name:           "with availability zone",
+			providerID:     "aws:///us-east-1a/i-1234567890abcdef0",
+			wantAZ:         "us-east-1a",
+			wantInstanceID: "i-1234567890abcdef0",
+		},
+		{
+			name:           "without availability zone",
+			providerID:     "aws:///i-1234567890abcdef0",
+			wantInstanceID: "i-1234567890abcdef0",
+		},
+		{
+			name:       "missing instance ID",
+			providerID: "aws:///us-east-1a/",
+			wantErr:    true,
+		},
+		{
+			name:       "not an AWS provider ID",
+			providerID: "gce://project/zone/instance",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az, instanceID, err := parseAWSProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAZ, az)
+			assert.Equal(t, tt.wantInstanceID, instanceID)
+		})
+	}
+}
+
+func TestRegionFromAZ(t *testing.T) {
+	tests := []struct {
+		az      string
+		want    string
+		wantErr bool
+	}{
+		{az: "us-east-1a", want: "us-east-1"},
+		{az: "eu-central-1c", want: "eu-central-1"},
+		{az: "ap-southeast-2b", want: "ap-southeast-2"},
+		{az: "us-gov-west-1a", want: "us-gov-west-1"},
+		{az: "cn-north-1a", want: "cn-north-1"},
+		{az: "us-west-2-lax-1a", want: "us-west-2"},
+		{az: "us-east-1-wl1-bos-wlz-1", want: "us-east-1"},
+		{az: "use1-az1", wantErr: true},
+		{az: "invalid", wantErr: true},
+		{az: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.az, func(t *testing.T) {
+			got, err := regionFromAZ(tt.az)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// httpStatusError is an error of an AWS API response with an HTTP status code
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("http status %d", e.status)
+}
+
+func (e *httpStatusError) HTTPStatusCode() int {
+	return e.status
+}
+
+func TestRetryAWS(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."}
+
+	tests := []struct {
+		name       string
+		errs       []error
+		maxRetries int
+		wantCalls  int
+		wantErr    error
+	}{
+		{
+			name:       "success",
+			maxRetries: 3,
+			wantCalls:  1,
+		},
+		{
+			name:       "throttled, then success",
+			errs:       []error{throttled, throttled},
+			maxRetries: 3,
+			wantCalls:  3,
+		},
+		{
+			name:       "server error, then success",
+			errs:       []error{&httpStatusError{status: 503}},
+			maxRetries: 3,
+			wantCalls:  2,
+		},
+		{
+			name:       "retries used up",
+			errs:       []error{throttled, throttled, throttled},
+			maxRetries: 2,
+			wantCalls:  3,
+			wantErr:    throttled,
+		},
+		{
+			name:       "validation errors are not retried",
+			errs:       []error{&smithy.GenericAPIError{Code: "InvalidParameterValue"}},
+			maxRetries: 3,
+			wantCalls:  1,
+			wantErr:    &smithy.GenericAPIError{Code: "InvalidParameterValue"},
+		},
+		{
+			name:       "client errors are not retried",
+			errs:       []error{&httpStatusError{status: 403}},
+			maxRetries: 3,
+			wantCalls:  1,
+			wantErr:    &httpStatusError{status: 403},
+		},
+		{
+			name:       "retries disabled",
+			errs:       []error{throttled},
+			maxRetries: 0,
+			wantCalls:  1,
+			wantErr:    throttled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryAWS(context.Background(), tt.maxRetries, time.Millisecond, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestRetryAWSContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	throttled := &smithy.GenericAPIError{Code: "Throttling"}
+	err := retryAWS(ctx, 3, time.Hour, func() error {
+		calls++
+		return throttled
+	})
+	assert.True(t, errors.Is(err, throttled))
+	assert.Equal(t, 1, calls)
+}
+
+func TestSanitizeValueForAWS(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "allowed characters", value: "Prod env: us-east-1/a+b=c_d.e@f", want: "Prod env: us-east-1/a+b=c_d.e@f"},
+		{name: "unicode letters", value: "café", want: "café"},
+		{name: "JSON", value: `{"team":"a","cost":[1,2]}`, want: `{"team":"a","cost":[1,2]}`},
+		{name: "invalid UTF-8", value: "team\xffa", want: "team_a"},
+		{name: "exceeding maximum length", value: strings.Repeat("v", 300), want: strings.Repeat("v", maxAWSTagValueLength)},
+		{name: "exceeding maximum length in characters", value: strings.Repeat("é", 300), want: strings.Repeat("é", maxAWSTagValueLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeValueForAWS(tt.value))
+		})
+	}
+}
+
+func TestValidateAWSTagKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr string
+	}{
+		{name: "label key", key: "example.com/team"},
+		{name: "allowed characters", key: "k8s:Cost center+=_.@"},
+		{name: "maximum length", key: strings.Repeat("é", maxAWSTagKeyLength)},
+		{name: "empty", key: "", wantErr: "the key is empty"},
+		{name: "reserved prefix", key: "aws:env", wantErr: `the key uses the reserved prefix "aws:"`},
+		{name: "exceeding maximum length", key: strings.Repeat("k", maxAWSTagKeyLength+1), wantErr: "the key is longer than 128 characters"},
+		{name: "disallowed characters", key: "team#1", wantErr: "the key contains characters AWS doesn't allow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAWSTagKey(tt.key)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsReservedAWSTagKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "aws:cloudformation:stack-name", want: true},
+		{key: "AWS:env", want: true},
+		{key: "aws", want: false},
+		{key: "aws-team", want: false},
+		{key: "example.com/aws:env", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.want, isReservedAWSTagKey(tt.key))
+		})
+	}
+}
+
+func TestOrderAWSTags(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("cost-center"), Value: aws.String("123")},
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("owner"), Value: aws.String("alice")},
+		{Key: aws.String("team"), Value: aws.String("a")},
+	}
+
+	ordered, unordered := orderAWSTags(tags, []string{"team", "missing", "env"})
+	assert.Equal(t, []types.Tag{tags[3], tags[1]}, ordered)
+	assert.Equal(t, []types.Tag{tags[0], tags[2]}, unordered)
+
+	ordered, unordered = orderAWSTags(tags, nil)
+	assert.Empty(t, ordered)
+	assert.Equal(t, tags, unordered)
+}
+
+func TestNewAWSInstance(t *testing.T) {
+	instance := newAWSInstance(types.Instance{
+		InstanceId: aws.String("i-1234567890abcdef0"),
+		Tags:       []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}},
+		BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-1"), DeleteOnTermination: aws.Bool(true)}},
+			{DeviceName: aws.String("/dev/sdb")},
+			// eg: the volume of a PVC, attached to the instance by the EBS CSI driver
+			{DeviceName: aws.String("/dev/xvdba"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-2"), DeleteOnTermination: aws.Bool(false)}},
+		},
+		NetworkInterfaces: []types.InstanceNetworkInterface{{NetworkInterfaceId: aws.String("eni-1")}},
+	})
+
+	assert.Equal(t, []types.TagDescription{{
+		ResourceId:   aws.String("i-1234567890abcdef0"),
+		ResourceType: types.ResourceTypeInstance,
+		Key:          aws.String("env"),
+		Value:        aws.String("prod"),
+	}}, instance.tags)
+	assert.Equal(t, []string{"vol-1"}, instance.volumeIDs)
+	assert.Equal(t, []string{"eni-1"}, instance.networkInterfaceIDs)
+}
+
+func TestSyncAWSTagsDescribeInstances(t *testing.T) {
+	const providerID = "aws:///us-east-1a/i-1234567890abcdef0"
+	currentTags := []types.TagDescription{
+		{Key: aws.String("env"), Value: aws.String("staging")},
+		{Key: aws.String("team"), Value: aws.String("a")},
+	}
+
+	for _, describeInstances := range []bool{false, true} {
+		t.Run(fmt.Sprintf("describe instances %t", describeInstances), func(t *testing.T) {
+			mock := &mockEC2Client{
+				currentTags:         currentTags,
+				volumeIDs:           []string{"vol-1", "vol-2"},
+				networkInterfaceIDs: []string{"eni-1"},
+			}
+			r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, AWSDescribeInstances: describeInstances}
+
+			require.NoError(t, r.syncTags(context.Background(), providerID, map[string]string{"env": "prod"}, false))
+			assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+			assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("a")}}, mock.deletedTags)
+
+			// DescribeInstances replaces the DescribeTags call rather than adding one
+			if describeInstances {
+				assert.Equal(t, 0, mock.describeTagsCalls)
+				assert.Equal(t, 1, mock.describeInstancesCalls)
+			} else {
+				assert.Equal(t, 1, mock.describeTagsCalls)
+				assert.Equal(t, 0, mock.describeInstancesCalls)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		mock := &mockEC2Client{describeErr: errors.New("unauthorized")}
+		r := &NodeLabelController{Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, AWSDescribeInstances: true}
+		err := r.syncTags(context.Background(), providerID, map[string]string{"env": "prod"}, false)
+		assert.EqualError(t, err, "failed to fetch node's current AWS tags: unauthorized")
+	})
+}
+
+// resourceTagsEC2Client is an ec2Client keeping the tags of an instance and its attached volumes
+// and network interfaces by resource ID, and recording the tag writes
+type resourceTagsEC2Client struct {
+	instanceID string
+	volumeIDs  []string
+	eniIDs     []string
+	tags       map[string]map[string]string
+	writes     []string
+
+	// failResource fails the tag writes of the resource when set
+	failResource string
+}
+
+func (m *resourceTagsEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	var out ec2.DescribeTagsOutput
+	for _, id := range params.Filters[0].Values {
+		for _, k := range slices.Sorted(maps.Keys(m.tags[id])) {
+			out.Tags = append(out.Tags, types.TagDescription{ResourceId: aws.String(id), Key: aws.String(k), Value: aws.String(m.tags[id][k])})
+		}
+	}
+	return &out, nil
+}
+
+func (m *resourceTagsEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	instance := types.Instance{InstanceId: aws.String(m.instanceID)}
+	for _, k := range slices.Sorted(maps.Keys(m.tags[m.instanceID])) {
+		instance.Tags = append(instance.Tags, types.Tag{Key: aws.String(k), Value: aws.String(m.tags[m.instanceID][k])})
+	}
+	for _, id := range m.volumeIDs {
+		instance.BlockDeviceMappings = append(instance.BlockDeviceMappings, types.InstanceBlockDeviceMapping{Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String(id), DeleteOnTermination: aws.Bool(true)}})
+	}
+	for _, id := range m.eniIDs {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, types.InstanceNetworkInterface{NetworkInterfaceId: aws.String(id)})
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{instance}}}}, nil
+}
+
+func (m *resourceTagsEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	id := params.Resources[0]
+	m.writes = append(m.writes, "CreateTags "+id)
+	if id == m.failResource {
+		return nil, errors.New("unauthorized")
+	}
+	if m.tags[id] == nil {
+		m.tags[id] = make(map[string]string)
+	}
+	for _, tag := range params.Tags {
+		m.tags[id][aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *resourceTagsEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	id := params.Resources[0]
+	m.writes = append(m.writes, "DeleteTags "+id)
+	if id == m.failResource {
+		return nil, errors.New("unauthorized")
+	}
+	for _, tag := range params.Tags {
+		delete(m.tags[id], aws.ToString(tag.Key))
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+func TestSyncAWSTagsEBSVolumes(t *testing.T) {
+	const providerID = "aws:///us-east-1a/i-1234567890abcdef0"
+	newMock := func() *resourceTagsEC2Client {
+		return &resourceTagsEC2Client{
+			instanceID: "i-1234567890abcdef0",
+			volumeIDs:  []string{"vol-1", "vol-2"},
+			tags: map[string]map[string]string{
+				"i-1234567890abcdef0": {"env": "prod", "Name": "node1"},
+				"vol-1":               {"env": "staging", "team": "a", "backup": "daily"},
+			},
+		}
+	}
+	newController := func(mock ec2Client) *NodeLabelController {
+		return &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, AWSTagEBSVolumes: true}
+	}
+	desired := map[string]string{"env": "prod"}
+
+	t.Run("volumes", func(t *testing.T) {
+		mock := newMock()
+		r := newController(mock)
+
+		// the volumes are synced even though the instance is up to date
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Equal(t, []string{"DeleteTags vol-1", "CreateTags vol-1", "CreateTags vol-2"}, mock.writes)
+		assert.Equal(t, map[string]map[string]string{
+			"i-1234567890abcdef0": {"env": "prod", "Name": "node1"},
+			"vol-1":               {"env": "prod", "backup": "daily"},
+			"vol-2":               {"env": "prod"},
+		}, mock.tags)
+
+		mock.writes = nil
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Empty(t, mock.writes)
+
+		// cleanup removes the managed tags of the volumes too
+		require.NoError(t, r.cleanupInstance(context.Background(), "node1", providerID, false))
+		assert.Equal(t, map[string]map[string]string{
+			"i-1234567890abcdef0": {"Name": "node1"},
+			"vol-1":               {"backup": "daily"},
+			"vol-2":               {},
+		}, mock.tags)
+	})
+
+	t.Run("managed-by tag", func(t *testing.T) {
+		// a volume's own managed-by tag decides which of its tags are managed, eg: zone synced
+		// under a previous configuration
+		mock := newMock()
+		mock.tags["vol-1"] = map[string]string{"env": "prod", "zone": "us-east-1a", "backup": "daily", "managed-by": "env zone"}
+		r := newController(mock)
+		r.ManagedByTag = "managed-by"
+
+		desired := map[string]string{"env": "prod", "managed-by": "env team"}
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Equal(t, map[string]string{"env": "prod", "backup": "daily", "managed-by": "env team"}, mock.tags["vol-1"])
+		assert.Equal(t, map[string]string{"env": "prod", "managed-by": "env team"}, mock.tags["vol-2"])
+	})
+
+	t.Run("volume error", func(t *testing.T) {
+		mock := newMock()
+		mock.failResource = "vol-1"
+		r := newController(mock)
+
+		err := r.syncTags(context.Background(), providerID, desired, false)
+		assert.EqualError(t, err, "failed to update AWS volume vol-1 tags: failed to delete AWS tags: unauthorized")
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.tags["vol-2"], "other volumes are still synced")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		mock := newMock()
+		r := newController(mock)
+
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, true))
+		assert.Empty(t, mock.writes)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mock := newMock()
+		r := newController(mock)
+		r.AWSTagEBSVolumes = false
+
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Empty(t, mock.writes)
+	})
+}
+
+func TestSyncAWSTagsENIs(t *testing.T) {
+	const providerID = "aws:///us-east-1a/i-1234567890abcdef0"
+	newMock := func() *resourceTagsEC2Client {
+		return &resourceTagsEC2Client{
+			instanceID: "i-1234567890abcdef0",
+			volumeIDs:  []string{"vol-1"},
+			eniIDs:     []string{"eni-1", "eni-2"},
+			tags: map[string]map[string]string{
+				"i-1234567890abcdef0": {"env": "prod"},
+				"eni-1":               {"env": "staging", "team": "a", "node.k8s.amazonaws.com/instance_id": "i-1234567890abcdef0"},
+			},
+		}
+	}
+	desired := map[string]string{"env": "prod"}
+
+	t.Run("network interfaces", func(t *testing.T) {
+		mock := newMock()
+		r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, AWSTagENIs: true}
+
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Equal(t, []string{"DeleteTags eni-1", "CreateTags eni-1", "CreateTags eni-2"}, mock.writes)
+		assert.Equal(t, map[string]map[string]string{
+			"i-1234567890abcdef0": {"env": "prod"},
+			"eni-1":               {"env": "prod", "node.k8s.amazonaws.com/instance_id": "i-1234567890abcdef0"},
+			"eni-2":               {"env": "prod"},
+		}, mock.tags, "the volumes are left alone")
+
+		// cleanup removes the managed tags of the network interfaces too
+		require.NoError(t, r.cleanupInstance(context.Background(), "node1", providerID, false))
+		assert.Equal(t, map[string]map[string]string{
+			"i-1234567890abcdef0": {},
+			"eni-1":               {"node.k8s.amazonaws.com/instance_id": "i-1234567890abcdef0"},
+			"eni-2":               {},
+		}, mock.tags)
+	})
+
+	t.Run("with volumes", func(t *testing.T) {
+		mock := newMock()
+		r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, AWSTagEBSVolumes: true, AWSTagENIs: true}
+
+		require.NoError(t, r.syncTags(context.Background(), providerID, desired, false))
+		assert.Equal(t, []string{"CreateTags vol-1", "DeleteTags eni-1", "CreateTags eni-1", "CreateTags eni-2"}, mock.writes)
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.tags["vol-1"])
+	})
+
+	t.Run("network interface error", func(t *testing.T) {
+		mock := newMock()
+		mock.failResource = "eni-1"
+		r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, AWSTagENIs: true}
+
+		err := r.syncTags(context.Background(), providerID, desired, false)
+		assert.EqualError(t, err, "failed to update AWS network interface eni-1 tags: failed to delete AWS tags: unauthorized")
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.tags["eni-2"], "other network interfaces are still synced")
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	// maxAzureTagKeyLength and maxAzureTagValueLength are the Azure tag name and value limits
+	maxAzureTagKeyLength   = 512
+	maxAzureTagValueLength = 256
+)
+
+// minimal interface we need for managing Azure VM tags
+type azureClient interface {
+	GetTags(ctx context.Context, resourceID string) (map[string]string, error)
+	MergeTags(ctx context.Context, resourceID string, tags map[string]string) error
+	DeleteTags(ctx context.Context, resourceID string, tags map[string]string) error
+}
+
+var _ azureClient = (*azureTagsClient)(nil)
+
+// Azure client implementation that wraps the resource manager's tags client
+type azureTagsClient struct {
+	*armresources.TagsClient
+}
+
+func newAzureTagsClient(client *armresources.TagsClient) *azureTagsClient {
+	return &azureTagsClient{client}
+}
+
+func (c *azureTagsClient) GetTags(ctx context.Context, resourceID string) (map[string]string, error) {
+	resp, err := c.GetAtScope(ctx, azureScope(resourceID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	if resp.Properties != nil {
+		for k, v := range resp.Properties.Tags {
+			if v != nil {
+				tags[k] = *v
+			} else {
+				tags[k] = ""
+			}
+		}
+	}
+	return tags, nil
+}
+
+// MergeTags adds or updates tags, leaving all other tags of the resource as is.
+func (c *azureTagsClient) MergeTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	return c.updateTags(ctx, resourceID, armresources.TagsPatchOperationMerge, tags)
+}
+
+// DeleteTags deletes tags that still have the given values.
+func (c *azureTagsClient) DeleteTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	return c.updateTags(ctx, resourceID, armresources.TagsPatchOperationDelete, tags)
+}
+
+func (c *azureTagsClient) updateTags(ctx context.Context, resourceID string, op armresources.TagsPatchOperation, tags map[string]string) error {
+	props := &armresources.Tags{Tags: make(map[string]*string, len(tags))}
+	for k, v := range tags {
+		props.Tags[k] = to.Ptr(v)
+	}
+
+	_, err := c.UpdateAtScope(ctx, azureScope(resourceID), armresources.TagsPatchResource{
+		Operation:  to.Ptr(op),
+		Properties: props,
+	}, nil)
+	return err
+}
+
+// isNotFoundAzureError returns whether err is an Azure API error of a missing resource, eg: a
+// deleted VM.
+func isNotFoundAzureError(err error) bool {
+	var rerr *azcore.ResponseError
+	return errors.As(err, &rerr) && (rerr.ErrorCode == "ResourceNotFound" || rerr.StatusCode == http.StatusNotFound)
+}
+
+// azureScope returns the scope of the tags API for a resource ID. The API adds the leading slash.
+func azureScope(resourceID string) string {
+	return strings.TrimPrefix(resourceID, "/")
+}
+
+// parseAzureProviderID parses the subscription, resource group and VM name from an Azure
+// provider ID of the form:
+// azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/<vm>
+func parseAzureProviderID(providerID string) (string, string, string, error) {
+	trimmed, ok := strings.CutPrefix(providerID, "azure:///")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid Azure provider ID format: %q", providerID)
+	}
+
+	// resource IDs are case-insensitive, eg: AKS uses resourcegroups
+	parts := strings.Split(trimmed, "/")
+	if len(parts) != 8 ||
+		!strings.EqualFold(parts[0], "subscriptions") ||
+		!strings.EqualFold(parts[2], "resourceGroups") ||
+		!strings.EqualFold(parts[4], "providers") ||
+		!strings.EqualFold(parts[5], "Microsoft.Compute") ||
+		!strings.EqualFold(parts[6], "virtualMachines") {
+		return "", "", "", fmt.Errorf("unsupported Azure provider ID format: %q", providerID)
+	}
+
+	subscription, resourceGroup, vm := parts[1], parts[3], parts[7]
+	if subscription == "" || resourceGroup == "" || vm == "" {
+		return "", "", "", fmt.Errorf("invalid Azure provider ID format: %q", providerID)
+	}
+	return subscription, resourceGroup, vm, nil
+}
+
+// azureVMResourceID returns the resource ID of a VM.
+func azureVMResourceID(subscription, resourceGroup, vm string) string {
+	return "/" + path.Join("subscriptions", subscription, "resourceGroups", resourceGroup, "providers/Microsoft.Compute/virtualMachines", vm)
+}
+
+// sanitizeTagsForAzure sanitizes the keys and values of tags for Azure.
+func sanitizeTagsForAzure(tags map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(tags))
+	for k, v := range tags {
+		sanitized[sanitizeKeyForAzure(k)] = sanitizeValueForAzure(v)
+	}
+	return sanitized
+}
+
+// sanitizeKeyForAzure replaces the characters Azure doesn't allow in tag names, <>%&\?/, with
+// underscores and truncates the key to maxAzureTagKeyLength.
+func sanitizeKeyForAzure(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>%&\?/`, r) {
+			return '_'
+		}
+		return r
+	}, key)
+	if len(key) > maxAzureTagKeyLength {
+		key = key[:maxAzureTagKeyLength]
+	}
+	return key
+}
+
+// sanitizeValueForAzure truncates the value to maxAzureTagValueLength.
+func sanitizeValueForAzure(value string) string {
+	if len(value) > maxAzureTagValueLength {
+		value = value[:maxAzureTagValueLength]
+	}
+	return value
+}
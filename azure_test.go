@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAzureProviderID(t *testing.T) {
+	tests := []struct {
+		name              string
+		providerID        string
+		wantSubscription  string
+		wantResourceGroup string
+		wantVM            string
+		wantErr           bool
+	}{
+		{
+			name:              "valid provider ID",
+			providerID:        "azure:///subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/virtualMachines/vm-1",
+			wantSubscription:  "sub-1",
+			wantResourceGroup: "rg-1",
+			wantVM:            "vm-1",
+		},
+		{
+			name:              "lowercase segments",
+			providerID:        "azure:///subscriptions/sub-1/resourcegroups/mc_rg/providers/microsoft.compute/virtualmachines/vm-1",
+			wantSubscription:  "sub-1",
+			wantResourceGroup: "mc_rg",
+			wantVM:            "vm-1",
+		},
+		{
+			name:       "scale set instance",
+			providerID: "azure:///subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0",
+			wantErr:    true,
+		},
+		{
+			name:       "missing VM name",
+			providerID: "azure:///subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/virtualMachines/",
+			wantErr:    true,
+		},
+		{
+			name:       "not an Azure provider ID",
+			providerID: "aws:///us-east-1a/i-1234567890abcdef0",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription, resourceGroup, vm, err := parseAzureProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSubscription, subscription)
+			assert.Equal(t, tt.wantResourceGroup, resourceGroup)
+			assert.Equal(t, tt.wantVM, vm)
+		})
+	}
+}
+
+func TestSanitizeKeyForAzure(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "env", want: "env"},
+		{input: "topology.kubernetes.io/region", want: "topology.kubernetes.io_region"},
+		{input: `a<b>c%d&e\f?g`, want: "a_b_c_d_e_f_g"},
+		{input: strings.Repeat("k", 600), want: strings.Repeat("k", maxAzureTagKeyLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeKeyForAzure(tt.input))
+		})
+	}
+}
+
+func TestSanitizeValueForAzure(t *testing.T) {
+	assert.Equal(t, "prod/us-east", sanitizeValueForAzure("prod/us-east"))
+	assert.Equal(t, strings.Repeat("v", maxAzureTagValueLength), sanitizeValueForAzure(strings.Repeat("v", 300)))
+}
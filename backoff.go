@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// defaultCloudRetryBaseDelay is the requeue delay of the first reconcile of a node failing
+	// with a retryable cloud API error. It doubles with every consecutive failure, up to
+	// maxCloudRetryDelay.
+	defaultCloudRetryBaseDelay = 5 * time.Second
+	maxCloudRetryDelay         = 5 * time.Minute
+
+	// maxCloudCallRetries caps --max-retries: with the capped backoff more retries would only
+	// hold a reconcile worker on a failing API for hours
+	maxCloudCallRetries = 20
+
+	// maxMissingProviderIDRequeue caps the requeue delay of nodes without a provider ID
+	maxMissingProviderIDRequeue = 5 * time.Minute
+)
+
+// retryCloudCall calls fn, an API call of cloud, until it succeeds, fails with an error that
+// isn't retryable or maxRetries retries are used up. Retries are delayed by an exponential
+// backoff from baseDelay up to maxDelay, with full jitter.
+func retryCloudCall(ctx context.Context, cloud string, maxRetries int, baseDelay, maxDelay time.Duration, retryable func(error) bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxRetries || !retryable(err) {
+			return err
+		}
+
+		var delay time.Duration
+		if backoff := retryCallBackoff(attempt, baseDelay, maxDelay); backoff > 0 {
+			delay = rand.N(backoff)
+		}
+		ctrl.LoggerFrom(ctx).V(1).Info("Retrying cloud API call", "cloud", cloud, "attempt", attempt+1, "delay", delay, "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryCallBackoff returns the upper bound of the jittered delay of the retry after attempt:
+// baseDelay doubled attempt times, up to maxDelay. The shift is capped so it can't overflow.
+func retryCallBackoff(attempt int, baseDelay, maxDelay time.Duration) time.Duration {
+	if attempt >= 32 {
+		return maxDelay
+	}
+	return min(baseDelay<<attempt, maxDelay)
+}
+
+// isRetryableCloudError returns whether err was caused by a cloud API error worth retrying
+// later: throttling and server errors. Other errors, eg: permission errors, are permanent.
+func isRetryableCloudError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if awsRetryables.IsErrorRetryable(err) == aws.TrueTernary {
+		return true
+	}
+	return isRetryableGCPError(err) || isRetryableDOError(err) || isRetryableOCIError(err) || isRetryableOpenStackError(err)
+}
+
+// isCloudNotFoundError returns whether err was caused by a cloud API error of a missing
+// instance, eg: one deleted along with its node.
+func isCloudNotFoundError(err error) bool {
+	return isNotFoundAWSError(err) || isNotFoundGCPError(err) || isNotFoundAzureError(err) ||
+		isNotFoundDOError(err) || isNotFoundOCIError(err) || isNotFoundOpenStackError(err)
+}
+
+// retryBackoff returns the requeue delay of node after another reconcile failed with a retryable
+// cloud API error: an exponential backoff of its consecutive failures, with jitter so throttled
+// nodes don't retry in lockstep.
+func (r *NodeLabelController) retryBackoff(node string) time.Duration {
+	baseDelay := r.cloudRetryBaseDelay
+	if baseDelay == 0 {
+		baseDelay = defaultCloudRetryBaseDelay
+	}
+
+	attempts := 0
+	if v, ok := r.retryAttempts.Load(node); ok {
+		attempts = v.(int)
+	}
+	r.retryAttempts.Store(node, attempts+1)
+
+	backoff := maxCloudRetryDelay
+	if attempts < 32 {
+		backoff = min(baseDelay<<attempts, maxCloudRetryDelay)
+	}
+	// half of the backoff is jitter, so the delay never drops to zero
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// missingProviderIDBackoff returns the requeue delay of node after another reconcile found it
+// without a provider ID: MissingProviderIDRequeue, doubled with every consecutive reconcile up to
+// maxMissingProviderIDRequeue, and the number of previous consecutive reconciles. The delay is 0
+// when MissingProviderIDRequeue is.
+func (r *NodeLabelController) missingProviderIDBackoff(node string) (time.Duration, int) {
+	attempts := 0
+	if v, ok := r.missingProviderIDAttempts.Load(node); ok {
+		attempts = v.(int)
+	}
+	r.missingProviderIDAttempts.Store(node, attempts+1)
+
+	if r.MissingProviderIDRequeue == 0 {
+		return 0, attempts
+	}
+	maxDelay := max(r.MissingProviderIDRequeue, maxMissingProviderIDRequeue)
+	if attempts >= 32 {
+		return maxDelay, attempts
+	}
+	return min(r.MissingProviderIDRequeue<<attempts, maxDelay), attempts
+}
+
+// resetRetryBackoff forgets the consecutive failures of node.
+func (r *NodeLabelController) resetRetryBackoff(node string) {
+	r.retryAttempts.Delete(node)
+}
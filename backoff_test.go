@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsRetryableCloudError(t *testing.T) {
+	awsStatusError := func(code int) error {
+		return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: code}},
+			Err:      errors.New("request failed"),
+		}}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"aws throttling", &smithy.GenericAPIError{Code: "RequestLimitExceeded"}, true},
+		{"aws server error", awsStatusError(http.StatusServiceUnavailable), true},
+		{"aws permission error", &smithy.GenericAPIError{Code: "UnauthorizedOperation"}, false},
+		{"wrapped aws throttling", fmt.Errorf("failed to create AWS tags: %w", &smithy.GenericAPIError{Code: "Throttling"}), true},
+		{"gcp rate limit", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"gcp server error", fmt.Errorf("failed to get GCP instance: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+		{"gcp fingerprint conflict", &googleapi.Error{Code: http.StatusPreconditionFailed}, false},
+		{"gcp quota exceeded", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{"gcp permission denied", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+		{"joined", errors.Join(errors.New("invalid"), &googleapi.Error{Code: http.StatusServiceUnavailable}), true},
+		{"unexpected", errors.New("invalid AWS provider ID format"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryableCloudError(tt.err))
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := &NodeLabelController{cloudRetryBaseDelay: time.Second}
+
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		backoff := r.retryBackoff("node1")
+		assert.GreaterOrEqual(t, backoff, want/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, backoff, want, "attempt %d", attempt)
+	}
+
+	// other nodes back off independently
+	assert.LessOrEqual(t, r.retryBackoff("node2"), time.Second)
+
+	for range 100 {
+		r.retryBackoff("node1")
+	}
+	assert.LessOrEqual(t, r.retryBackoff("node1"), maxCloudRetryDelay)
+
+	r.resetRetryBackoff("node1")
+	assert.LessOrEqual(t, r.retryBackoff("node1"), time.Second)
+}
+
+func TestReconcileGCPRetryableError(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	newController := func(setLabelsErr error) (*NodeLabelController, *mockGCEClient) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+		mock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}, setLabelsErr: setLabelsErr}
+		return &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, cloudRetryBaseDelay: time.Second}, mock
+	}
+
+	t.Run("rate limited", func(t *testing.T) {
+		r, mock := newController(&googleapi.Error{Code: http.StatusTooManyRequests, Message: "Rate Limit Exceeded"})
+
+		// consecutive failures back off exponentially
+		res, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, res.RequeueAfter, time.Second)
+		res, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, res.RequeueAfter, time.Second)
+
+		// the requeued failures are still recorded
+		assert.Contains(t, r.nodeErrors.errors["node1"], "Rate Limit Exceeded")
+		_, total := r.summary.snapshot()
+		assert.Equal(t, 2, total.Errors)
+
+		// a success resets the backoff
+		mock.setLabelsErr = nil
+		res, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		_, ok := r.retryAttempts.Load("node1")
+		assert.False(t, ok)
+		assert.NotContains(t, r.nodeErrors.errors, "node1")
+	})
+
+	t.Run("permission denied", func(t *testing.T) {
+		r, _ := newController(&googleapi.Error{Code: http.StatusForbidden, Message: "Required 'compute.instances.setLabels' permission"})
+
+		res, err := r.Reconcile(context.Background(), req)
+		require.ErrorContains(t, err, "compute.instances.setLabels")
+		assert.Zero(t, res.RequeueAfter)
+	})
+}
+
+func TestRetryCallBackoff(t *testing.T) {
+	assert.Equal(t, 200*time.Millisecond, retryCallBackoff(0, 200*time.Millisecond, 20*time.Second))
+	assert.Equal(t, 800*time.Millisecond, retryCallBackoff(2, 200*time.Millisecond, 20*time.Second))
+
+	// large attempt counts would overflow the shift, to negative or zero delays
+	for _, attempt := range []int{10, 36, 57, 100} {
+		assert.Equal(t, 20*time.Second, retryCallBackoff(attempt, 200*time.Millisecond, 20*time.Second), attempt)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultClusterNameLabel is the node label consulted for the cluster name when no
+// static name is configured.
+const defaultClusterNameLabel = "cluster-name"
+
+// clusterNameResolver determines the name of the cluster a node belongs to. Sources are
+// consulted in order:
+//
+//  1. Name, the static name from the --cluster-name flag
+//  2. the node's Label label (eg: "cluster-name")
+//  3. the UID of the kube-system namespace, which is unique per cluster and never changes
+type clusterNameResolver struct {
+	// Reader is used to look up the kube-system namespace. It should be an uncached
+	// reader (mgr.GetAPIReader()) to avoid starting an informer on all namespaces.
+	Reader client.Reader
+
+	// Name is a static cluster name. Takes precedence over all other sources.
+	Name string
+
+	// Label is a node label key to read the cluster name from.
+	Label string
+
+	mu            sync.Mutex
+	kubeSystemUID string
+}
+
+// Resolve returns the cluster name for the given node.
+func (c *clusterNameResolver) Resolve(ctx context.Context, node *corev1.Node) (string, error) {
+	if c.Name != "" {
+		return c.Name, nil
+	}
+
+	if c.Label != "" && node != nil {
+		if name := node.Labels[c.Label]; name != "" {
+			return name, nil
+		}
+	}
+
+	return c.resolveKubeSystemUID(ctx)
+}
+
+// resolveKubeSystemUID fetches the kube-system namespace UID once and caches it.
+func (c *clusterNameResolver) resolveKubeSystemUID(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.kubeSystemUID != "" {
+		return c.kubeSystemUID, nil
+	}
+
+	if c.Reader == nil {
+		return "", fmt.Errorf("no cluster name configured and no client available to read the kube-system namespace")
+	}
+
+	var ns corev1.Namespace
+	if err := c.Reader.Get(ctx, client.ObjectKey{Name: "kube-system"}, &ns); err != nil {
+		return "", fmt.Errorf("failed to fetch kube-system namespace: %v", err)
+	}
+	if ns.UID == "" {
+		return "", fmt.Errorf("kube-system namespace has no UID")
+	}
+
+	c.kubeSystemUID = string(ns.UID)
+	return c.kubeSystemUID, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClusterNameResolver(t *testing.T) {
+	kubeSystem := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "kube-system",
+			UID:  "6f2b1c1e-5b7a-4c1e-9f3e-0d6b6a1c2e3f",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		resolver *clusterNameResolver
+		node     *corev1.Node
+		objects  []client.Object
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "static name takes precedence",
+			resolver: &clusterNameResolver{Name: "prod-us-east", Label: defaultClusterNameLabel},
+			node:     createNode("node1", map[string]string{"cluster-name": "from-label"}, ""),
+			objects:  []client.Object{kubeSystem},
+			want:     "prod-us-east",
+		},
+		{
+			name:     "node label",
+			resolver: &clusterNameResolver{Label: defaultClusterNameLabel},
+			node:     createNode("node1", map[string]string{"cluster-name": "from-label"}, ""),
+			objects:  []client.Object{kubeSystem},
+			want:     "from-label",
+		},
+		{
+			name:     "kube-system UID fallback when label is missing",
+			resolver: &clusterNameResolver{Label: defaultClusterNameLabel},
+			node:     createNode("node1", nil, ""),
+			objects:  []client.Object{kubeSystem},
+			want:     "6f2b1c1e-5b7a-4c1e-9f3e-0d6b6a1c2e3f",
+		},
+		{
+			name:     "kube-system UID when label lookup is disabled",
+			resolver: &clusterNameResolver{},
+			node:     createNode("node1", map[string]string{"cluster-name": "from-label"}, ""),
+			objects:  []client.Object{kubeSystem},
+			want:     "6f2b1c1e-5b7a-4c1e-9f3e-0d6b6a1c2e3f",
+		},
+		{
+			name:     "missing kube-system namespace",
+			resolver: &clusterNameResolver{},
+			node:     createNode("node1", nil, ""),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			tt.resolver.Reader = fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(tt.objects...).
+				Build()
+
+			got, err := tt.resolver.Resolve(context.Background(), tt.node)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClusterNameResolverCachesKubeSystemUID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	k8s := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "uid-1"}}).
+		Build()
+
+	resolver := &clusterNameResolver{Reader: k8s}
+	got, err := resolver.Resolve(context.Background(), createNode("node1", nil, ""))
+	require.NoError(t, err)
+	assert.Equal(t, "uid-1", got)
+
+	// the namespace going away must not affect an already resolved UID
+	require.NoError(t, k8s.Delete(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}}))
+
+	got, err = resolver.Resolve(context.Background(), createNode("node1", nil, ""))
+	require.NoError(t, err)
+	assert.Equal(t, "uid-1", got)
+}
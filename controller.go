@@ -2,292 +2,2614 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"maps"
+	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
+// defaultDisabledAnnotation is the node annotation opting a node out of tagging by default
+const defaultDisabledAnnotation = "node-tagger.planetscale.com/disabled"
+
+// cloudAuto is the Cloud detecting the cloud of each node from its provider ID
+const cloudAuto = "auto"
+
+// cloudUnknown counts the reconciles of nodes whose cloud isn't known with cloudAuto, eg: without
+// a provider ID, in the summary
+const cloudUnknown = "unknown"
+
+// maxCleanupFinalizerHold bounds how long a failing cleanup holds the deletion of a node with
+// CleanupFinalizer, so a permanent cloud API error doesn't block the deletion forever
+const maxCleanupFinalizerHold = 15 * time.Minute
+
+// supportedClouds are the clouds whose instances can be tagged
+var supportedClouds = []string{"aws", "gcp", "azure", "do", "oci", "openstack"}
+
 type NodeLabelController struct {
 	client.Client
-	EC2Client ec2Client
-	GCEClient gceClient
+	EC2Client       ec2Client
+	AzureClient     azureClient
+	GCEClient       gceClient
+	DOClient        doClient
+	OCIClient       ociClient
+	OpenStackClient openstackClient
+
+	// Labels is a list of label keys to sync from the node to the cloud provider
+	Labels []string
+
+	// CloudLabels overrides Labels with the label keys to sync for nodes of a cloud, eg: to sync
+	// a different key set to AWS than to GCP.
+	CloudLabels map[string][]string
+
+	// LabelRegex selects more label keys to sync, eg: ^example\.com/. Like the keys matched by a
+	// label key pattern, they're tagged under their own key. Only supported on AWS and GCP.
+	LabelRegex *regexp.Regexp
+
+	// Annotations is a list of annotation keys to sync from the node to the cloud provider
+	Annotations []string
+
+	// Cloud is the cloud provider (aws, gcp, azure, do, oci or openstack), or auto to detect the cloud of each node
+	// from its provider ID
+	Cloud string
+
+	// AutoClouds restricts the clouds tagged with Cloud auto, eg: aws and gcp in a hybrid
+	// cluster. All supportedClouds are tagged when empty.
+	AutoClouds []string
+
+	// KeyAliases maps Kubernetes label keys to the cloud tag key they're written as, eg:
+	// topology.kubernetes.io/region -> region. Unmapped keys are written as-is.
+	KeyAliases map[string]string
+
+	// CloudKeyAliases maps Kubernetes label keys to the cloud tag key they're written as on nodes
+	// of a cloud, by cloud. They take precedence over KeyAliases.
+	CloudKeyAliases map[string]map[string]string
+
+	// StripKeyPrefixes and StripKeySuffixes are removed from label and annotation keys before
+	// they're written as tag keys, eg: to drop boilerplate like "-managed". Only the first
+	// matching prefix and suffix are removed.
+	StripKeyPrefixes []string
+	StripKeySuffixes []string
+
+	// StripValuePrefixes and StripValueSuffixes are removed from label and annotation values
+	StripValuePrefixes []string
+	StripValueSuffixes []string
+
+	// ClusterNameTag is the cloud tag key to stamp with the node's cluster name. Disabled when empty.
+	ClusterNameTag string
+
+	// TagPrefix is prepended to every cloud tag key written by the controller, eg: "k8s:" to tell
+	// them apart from the tags of other tools. Only prefixed keys are managed.
+	TagPrefix string
+
+	// TagRegionFromProviderID stamps the node's region, from its topology.kubernetes.io/region
+	// label or else derived from the zone of its AWS or GCP provider ID.
+	TagRegionFromProviderID bool
+
+	// NodeUIDTag is the cloud tag key to stamp with the node's metadata.uid, eg: to correlate
+	// instances with nodes in external systems. Disabled when empty.
+	NodeUIDTag string
+
+	// NodeNameTag is the cloud tag key to stamp with the node's name, eg: to cross-reference
+	// instances with nodes. Disabled when empty.
+	NodeNameTag string
+
+	// TagTemplates are tags whose values combine several labels and annotations of the node.
+	// TagTemplateMissing is the behavior of templates referencing a key missing from the node:
+	// "skip" (the default) or "empty".
+	TagTemplates       []tagTemplate
+	TagTemplateMissing string
+
+	// ClusterName resolves the cluster name written to ClusterNameTag
+	ClusterName *clusterNameResolver
+
+	// DryRun computes and logs the tag changes of all nodes without applying them
+	DryRun bool
+
+	// SampleRate is the fraction (0, 1) of nodes, selected deterministically by name, whose
+	// tags are actually written. Changes for the remaining nodes are only logged. Values
+	// outside (0, 1) disable sampling.
+	SampleRate float64
+
+	// AZToRegion derives an instance's AWS region from the availability zone in its provider ID.
+	// When set, instances are tagged with an EC2 client for their own region created by
+	// NewEC2Client, so one controller can tag instances across regions. EC2Client is used when
+	// AZToRegion is nil or the provider ID has no availability zone.
+	AZToRegion func(az string) (string, error)
+
+	// AWSRegion overrides the region of the default EC2 client, eg: when it can't be detected
+	// through IMDS. The SDK's region detection is used when empty.
+	AWSRegion string
+
+	// AWSEndpointURL overrides the endpoint of the EC2 clients, eg: LocalStack in tests. The
+	// SDK's endpoint resolution is used when empty.
+	AWSEndpointURL string
+
+	// AWSAssumeRoleARN is the ARN of an IAM role assumed through STS to tag the EC2 instances,
+	// eg: in another account. The default credentials are used when empty.
+	AWSAssumeRoleARN string
+
+	// AWSExternalID is the external ID passed when assuming AWSAssumeRoleARN, if the role's trust
+	// policy requires one.
+	AWSExternalID string
+
+	// AWSTagApplyOrder are tag keys created one CreateTags call each, in order, before the other
+	// tags, eg: for ABAC policies that require some tags to exist before others can be set.
+	AWSTagApplyOrder []string
+
+	// AWSMaxRetries is the number of times an AWS API call failing with a throttling or server
+	// error is retried, with exponential backoff.
+	AWSMaxRetries int
+
+	// AWSDescribeInstances reads the tags of an instance with DescribeInstances rather than
+	// DescribeTags. A single call then also returns the instance's volume and network interface
+	// IDs.
+	AWSDescribeInstances bool
+
+	// AWSTagEBSVolumes also syncs the managed tags to the EBS volumes attached to AWS instances
+	AWSTagEBSVolumes bool
+
+	// AWSTagENIs also syncs the managed tags to the network interfaces attached to AWS instances
+	AWSTagENIs bool
+
+	// GCPMaxRetries is the number of times a GCE API call failing with a rate limit, quota or
+	// server error is retried, with exponential backoff.
+	GCPMaxRetries int
+
+	// awsRetryBaseDelay overrides defaultAWSRetryBaseDelay when set, eg: in tests
+	awsRetryBaseDelay time.Duration
+
+	// gcpRetryBaseDelay overrides defaultGCPRetryBaseDelay when set, eg: in tests
+	gcpRetryBaseDelay time.Duration
+
+	// cloudRetryBaseDelay overrides defaultCloudRetryBaseDelay when set, eg: in tests
+	cloudRetryBaseDelay time.Duration
+
+	// NewEC2Client creates an EC2 client for a region. SetupCloudProvider sets it when nil.
+	NewEC2Client func(region string) ec2Client
+
+	// GCPSkipNonRunning skips label updates of GCE instances that aren't RUNNING, eg: TERMINATED
+	// or SUSPENDED instances.
+	GCPSkipNonRunning bool
+
+	// ConsolidateDuplicateKeys deletes AWS tags whose key only differs in case from a managed
+	// key, eg: Env next to env, keeping the managed key. GCP label keys are always lowercase.
+	ConsolidateDuplicateKeys bool
+
+	// GCPOverwriteUnmanaged allows overwriting and deleting existing unmanaged GCE labels that a
+	// managed key is mapped onto by sanitizing, eg: a team-name label for the team.name key.
+	GCPOverwriteUnmanaged bool
+
+	// GCPProjectAnnotation, GCPZoneAnnotation and GCPInstanceAnnotation name node annotations that
+	// override the project, zone and instance name parsed from a GCP node's provider ID, eg: when
+	// the provider ID is unreliable. Ignored when empty.
+	GCPProjectAnnotation  string
+	GCPZoneAnnotation     string
+	GCPInstanceAnnotation string
+
+	// GCPLabelDisks also syncs the managed labels to the zonal disks attached to GCP instances
+	GCPLabelDisks bool
+
+	// CleanupOnDelete removes the managed tags from a node's instance when the node is deleted,
+	// eg: so instances that are reused don't keep a stale node's tags.
+	CleanupOnDelete bool
+
+	// CleanupFinalizer, when set with CleanupOnDelete, is added to nodes so their deletion waits
+	// for the cleanup of their instance, even when the controller isn't running as they're
+	// deleted. Nodes are not modified when empty.
+	CleanupFinalizer string
+
+	// Recorder records events on nodes when their tags are changed or fail to sync. No events are
+	// recorded when nil.
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is the maximum number of nodes reconciled concurrently. Each reconcile
+	// calls the cloud API, so its rate limits (and CloudRateLimiter) should be considered when
+	// raising it. Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// ResyncPeriod is the cache's resync period. When set, the update events of periodic resyncs,
+	// which replay nodes that didn't change, are reconciled too, so drifted tags are corrected
+	// every period. Otherwise they're filtered out like other updates not changing a monitored key.
+	ResyncPeriod time.Duration
+
+	// CloudRateLimiter limits the rate of tag syncs through the cloud provider APIs. It's shared by
+	// all reconciles, event-driven or from the sweep. No limit when nil.
+	CloudRateLimiter *rate.Limiter
+
+	// MissingProviderIDRequeue is how long to wait before reconciling a node without a provider ID
+	// again, eg: a new node whose provider ID isn't set by the cloud controller manager yet. It
+	// doubles with every consecutive reconcile, up to maxMissingProviderIDRequeue. Such nodes are
+	// only reconciled on their next event when 0.
+	MissingProviderIDRequeue time.Duration
+
+	// OnDuplicateProviderID is how nodes whose provider ID references the same instance as
+	// another node's are reconciled: newest, the default, only reconciles the most recently seen
+	// node, skip reconciles none of them and error fails their reconciles.
+	OnDuplicateProviderID string
+
+	// DisabledAnnotation is the key of a node annotation opting the node out of tagging when set
+	// to true, eg: for nodes whose tags are managed by another process. Their tags are neither
+	// synced nor cleaned up. Disabled when empty.
+	DisabledAnnotation string
+
+	// NodeSelector restricts tagging to the nodes whose labels it matches, eg: node-pool=batch.
+	// The other nodes are ignored like disabled nodes. All nodes are tagged when nil.
+	NodeSelector labels.Selector
+
+	// MissingValue, when set, is written as the tag value of the labels and annotations missing
+	// from a node instead of deleting their tags, eg: unknown.
+	MissingValue string
+
+	// StaticTags are tags applied to every instance, with TagPrefix prepended to their keys. They
+	// take precedence over label and annotation tags of the same key.
+	StaticTags map[string]string
+
+	// ManagedByTag is the key of a tag listing the tag keys the controller wrote to an instance.
+	// The listed tags are still managed, and deleted, after their keys are removed from the
+	// configuration. Not supported on GCP, whose label values can't hold the list. Disabled when
+	// empty.
+	ManagedByTag string
+
+	// ReconcileRateLimiter limits the rate of all reconciles, including those of nodes that don't
+	// need a tag sync, to protect the Kubernetes and cloud provider APIs during mass events. No
+	// limit when nil.
+	ReconcileRateLimiter *rate.Limiter
+
+	// Sink receives the tag updates. The cloud provider APIs are used when nil.
+	Sink tagSink
+
+	// HTTPClient overrides the HTTP client used by the cloud SDKs, eg: for custom timeouts or an
+	// egress proxy. The SDK defaults are used when nil. The AWS SDK does not support a custom
+	// client together with AWS_CA_BUNDLE.
+	HTTPClient *http.Client
+
+	// GCPClientOptions are additional options used when creating the GCE client, or with
+	// GCPImpersonateServiceAccount the client impersonating the service account
+	GCPClientOptions []option.ClientOption
+
+	// GCPImpersonateServiceAccount is the email of a service account impersonated to label the
+	// GCE instances, eg: when the workload identity isn't granted the permissions directly. The
+	// default credentials are used when empty.
+	GCPImpersonateServiceAccount string
+
+	// CredentialsRecheckInterval, when positive, is how often CredentialsCheck probes the
+	// credentials again once verified.
+	CredentialsRecheckInterval time.Duration
+
+	// TwoPhaseDeleteInterval, when positive, delays deleting a managed tag until it has been
+	// observed as "should delete" on two reconciles at least this far apart.
+	TwoPhaseDeleteInterval time.Duration
+
+	// pendingDeletes tracks the deletions awaiting confirmation for TwoPhaseDeleteInterval
+	pendingDeletes pendingDeletes
+
+	// ownership tracks the tags written by the controller, to detect collisions with unmanaged tags
+	ownership tagOwnership
+
+	// awsInstanceCache and gceInstanceCache hold the cloud state preloaded by PreloadCloudState
+	awsInstanceCache preloadCache[*awsInstance]
+	gceInstanceCache preloadCache[*gce.Instance]
+
+	// preloaded is closed once the preload runnable finished, reconciles wait for it when set
+	preloaded chan struct{}
+
+	// providerIDIndexed is set once providerIDIndex is registered with the manager's cache
+	providerIDIndexed bool
+
+	// nodeErrors exports the last reconcile error of failing nodes as a metric
+	nodeErrors nodeErrorTracker
+
+	// summary counts the reconciles and tag changes, logged at shutdown
+	summary syncSummary
+
+	// instanceLocks serializes reconciles of nodes that share a cloud instance. controller-runtime
+	// already guarantees a single in-flight reconcile per node name.
+	instanceLocks keyedMutex
+
+	// providerIDs records the last seen provider ID of each node, to detect provider ID changes
+	providerIDs sync.Map
+
+	// finalized records the nodes whose instance was cleaned up before their CleanupFinalizer
+	// was removed, so their delete event doesn't clean it up again
+	finalized sync.Map
+
+	// instanceNodes records the last node reconciled for each instance key, to detect nodes
+	// sharing an instance.
+	instanceNodes sync.Map
+
+	// cloudReady is set once SetupCloudProvider succeeded
+	cloudReady atomic.Bool
+
+	// credentialProbes verify the credentials of the clouds set up, by cloud, for
+	// CredentialsCheck. credentialsVerified is set once one of them succeeded.
+	credentialProbes    map[string]func(context.Context) error
+	credentialsVerified atomic.Bool
+
+	// credentialsMu guards the state of the re-checks of verified credentials: when they were
+	// last probed, the consecutive failed probes and the error CredentialsCheck reports.
+	credentialsMu        sync.Mutex
+	credentialsCheckedAt time.Time
+	credentialFailures   int
+	credentialsErr       error
+
+	// regionalEC2Clients caches the EC2 clients created by NewEC2Client by region
+	regionalEC2Clients sync.Map
+
+	// retryAttempts counts the consecutive reconciles of each node that failed with a retryable
+	// cloud API error, to back off their requeues
+	retryAttempts sync.Map
+
+	// missingProviderIDAttempts counts the consecutive reconciles of each node without a provider
+	// ID, to back off their requeues
+	missingProviderIDAttempts sync.Map
+}
+
+func (r *NodeLabelController) SetupCloudProvider(ctx context.Context) error {
+	if err := r.validateManagedByTag(); err != nil {
+		return err
+	}
+	if r.handlesCloud("aws") {
+		if err := r.validateAWSTagKeys(); err != nil {
+			return err
+		}
+	}
+	if r.handlesCloud("gcp") {
+		if collisions := gcpKeyCollisions(r.managedKeys("gcp")); len(collisions) > 0 {
+			ctrl.LoggerFrom(ctx).Info("Label keys collide once sanitized for GCP, they're suffixed with a hash of the original key", "keys", collisions)
+		}
+	}
+
+	if r.Cloud != cloudAuto {
+		if err := r.setupCloud(ctx, r.Cloud); err != nil {
+			return err
+		}
+		r.cloudReady.Store(true)
+		return nil
+	}
+
+	// clouds whose client can't be set up, eg: for lack of credentials, are skipped and the
+	// reconciles of their nodes fail
+	var errs []error
+	clouds := r.clouds()
+	for _, cloud := range clouds {
+		if err := r.setupCloud(ctx, cloud); err != nil {
+			ctrl.LoggerFrom(ctx).Info("Unable to set up cloud provider, its nodes won't be tagged", "cloud", cloud, "reason", err.Error())
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(clouds) {
+		return errors.Join(errs...)
+	}
+	r.cloudReady.Store(true)
+	return nil
+}
+
+// setupCloud creates the client of cloud.
+func (r *NodeLabelController) setupCloud(ctx context.Context, cloud string) error {
+	switch cloud {
+	case "aws":
+		var opts []func(*awsconfig.LoadOptions) error
+		if r.HTTPClient != nil {
+			opts = append(opts, awsconfig.WithHTTPClient(r.HTTPClient))
+		}
+		if r.AWSRegion != "" {
+			opts = append(opts, awsconfig.WithRegion(r.AWSRegion))
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("unable to load AWS config: %v", err)
+		}
+		if r.AWSAssumeRoleARN != "" {
+			provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), r.AWSAssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+				if r.AWSExternalID != "" {
+					o.ExternalID = aws.String(r.AWSExternalID)
+				}
+			})
+			cfg.Credentials = aws.NewCredentialsCache(provider)
+		}
+		// EC2 calls are retried by retryAWS, up to MaxRetries times, rather than by the SDK
+		ec2Options := func(o *ec2.Options) {
+			o.Retryer = aws.NopRetryer{}
+			if r.AWSEndpointURL != "" {
+				o.BaseEndpoint = aws.String(r.AWSEndpointURL)
+			}
+		}
+		r.EC2Client = ec2.NewFromConfig(cfg, ec2Options)
+		r.setCredentialProbe(cloud, func(ctx context.Context) error { return probeAWSCredentials(ctx, r.EC2Client) })
+		if r.NewEC2Client == nil {
+			r.NewEC2Client = func(region string) ec2Client {
+				return ec2.NewFromConfig(cfg, ec2Options, func(o *ec2.Options) { o.Region = region })
+			}
+		}
+	case "gcp":
+		// the GCE client and the credentials probe share the token source, so readiness checks
+		// the credentials and the network path of the tag updates
+		var ts oauth2.TokenSource
+		if r.GCPImpersonateServiceAccount != "" {
+			var err error
+			ts, err = newGCPImpersonationTokenSource(ctx, r.HTTPClient, r.GCPImpersonateServiceAccount, r.GCPClientOptions...)
+			if err != nil {
+				return fmt.Errorf("unable to impersonate GCP service account %q: %v", r.GCPImpersonateServiceAccount, err)
+			}
+		} else {
+			creds, err := newGCPCredentials(ctx, r.HTTPClient, r.GCPClientOptions...)
+			if err != nil {
+				return fmt.Errorf("unable to load GCP credentials: %v", err)
+			}
+			ts = creds.TokenSource
+		}
+		opts := append(slices.Clone(r.GCPClientOptions), option.WithHTTPClient(newTokenHTTPClient(r.HTTPClient, ts)))
+		c, err := gce.NewService(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("unable to create GCP client: %v", err)
+		}
+		r.GCEClient = newGCEComputeClient(c)
+		r.setCredentialProbe(cloud, func(context.Context) error { return probeGCPCredentials(ts) })
+	case "azure":
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return fmt.Errorf("unable to load Azure credentials: %v", err)
+		}
+		opts := &arm.ClientOptions{}
+		if r.HTTPClient != nil {
+			opts.ClientOptions = policy.ClientOptions{Transport: r.HTTPClient}
+		}
+		// the tags client is only used with resource ID scopes, which include the subscription
+		c, err := armresources.NewTagsClient("", cred, opts)
+		if err != nil {
+			return fmt.Errorf("unable to create Azure client: %v", err)
+		}
+		r.AzureClient = newAzureTagsClient(c)
+		r.setCredentialProbe(cloud, func(ctx context.Context) error { return probeAzureCredentials(ctx, cred) })
+	case "do":
+		token := os.Getenv(doTokenEnv)
+		if token == "" {
+			return fmt.Errorf("unable to load DigitalOcean credentials: %s is not set", doTokenEnv)
+		}
+		c, err := newDOAPIClient(r.HTTPClient, token)
+		if err != nil {
+			return fmt.Errorf("unable to create DigitalOcean client: %v", err)
+		}
+		r.DOClient = c
+		r.setCredentialProbe(cloud, c.checkAccount)
+	case "oci":
+		provider, err := loadOCIConfigProvider()
+		if err != nil {
+			return fmt.Errorf("unable to load OCI config: %v", err)
+		}
+		c, err := newOCIAPIClient(r.HTTPClient, provider)
+		if err != nil {
+			return fmt.Errorf("unable to create OCI client: %v", err)
+		}
+		r.OCIClient = c
+		r.setCredentialProbe(cloud, c.checkTenancy)
+	case "openstack":
+		auth, err := loadOpenStackAuth()
+		if err != nil {
+			return fmt.Errorf("unable to load OpenStack credentials: %v", err)
+		}
+		c := newOpenStackAPIClient(r.HTTPClient, auth)
+		r.OpenStackClient = c
+		r.setCredentialProbe(cloud, c.checkToken)
+	default:
+		return fmt.Errorf("unsupported cloud provider: %q", cloud)
+	}
+	return nil
+}
+
+// validateAWSTagKeys returns an error when any of the managed AWS tag keys starts with the aws:
+// prefix reserved by AWS.
+func (r *NodeLabelController) validateAWSTagKeys() error {
+	var reserved []string
+	for _, k := range r.managedKeys("aws") {
+		if isReservedAWSTagKey(k) {
+			reserved = append(reserved, k)
+		}
+	}
+	if len(reserved) > 0 {
+		return fmt.Errorf("tag keys %q use the reserved AWS prefix %q", reserved, reservedAWSTagPrefix)
+	}
+	return nil
+}
+
+// validateManagedByTag returns an error when the managed tag keys can't be listed in the
+// ManagedByTag of instances of the configured clouds.
+func (r *NodeLabelController) validateManagedByTag() error {
+	if r.ManagedByTag == "" {
+		return nil
+	}
+	if r.handlesCloud("gcp") {
+		return fmt.Errorf("the managed-by tag is not supported on GCP, label values can't list the managed keys")
+	}
+	if r.handlesCloud("do") {
+		return fmt.Errorf("the managed-by tag is not supported on DigitalOcean, tags can't list the managed keys")
+	}
+
+	for _, cloud := range r.clouds() {
+		keys := slices.DeleteFunc(r.managedKeys(cloud), func(k string) bool { return k == r.managedByTagKey() })
+		for _, k := range keys {
+			if strings.ContainsFunc(k, unicode.IsSpace) {
+				return fmt.Errorf("tag key %q can't be listed in the managed-by tag", k)
+			}
+		}
+		// Nova metadata values are a character shorter than the tag values of the other clouds
+		limit := maxManagedByValueLength
+		if cloud == "openstack" {
+			limit = maxOpenStackMetadataLength
+		}
+		if v := managedByValue(keys); len(v) > limit {
+			return fmt.Errorf("the managed tag keys exceed the %d characters of the managed-by tag: %q", limit, v)
+		}
+	}
+	return nil
+}
+
+// ReadyzCheck is a readiness check that fails until SetupCloudProvider succeeded, unless the tag
+// updates go to a Sink rather than the cloud provider APIs.
+func (r *NodeLabelController) ReadyzCheck(_ *http.Request) error {
+	if r.Sink == nil && !r.cloudReady.Load() {
+		return fmt.Errorf("cloud provider %q is not set up", r.Cloud)
+	}
+	return nil
+}
+
+// newTokenHTTPClient returns a copy of base, or else of the default client, authenticating its
+// requests with the OAuth tokens of ts.
+func newTokenHTTPClient(base *http.Client, ts oauth2.TokenSource) *http.Client {
+	hc := &http.Client{}
+	if base != nil {
+		*hc = *base
+	}
+	hc.Transport = &oauth2.Transport{Source: ts, Base: hc.Transport}
+	return hc
+}
+
+// newCloudHTTPClient returns an HTTP client for the cloud SDKs with the given request timeout
+// and proxy. It returns nil, meaning the SDK defaults, when neither is set.
+func newCloudHTTPClient(timeout time.Duration, proxyURL string) (*http.Client, error) {
+	if timeout == 0 && proxyURL == "" {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %v", proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// newRateLimiter returns a limiter of perSecond events without bursts, or nil for no limit.
+func newRateLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+func (r *NodeLabelController) SetupWithManager(mgr ctrl.Manager) error {
+	exportConfigInfo(r)
+	if err := mgr.Add(leaderRunnable{}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Node{}, providerIDIndex, r.indexProviderID); err != nil {
+		return fmt.Errorf("unable to index nodes by provider ID: %v", err)
+	}
+	r.providerIDIndexed = true
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}).
+		WithEventFilter(r.eventFilter()).
+		WithOptions(r.controllerOptions()).
+		Complete(r)
+}
+
+// eventFilter returns the predicate of the node events to reconcile. To reduce the number of
+// API calls to AWS and GCP, it filters out node events that do not involve changes to the
+// monitored label set (r.labels).
+func (r *NodeLabelController) eventFilter() predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			// ignored nodes are only reconciled to release their finalizer, and once no longer
+			// ignored
+			if r.ignoresNode(newNode) {
+				return r.isFinalizing(newNode)
+			}
+			if r.ResyncPeriod > 0 && oldNode.ResourceVersion == newNode.ResourceVersion {
+				return true
+			}
+			keys := append(r.monitoredLabels(), r.regexMatches(oldNode.Labels, newNode.Labels)...)
+			return shouldProcessNodeUpdate(oldNode, newNode, keys, r.monitoredAnnotations()) || r.isFinalizing(newNode) || r.ignoresNode(oldNode)
+		},
+
+		CreateFunc: func(e event.CreateEvent) bool {
+			node, ok := e.Object.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			if r.ignoresNode(node) {
+				return r.isFinalizing(node)
+			}
+			// static tags, missing values and the region are applied to every instance, even of
+			// nodes without monitored keys
+			keys := append(r.monitoredLabels(), r.regexMatches(node.Labels)...)
+			return shouldProcessNodeCreate(node, keys, r.monitoredAnnotations()) || r.isFinalizing(node) || len(r.StaticTags) > 0 || r.MissingValue != "" || r.TagRegionFromProviderID ||
+				(len(r.TagTemplates) > 0 && r.TagTemplateMissing == tagTemplateMissingEmpty)
+		},
+
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			node, ok := e.Object.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			return r.shouldProcessNodeDelete(node)
+		},
+
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// controllerOptions returns the options of the node controller.
+func (r *NodeLabelController) controllerOptions() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: max(r.MaxConcurrentReconciles, 1),
+	}
+}
+
+// shouldProcessNodeUpdate determines if a node update event should trigger reconciliation
+// based on whether any monitored labels or annotations have changed.
+func shouldProcessNodeUpdate(oldNode, newNode *corev1.Node, monitoredLabels, monitoredAnnotations []string) bool {
+	if oldNode == nil || newNode == nil {
+		return false
+	}
+
+	return anyKeyChanged(oldNode.Labels, newNode.Labels, monitoredLabels) ||
+		anyKeyChanged(oldNode.Annotations, newNode.Annotations, monitoredAnnotations)
+}
+
+// shouldProcessNodeCreate determines if a newly created node should trigger reconciliation
+// based on whether it has any of the monitored labels or annotations.
+func shouldProcessNodeCreate(node *corev1.Node, monitoredLabels, monitoredAnnotations []string) bool {
+	if node == nil {
+		return false
+	}
+
+	return hasAnyKey(node.Labels, monitoredLabels) || hasAnyKey(node.Annotations, monitoredAnnotations)
+}
+
+// shouldProcessNodeDelete determines if a node delete event should trigger the cleanup of the
+// node's instance. The node is gone from the cache by the time it's reconciled, so its provider
+// ID is recorded from the event.
+func (r *NodeLabelController) shouldProcessNodeDelete(node *corev1.Node) bool {
+	if !r.CleanupOnDelete || node == nil {
+		return false
+	}
+	if _, ok := r.finalized.LoadAndDelete(node.Name); ok {
+		return false
+	}
+	if r.ignoresNode(node) {
+		return false
+	}
+	providerID := r.providerID(node)
+	if providerID == "" {
+		return false
+	}
+
+	r.providerIDs.Store(node.Name, providerID)
+	return true
+}
+
+// isDisabled reports whether node opted out of tagging with DisabledAnnotation.
+func (r *NodeLabelController) isDisabled(node *corev1.Node) bool {
+	if r.DisabledAnnotation == "" {
+		return false
+	}
+	disabled, _ := strconv.ParseBool(node.Annotations[r.DisabledAnnotation])
+	return disabled
+}
+
+// selectsNode reports whether node matches NodeSelector.
+func (r *NodeLabelController) selectsNode(node *corev1.Node) bool {
+	return r.NodeSelector == nil || r.NodeSelector.Matches(labels.Set(node.Labels))
+}
+
+// ignoresNode reports whether node is left alone: disabled or not selected by NodeSelector.
+func (r *NodeLabelController) ignoresNode(node *corev1.Node) bool {
+	return r.isDisabled(node) || !r.selectsNode(node)
+}
+
+// nodeListOptions returns the options listing the nodes selected by NodeSelector.
+func (r *NodeLabelController) nodeListOptions() []client.ListOption {
+	if r.NodeSelector == nil {
+		return nil
+	}
+	return []client.ListOption{client.MatchingLabelsSelector{Selector: r.NodeSelector}}
+}
+
+// isFinalizing reports whether node is being deleted and waits on CleanupFinalizer.
+func (r *NodeLabelController) isFinalizing(node *corev1.Node) bool {
+	return r.CleanupOnDelete && r.CleanupFinalizer != "" && !node.DeletionTimestamp.IsZero() &&
+		controllerutil.ContainsFinalizer(node, r.CleanupFinalizer)
+}
+
+// anyKeyChanged reports whether any of keys was added, removed or changed value between old and
+// new. Key patterns cover all the keys they match.
+func anyKeyChanged(old, new map[string]string, keys []string) bool {
+	for _, k := range keys {
+		if isKeyPattern(k) {
+			if anyKeyChanged(old, new, matchingKeys(k, old, new)) {
+				return true
+			}
+			continue
+		}
+		newVal, newExists := new[k]
+		oldVal, oldExists := old[k]
+		if newExists != oldExists || (newExists && newVal != oldVal) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyKey reports whether m contains any of keys, or a key matching any of the key patterns.
+func hasAnyKey(m map[string]string, keys []string) bool {
+	for _, k := range keys {
+		if isKeyPattern(k) && len(matchingKeys(k, m)) > 0 {
+			return true
+		}
+		if _, ok := m[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *NodeLabelController) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	// tag every log line of this reconcile, including those emitted by the cloud sync
+	// methods via ctrl.LoggerFrom(ctx), with a short ID so a single pass can be traced.
+	logger := ctrl.LoggerFrom(ctx).WithName("reconcile").WithValues("node", req.NamespacedName, "correlationID", newCorrelationID())
+	ctx = ctrl.LoggerInto(ctx, logger)
+
+	if r.ReconcileRateLimiter != nil {
+		if err := r.ReconcileRateLimiter.Wait(ctx); err != nil {
+			return ctrl.Result{}, fmt.Errorf("reconcile rate limiter: %v", err)
+		}
+	}
+	if err := r.waitForPreload(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the reconcile is counted under the node's cloud once it's known
+	summaryCloud := r.Cloud
+	if r.Cloud == cloudAuto {
+		summaryCloud = cloudUnknown
+	}
+	// retryable cloud API errors are requeued without returning them, they still fail the reconcile
+	var retryErr error
+	defer func() {
+		failure := err
+		if failure == nil {
+			failure = retryErr
+		}
+		r.summary.reconciled(summaryCloud, req.Name, failure)
+		if failure != nil {
+			r.nodeErrors.set(req.Name, failure)
+		} else {
+			r.nodeErrors.clear(req.Name)
+		}
+	}()
+
+	var node corev1.Node
+	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.resetRetryBackoff(req.Name)
+			r.missingProviderIDAttempts.Delete(req.Name)
+			if prev, ok := r.providerIDs.Load(req.Name); ok && r.handlesCloud(r.cloudFor(prev.(string))) {
+				summaryCloud = r.cloudFor(prev.(string))
+			}
+			if r.CleanupOnDelete {
+				return r.cleanupDeletedNode(ctx, req.Name)
+			}
+			r.forgetNode(req.Name)
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable to fetch Node")
+		return ctrl.Result{}, err
+	}
+
+	if r.isFinalizing(&node) {
+		return r.finalizeNode(ctx, &node)
+	}
+
+	if r.isDisabled(&node) {
+		logger.Info("Skipping node, tagging is disabled by its annotation", "annotation", r.DisabledAnnotation)
+		return ctrl.Result{}, nil
+	}
+	if !r.selectsNode(&node) {
+		logger.V(1).Info("Skipping node, it doesn't match the node selector", "nodeSelector", r.NodeSelector.String())
+		return ctrl.Result{}, nil
+	}
+
+	providerID := r.providerID(&node)
+	if providerID == "" {
+		requeueAfter, attempts := r.missingProviderIDBackoff(node.Name)
+		// only the first reconcile of the node logs at the default level, the requeues are expected
+		log := logger
+		if attempts > 0 {
+			log = logger.V(1)
+		}
+		log.Info("Node is missing a spec.ProviderID", "node", node.Name, "requeueAfter", requeueAfter)
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+	r.missingProviderIDAttempts.Delete(node.Name)
+
+	// nodes with an unknown provider ID fall back to the configured cloud's key set
+	nodeCloud, err := detectCloudFromProviderID(providerID)
+	if err != nil {
+		nodeCloud = r.Cloud
+	}
+	if r.Cloud == cloudAuto && err == nil {
+		summaryCloud = nodeCloud
+	}
+
+	tagsToSync := make(map[string]string)
+	for _, k := range r.labelsFor(nodeCloud) {
+		if value, exists := node.Labels[k]; exists && !isKeyPattern(k) {
+			tagsToSync[r.tagKey(nodeCloud, k)] = r.tagValue(value)
+		}
+	}
+	// labels matched by a key pattern are tagged under their own key, literal keys win
+	for _, k := range r.patternMatches(nodeCloud, node.Labels) {
+		if _, exists := tagsToSync[r.TagPrefix+k]; !exists {
+			tagsToSync[r.TagPrefix+k] = r.tagValue(node.Labels[k])
+		}
+	}
+	for _, k := range r.Annotations {
+		if value, exists := node.Annotations[k]; exists {
+			tagsToSync[r.tagKey(nodeCloud, k)] = r.tagValue(value)
+		}
+	}
+	// absent keys are only filled in once all present keys are set, so they never override a
+	// present key written under the same tag key
+	if r.MissingValue != "" {
+		for _, k := range slices.DeleteFunc(slices.Concat(r.labelsFor(nodeCloud), r.Annotations), isKeyPattern) {
+			if _, exists := tagsToSync[r.tagKey(nodeCloud, k)]; !exists {
+				tagsToSync[r.tagKey(nodeCloud, k)] = r.MissingValue
+			}
+		}
+	}
+
+	// templated tags override the labels and annotations of the same tag key
+	for _, t := range r.TagTemplates {
+		if value, ok := t.render(&node, r.TagTemplateMissing); ok {
+			tagsToSync[r.TagPrefix+t.Key] = value
+		}
+	}
+
+	if r.ClusterNameTag != "" {
+		clusterName, err := r.ClusterName.Resolve(ctx, &node)
+		if err != nil {
+			logger.Error(err, "unable to resolve cluster name")
+			return ctrl.Result{}, err
+		}
+		tagsToSync[r.TagPrefix+r.ClusterNameTag] = clusterName
+	}
+
+	if r.NodeUIDTag != "" {
+		tagsToSync[r.TagPrefix+r.NodeUIDTag] = string(node.UID)
+	}
+
+	if r.NodeNameTag != "" {
+		tagsToSync[r.TagPrefix+r.NodeNameTag] = node.Name
+	}
+
+	if r.TagRegionFromProviderID {
+		region, ok := node.Labels[corev1.LabelTopologyRegion]
+		if !ok {
+			if region, err = regionFromProviderID(providerID); err != nil {
+				logger.V(1).Info("Unable to derive the node's region from its provider ID", "providerID", providerID, "reason", err)
+			}
+		}
+		if region != "" {
+			tagsToSync[r.regionTagKey(nodeCloud)] = r.tagValue(region)
+		}
+	}
+
+	for k, v := range r.StaticTags {
+		tagsToSync[r.TagPrefix+k] = v
+	}
+
+	// the managed-by tag lists the keys written to the instance, so they're still managed after
+	// they're removed from the configuration
+	if r.ManagedByTag != "" && len(tagsToSync) > 0 {
+		tagsToSync[r.managedByTagKey()] = managedByValue(slices.Collect(maps.Keys(tagsToSync)))
+	}
+
+	dryRun := r.DryRun || !sampleNode(node.Name, r.SampleRate)
+	if dryRun && !r.DryRun {
+		logger.V(1).Info("Node is not in the sample, changes will only be logged", "sampleRate", r.SampleRate)
+	}
+
+	// during migrations a node's provider ID can move to another cloud, and the GCP override
+	// annotations can retarget a node to another instance. Clean up the managed tags on the old
+	// instance if we can reach it, and no other node references it.
+	if prev, loaded := r.providerIDs.Swap(node.Name, providerID); loaded && instanceKey(prev.(string)) != instanceKey(providerID) {
+		prevProviderID := prev.(string)
+		prevCloud, _ := detectCloudFromProviderID(prevProviderID)
+		if prevCloud != nodeCloud {
+			logger.Info("Node's provider ID moved to a different cloud", "previousProviderID", prevProviderID, "providerID", providerID)
+		} else {
+			logger.Info("Node's instance changed", "previousProviderID", prevProviderID, "providerID", providerID)
+		}
+		prevInstance := instanceKey(prevProviderID)
+		if other, ok := r.instanceNodes.Load(prevInstance); ok && other != node.Name {
+			logger.Info("Skipping cleanup of the previous instance, it's referenced by another node", "previousProviderID", prevProviderID, "otherNode", other)
+		} else if r.handlesCloud(prevCloud) {
+			if err := r.cleanupInstance(ctx, node.Name, prevProviderID, dryRun); err != nil {
+				logger.Error(err, "failed to remove managed tags from the previous instance", "previousProviderID", prevProviderID)
+			}
+		}
+		if r.instanceNodes.CompareAndDelete(prevInstance, node.Name) {
+			r.ownership.forget(prevInstance)
+		}
+	}
+
+	// never push the node's tags through the client of the wrong cloud, eg: a gce:// node in a
+	// cluster misconfigured with --cloud aws
+	if !r.handlesCloud(nodeCloud) {
+		logger.Info("Skipping node, its provider ID does not belong to the configured cloud", "cloud", r.Cloud, "nodeCloud", nodeCloud, "providerID", providerID)
+		// the node's deletion doesn't wait on a cleanup it will never get
+		if err := r.removeCleanupFinalizer(ctx, &node); err != nil {
+			logger.Error(err, "unable to remove the cleanup finalizer")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.addCleanupFinalizer(ctx, &node, dryRun); err != nil {
+		logger.Error(err, "unable to add the cleanup finalizer")
+		return ctrl.Result{}, err
+	}
+
+	// several node objects (eg: a virtual node and the real node) can reference the same
+	// instance. Serialize their reconciles so they don't race on the instance's tags.
+	instance := instanceKey(providerID)
+	if prev, loaded := r.instanceNodes.Swap(instance, node.Name); loaded && prev != node.Name {
+		logger.Info("Instance is referenced by multiple nodes, serializing their reconciles", "instance", instance, "otherNode", prev)
+	}
+	unlock := r.instanceLocks.Lock(instance)
+	defer unlock()
+
+	if skip, err := r.skipDuplicateProviderID(ctx, &node, providerID); err != nil || skip {
+		if err != nil {
+			logger.Error(err, "unable to reconcile node with a duplicate provider ID")
+		}
+		return ctrl.Result{}, err
+	}
+
+	update := tagUpdate{
+		Time:               time.Now(),
+		Node:               node.Name,
+		ProviderID:         providerID,
+		Tags:               tagsToSync,
+		ManagedKeys:        r.managedKeys(nodeCloud),
+		ManagedKeyPatterns: r.managedKeyPatterns(nodeCloud),
+		DryRun:             dryRun,
+	}
+	ctx, changes := withTagChanges(ctx)
+	if err := r.apply(ctx, update); err != nil {
+		r.recordSyncFailed(&node, err)
+		// throttling and server errors are retried after a backoff of the node's own, rather
+		// than controller-runtime's requeue, so many failing nodes don't keep hammering the API
+		if isRetryableCloudError(err) {
+			retryErr = err
+			backoff := r.retryBackoff(node.Name)
+			logger.Info("Cloud API error is retryable, requeueing", "error", err.Error(), "requeueAfter", backoff)
+			return ctrl.Result{RequeueAfter: backoff}, nil
+		}
+		logger.Error(err, "failed to sync labels")
+		return ctrl.Result{}, err
+	}
+	r.resetRetryBackoff(node.Name)
+	r.recordSynced(&node, nodeCloud, changes)
+
+	logger.Info("Successfully synced labels to cloud provider", "labels", tagsToSync)
+
+	// come back to confirm deletions that were observed for the first time
+	if r.TwoPhaseDeleteInterval > 0 && r.pendingDeletes.has(instance) {
+		logger.Info("Tag deletions are pending confirmation", "requeueAfter", r.TwoPhaseDeleteInterval)
+		return ctrl.Result{RequeueAfter: r.TwoPhaseDeleteInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// confirmDeletes filters the managed tag keys about to be deleted from the instance behind
+// providerID down to those confirmed under TwoPhaseDeleteInterval.
+func (r *NodeLabelController) confirmDeletes(providerID string, keys []string) []string {
+	if r.TwoPhaseDeleteInterval <= 0 {
+		return keys
+	}
+	return r.pendingDeletes.confirm(instanceKey(providerID), keys, r.TwoPhaseDeleteInterval, time.Now())
+}
+
+// sink returns the configured tag sink, defaulting to the cloud provider APIs.
+func (r *NodeLabelController) sink() tagSink {
+	if r.Sink != nil {
+		return r.Sink
+	}
+	return &cloudSink{r: r}
+}
+
+// apply passes update to the sink, recording the sync duration and errors metrics.
+func (r *NodeLabelController) apply(ctx context.Context, update tagUpdate) error {
+	cloud := r.cloudFor(update.ProviderID)
+	start := time.Now()
+	err := r.sink().Apply(ctx, update)
+	syncDuration.WithLabelValues(cloud).Observe(time.Since(start).Seconds())
+	if err != nil {
+		syncErrors.WithLabelValues(cloud).Inc()
+	}
+	return err
+}
+
+// syncTags reconciles the managed tags of the instance behind providerID with tags using the
+// client of the instance's cloud.
+func (r *NodeLabelController) syncTags(ctx context.Context, providerID string, tags map[string]string, dryRun bool) error {
+	cloud := r.cloudFor(providerID)
+	if slices.Contains(supportedClouds, cloud) && !r.cloudSetUp(cloud) {
+		return fmt.Errorf("cloud provider %q is not set up", cloud)
+	}
+	switch cloud {
+	case "aws":
+		return r.syncAWSTags(ctx, providerID, tags, dryRun)
+	case "gcp":
+		return r.syncGCPLabels(ctx, providerID, tags, dryRun)
+	case "azure":
+		return r.syncAzureTags(ctx, providerID, tags, dryRun)
+	case "do":
+		return r.syncDOTags(ctx, providerID, tags, dryRun)
+	case "oci":
+		return r.syncOCITags(ctx, providerID, tags, dryRun)
+	case "openstack":
+		return r.syncOpenStackMetadata(ctx, providerID, tags, dryRun)
+	}
+	return fmt.Errorf("unsupported cloud provider: %q", cloud)
+}
+
+// cloudSetUp reports whether the client of cloud is set.
+func (r *NodeLabelController) cloudSetUp(cloud string) bool {
+	switch cloud {
+	case "aws":
+		return r.EC2Client != nil || r.NewEC2Client != nil
+	case "gcp":
+		return r.GCEClient != nil
+	case "azure":
+		return r.AzureClient != nil
+	case "do":
+		return r.DOClient != nil
+	case "oci":
+		return r.OCIClient != nil
+	case "openstack":
+		return r.OpenStackClient != nil
+	}
+	return false
+}
+
+// cleanupInstance removes all managed tags from the instance behind providerID, which was last
+// referenced by node.
+func (r *NodeLabelController) cleanupInstance(ctx context.Context, node, providerID string, dryRun bool) error {
+	unlock := r.instanceLocks.Lock(instanceKey(providerID))
+	defer unlock()
+
+	return r.apply(ctx, tagUpdate{
+		Time:               time.Now(),
+		Node:               node,
+		ProviderID:         providerID,
+		Tags:               map[string]string{},
+		ManagedKeys:        r.managedKeys(r.cloudFor(providerID)),
+		ManagedKeyPatterns: r.managedKeyPatterns(r.cloudFor(providerID)),
+		DryRun:             dryRun,
+	})
+}
+
+// cleanupDeletedNode removes all managed tags from the instance of a deleted node, using the
+// provider ID last seen for the node.
+func (r *NodeLabelController) cleanupDeletedNode(ctx context.Context, name string) (ctrl.Result, error) {
+	logger := ctrl.LoggerFrom(ctx)
+
+	prev, ok := r.providerIDs.Load(name)
+	if !ok {
+		logger.V(1).Info("Node was deleted before its provider ID was seen, skipping cleanup")
+		return ctrl.Result{}, nil
+	}
+	return r.cleanupDeletedInstance(ctx, name, prev.(string))
+}
+
+// forgetNode drops the provider ID of the deleted node name, and its instance's reference to it.
+func (r *NodeLabelController) forgetNode(name string) {
+	if prev, ok := r.providerIDs.LoadAndDelete(name); ok {
+		instance := instanceKey(prev.(string))
+		if r.instanceNodes.CompareAndDelete(instance, name) {
+			r.ownership.forget(instance)
+		}
+	}
+}
+
+// cleanupDeletedInstance removes all managed tags from the instance behind providerID of the
+// deleted node name, unless the instance is referenced by another node.
+func (r *NodeLabelController) cleanupDeletedInstance(ctx context.Context, name, providerID string) (ctrl.Result, error) {
+	logger := ctrl.LoggerFrom(ctx)
+	instance := instanceKey(providerID)
+
+	forget := func() {
+		r.providerIDs.Delete(name)
+		if r.instanceNodes.CompareAndDelete(instance, name) {
+			r.ownership.forget(instance)
+		}
+	}
+
+	if cloud, err := detectCloudFromProviderID(providerID); err != nil || !r.handlesCloud(cloud) {
+		logger.Info("Skipping cleanup of deleted node, its provider ID does not belong to the configured cloud", "cloud", r.Cloud, "providerID", providerID)
+		forget()
+		return ctrl.Result{}, nil
+	}
+
+	// the instance may be referenced by another node by now, eg: a node re-registered under a new name
+	if other, ok := r.instanceNodes.Load(instance); ok && other != name {
+		logger.Info("Skipping cleanup of deleted node, its instance is referenced by another node", "providerID", providerID, "otherNode", other)
+		r.providerIDs.Delete(name)
+		return ctrl.Result{}, nil
+	}
+
+	dryRun := r.DryRun || !sampleNode(name, r.SampleRate)
+	if err := r.cleanupInstance(ctx, name, providerID, dryRun); err != nil {
+		// the instance was deleted along with the node, so there are no tags left to remove
+		if isCloudNotFoundError(err) {
+			logger.Info("Instance of deleted node is gone, nothing to clean up", "providerID", providerID)
+			forget()
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to remove managed tags of deleted node", "providerID", providerID)
+		return ctrl.Result{}, err
+	}
+
+	if r.TwoPhaseDeleteInterval > 0 && r.pendingDeletes.has(instance) {
+		logger.Info("Tag deletions of deleted node are pending confirmation", "requeueAfter", r.TwoPhaseDeleteInterval)
+		return ctrl.Result{RequeueAfter: r.TwoPhaseDeleteInterval}, nil
+	}
+
+	logger.Info("Removed managed tags of deleted node", "providerID", providerID)
+	forget()
+	return ctrl.Result{}, nil
+}
+
+// addCleanupFinalizer adds CleanupFinalizer to node if it's missing. Nodes are left alone in dry-run
+// mode, including nodes outside of the sample.
+func (r *NodeLabelController) addCleanupFinalizer(ctx context.Context, node *corev1.Node, dryRun bool) error {
+	if !r.CleanupOnDelete || r.CleanupFinalizer == "" || dryRun || controllerutil.ContainsFinalizer(node, r.CleanupFinalizer) {
+		return nil
+	}
+
+	orig := node.DeepCopy()
+	controllerutil.AddFinalizer(node, r.CleanupFinalizer)
+	if err := r.Patch(ctx, node, client.MergeFrom(orig)); err != nil {
+		return fmt.Errorf("unable to patch node: %v", err)
+	}
+	return nil
+}
+
+// removeCleanupFinalizer removes CleanupFinalizer from node if it's present. A node deleted in
+// the meantime isn't an error.
+func (r *NodeLabelController) removeCleanupFinalizer(ctx context.Context, node *corev1.Node) error {
+	if r.CleanupFinalizer == "" || !controllerutil.ContainsFinalizer(node, r.CleanupFinalizer) {
+		return nil
+	}
+
+	orig := node.DeepCopy()
+	controllerutil.RemoveFinalizer(node, r.CleanupFinalizer)
+	if err := r.Patch(ctx, node, client.MergeFrom(orig)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to patch node: %v", err)
+	}
+	return nil
+}
+
+// finalizeNode removes all managed tags from the instance of node, which is being deleted, then
+// removes CleanupFinalizer to let the deletion proceed. The finalizer is removed anyway once the
+// cleanup failed for maxCleanupFinalizerHold.
+func (r *NodeLabelController) finalizeNode(ctx context.Context, node *corev1.Node) (ctrl.Result, error) {
+	logger := ctrl.LoggerFrom(ctx)
+
+	// the finalizer of an ignored node is released without touching its instance
+	if providerID := r.providerID(node); providerID != "" && !r.ignoresNode(node) {
+		result, err := r.cleanupDeletedInstance(ctx, node.Name, providerID)
+		if err != nil && time.Since(node.DeletionTimestamp.Time) >= maxCleanupFinalizerHold {
+			logger.Error(err, "Giving up on the cleanup of deleted node, removing the cleanup finalizer", "providerID", providerID, "deletionTimestamp", node.DeletionTimestamp)
+			r.forgetNode(node.Name)
+		} else if err != nil || result.RequeueAfter > 0 {
+			return result, err
+		}
+	}
+
+	if err := r.removeCleanupFinalizer(ctx, node); err != nil {
+		logger.Error(err, "unable to remove the cleanup finalizer")
+		return ctrl.Result{}, err
+	}
+
+	r.finalized.Store(node.Name, struct{}{})
+	logger.Info("Removed the cleanup finalizer of deleted node")
+	return ctrl.Result{}, nil
+}
+
+// managedKeys returns the cloud tag keys owned by the controller on instances of cloud. Only
+// these keys, and those of labels matched by a key pattern, are ever created, updated or deleted
+// on the cloud instance.
+func (r *NodeLabelController) managedKeys(cloud string) []string {
+	labels := slices.DeleteFunc(slices.Clone(r.labelsFor(cloud)), isKeyPattern)
+	keys := make([]string, 0, len(labels)+len(r.Annotations)+2)
+	for _, k := range slices.Concat(labels, r.Annotations) {
+		keys = append(keys, r.tagKey(cloud, k))
+	}
+	if r.ClusterNameTag != "" {
+		keys = append(keys, r.TagPrefix+r.ClusterNameTag)
+	}
+	if r.NodeUIDTag != "" {
+		keys = append(keys, r.TagPrefix+r.NodeUIDTag)
+	}
+	if r.NodeNameTag != "" {
+		keys = append(keys, r.TagPrefix+r.NodeNameTag)
+	}
+	for _, t := range r.TagTemplates {
+		if k := r.TagPrefix + t.Key; !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	if r.TagRegionFromProviderID {
+		if k := r.regionTagKey(cloud); !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(r.StaticTags)) {
+		if k = r.TagPrefix + k; !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	if r.ManagedByTag != "" {
+		keys = append(keys, r.managedByTagKey())
+	}
+	return keys
+}
+
+// labelsFor returns the label keys to sync for nodes of cloud.
+func (r *NodeLabelController) labelsFor(cloud string) []string {
+	if labels, ok := r.CloudLabels[cloud]; ok {
+		return labels
+	}
+	return r.Labels
+}
+
+// monitoredLabels returns the label keys to sync for nodes of any cloud, referenced by a tag
+// template, or read for the cluster name and region tags.
+func (r *NodeLabelController) monitoredLabels() []string {
+	labels := slices.Clone(r.Labels)
+	for _, cloudLabels := range r.CloudLabels {
+		labels = append(labels, cloudLabels...)
+	}
+	for _, t := range r.TagTemplates {
+		labels = append(labels, t.refs("label")...)
+	}
+	if r.ClusterNameTag != "" && r.ClusterName != nil && r.ClusterName.Name == "" && r.ClusterName.Label != "" {
+		labels = append(labels, r.ClusterName.Label)
+	}
+	if r.TagRegionFromProviderID {
+		labels = append(labels, corev1.LabelTopologyRegion)
+	}
+	slices.Sort(labels)
+	return slices.Compact(labels)
+}
+
+// monitoredAnnotations returns the annotation keys synced, referenced by a tag template or
+// overriding the instance of GCP nodes.
+func (r *NodeLabelController) monitoredAnnotations() []string {
+	annotations := slices.Clone(r.Annotations)
+	for _, t := range r.TagTemplates {
+		annotations = append(annotations, t.refs("annotation")...)
+	}
+	if r.handlesCloud("gcp") {
+		for _, a := range []string{r.GCPProjectAnnotation, r.GCPZoneAnnotation, r.GCPInstanceAnnotation} {
+			if a != "" {
+				annotations = append(annotations, a)
+			}
+		}
+	}
+	slices.Sort(annotations)
+	return slices.Compact(annotations)
+}
+
+// syncAWSTags reconciles the managed tags of the EC2 instance behind providerID with
+// desiredLabels. When dryRun is set the changes are computed and logged but not applied.
+func (r *NodeLabelController) syncAWSTags(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	instanceID := path.Base(providerID)
+	if instanceID == "" {
+		return fmt.Errorf("invalid AWS provider ID format: %q", providerID)
+	}
+
+	svc, err := r.ec2ClientFor(providerID)
+	if err != nil {
+		return err
+	}
+
+	instance, cached := r.awsInstanceCache.take(instanceKey(providerID))
+	switch {
+	case cached:
+	case r.AWSTagEBSVolumes || r.AWSTagENIs:
+		// the instance's volumes and network interfaces are listed by DescribeInstances, which
+		// returns its tags too
+		if instance, err = r.describeAWSInstance(ctx, svc, instanceID); err != nil {
+			return fmt.Errorf("failed to fetch node's current AWS tags: %w", err)
+		}
+	default:
+		tags, err := r.fetchAWSTags(ctx, providerID)
+		if err != nil {
+			return err
+		}
+		instance = &awsInstance{tags: tags}
+	}
+	tags := instance.tags
+	var volumeIDs, eniIDs []string
+	if r.AWSTagEBSVolumes {
+		volumeIDs = instance.volumeIDs
+	}
+	if r.AWSTagENIs {
+		eniIDs = instance.networkInterfaceIDs
+	}
+
+	resources := []*awsResource{{
+		id:        instanceID,
+		name:      "instance",
+		tags:      tags,
+		logValues: []any{"instanceID", instanceID},
+	}}
+	if len(volumeIDs) > 0 {
+		volumes, err := r.awsAttachedResources(ctx, svc, instanceID, awsVolumeAttachment, volumeIDs)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, volumes...)
+	}
+	if len(eniIDs) > 0 {
+		enis, err := r.awsAttachedResources(ctx, svc, instanceID, awsENIAttachment, eniIDs)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, enis...)
+	}
+
+	// deletions of all resources are confirmed together, under the instance's two-phase delete
+	// window. The keys of the attached resources are prefixed by their ID.
+	var deleteKeys []string
+	for _, res := range resources {
+		r.planAWSTags(ctx, res, desiredLabels)
+		r.recordUnmanagedCollisions(ctx, "aws", path.Join(instanceKey(providerID), res.pendingPrefix), res.collisions, res.logValues)
+		for _, k := range res.deleteKeys {
+			deleteKeys = append(deleteKeys, res.pendingPrefix+k)
+		}
+	}
+	confirmed := r.confirmDeletes(providerID, deleteKeys)
+
+	// the instance's failures are returned right away, those of an attached resource are reported
+	// with its ID and don't prevent updating the other resources
+	var errs []error
+	for _, res := range resources {
+		for _, k := range res.deleteKeys {
+			if slices.Contains(confirmed, res.pendingPrefix+k) {
+				// tags are deleted by key and observed value, so a concurrent change of the value
+				// makes the delete a no-op instead of clobbering it
+				res.toDelete = append(res.toDelete, types.Tag{Key: aws.String(k), Value: aws.String(res.current[k])})
+			}
+		}
+
+		ctrl.LoggerFrom(ctx).V(1).Info("Computed AWS tag changes", slices.Concat(res.logValues, []any{"toAdd", res.toAdd, "toDelete", res.toDelete})...)
+		if dryRun {
+			if len(res.toAdd) > 0 || len(res.toDelete) > 0 {
+				ctrl.LoggerFrom(ctx).Info("Skipping AWS tag changes", slices.Concat([]any{"providerID", providerID}, res.logValues, []any{"createTags", awsTagMap(res.toAdd), "deleteTags", awsTagMap(res.toDelete)})...)
+			}
+			continue
+		}
+
+		err := r.applyAWSTags(ctx, svc, res)
+		if err != nil && res.name == "instance" {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update AWS %s tags: %w", res.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// awsResource is a tagged EC2 resource of a node, its instance or one of its volumes or network
+// interfaces, and the planned update of its managed tags.
+type awsResource struct {
+	// id is the resource's ID, eg: the instance or volume ID
+	id string
+
+	// name identifies the resource in errors, eg: "instance", "volume vol-1" or
+	// "network interface eni-1"
+	name string
+
+	// pendingPrefix prefixes the resource's tag keys awaiting a two-phase delete
+	pendingPrefix string
+
+	tags      []types.TagDescription
+	logValues []any
+
+	// current are the current values of the managed tags and of their duplicates, toAdd the tags
+	// to create or update and deleteKeys the tags to delete, before two-phase delete confirmation
+	current    map[string]string
+	toAdd      []types.Tag
+	deleteKeys []string
+	toDelete   []types.Tag
+
+	// collisions are the keys of toAdd that overwrite tags the controller didn't write
+	collisions []string
+}
+
+// awsAttachment is a kind of EC2 resource attached to instances, whose managed tags are synced
+// along with the instance's.
+type awsAttachment struct {
+	// name names the kind in errors, eg: volume
+	name string
+	// pendingPrefix prefixes the resource IDs in the keys awaiting a two-phase delete
+	pendingPrefix string
+	// logKey is the log key of the resource IDs
+	logKey string
+}
+
+var (
+	awsVolumeAttachment = awsAttachment{name: "volume", pendingPrefix: "volumes/", logKey: "volumeID"}
+	awsENIAttachment    = awsAttachment{name: "network interface", pendingPrefix: "network-interfaces/", logKey: "networkInterfaceID"}
+)
+
+// awsAttachedResources returns the resources ids of kind attached to the instance instanceID,
+// with their current tags.
+func (r *NodeLabelController) awsAttachedResources(ctx context.Context, svc ec2Client, instanceID string, kind awsAttachment, ids []string) ([]*awsResource, error) {
+	tags, err := r.describeAWSTags(ctx, svc, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the current AWS tags of the instance's %ss: %w", kind.name, err)
+	}
+
+	resources := make([]*awsResource, 0, len(ids))
+	for _, id := range ids {
+		resources = append(resources, &awsResource{
+			id:            id,
+			name:          kind.name + " " + id,
+			pendingPrefix: kind.pendingPrefix + id + "/",
+			tags: slices.DeleteFunc(slices.Clone(tags), func(tag types.TagDescription) bool {
+				return aws.ToString(tag.ResourceId) != id
+			}),
+			logValues: []any{"instanceID", instanceID, kind.logKey, id},
+		})
+	}
+	return resources, nil
+}
+
+// planAWSTags sets the tags to create or update of res, and its managed tags to delete before
+// two-phase delete confirmation.
+func (r *NodeLabelController) planAWSTags(ctx context.Context, res *awsResource, desiredLabels map[string]string) {
+	var managedBy string
+	for _, tag := range res.tags {
+		if r.ManagedByTag != "" && aws.ToString(tag.Key) == r.managedByTagKey() {
+			managedBy = aws.ToString(tag.Value)
+		}
+	}
+	managedKeys := slices.DeleteFunc(withManagedByKeys(r.managedKeys("aws"), managedBy), isReservedAWSTagKey)
+	managed := func(k string) bool {
+		return slices.Contains(managedKeys, k) || (r.matchesKeyPattern("aws", k) && !isReservedAWSTagKey(k))
+	}
+
+	currentTags := make(map[string]string)
+	duplicateTags := make(map[string]string)
+	for _, tag := range res.tags {
+		key := aws.ToString(tag.Key)
+		switch {
+		case key == "":
+		case managed(key):
+			currentTags[key] = aws.ToString(tag.Value)
+		case r.ConsolidateDuplicateKeys && slices.ContainsFunc(managedKeys, func(k string) bool { return strings.EqualFold(k, key) }):
+			duplicateTags[key] = aws.ToString(tag.Value)
+		}
+	}
+
+	res.toAdd = make([]types.Tag, 0)
+	res.toDelete = make([]types.Tag, 0)
+	var overwritten []string
+
+	// find tags to add or update. Keys are sorted so the resulting API calls are deterministic.
+	// Invalid keys, eg: with the reserved aws: prefix, are skipped so the other tags are still
+	// synced. Values are sanitized instead.
+	for _, k := range slices.Sorted(maps.Keys(desiredLabels)) {
+		if err := validateAWSTagKey(k); err != nil {
+			ctrl.LoggerFrom(ctx).Info("Skipping invalid AWS tag", slices.Concat(res.logValues, []any{"key", k, "reason", err.Error()})...)
+			continue
+		}
+		v := sanitizeValueForAWS(desiredLabels[k])
+		if curr, exists := currentTags[k]; !exists || curr != v {
+			res.toAdd = append(res.toAdd, types.Tag{
+				Key:   aws.String(k),
+				Value: aws.String(v),
+			})
+			if exists {
+				overwritten = append(overwritten, k)
+			}
+		}
+	}
+	res.collisions = r.unmanagedKeys(managedBy, func(k string) string { return k }, overwritten)
+
+	// find monitored tags to remove
+	var deleteKeys []string
+	for k := range currentTags {
+		if managed(k) {
+			if _, exists := desiredLabels[k]; !exists {
+				deleteKeys = append(deleteKeys, k)
+			}
+		}
+	}
+	// differently cased duplicates of managed keys are removed in favour of the managed key
+	if len(duplicateTags) > 0 {
+		duplicateKeys := slices.Sorted(maps.Keys(duplicateTags))
+		ctrl.LoggerFrom(ctx).Info("Consolidating duplicate tag keys", slices.Concat(res.logValues, []any{"duplicateKeys", duplicateKeys})...)
+		deleteKeys = append(deleteKeys, duplicateKeys...)
+	}
+	slices.Sort(deleteKeys)
+
+	res.current = currentTags
+	maps.Copy(res.current, duplicateTags)
+	res.deleteKeys = deleteKeys
+}
+
+// applyAWSTags deletes and creates the planned tags of res.
+func (r *NodeLabelController) applyAWSTags(ctx context.Context, svc ec2Client, res *awsResource) error {
+	// large changes are split into several calls of up to maxAWSTagsPerCall tags. Tags are deleted
+	// first, so they no longer count toward the tag limit of the instance when the new ones are
+	// created
+	for batch := range slices.Chunk(res.toDelete, maxAWSTagsPerCall) {
+		err := r.retryAWS(ctx, func() error {
+			_, err := svc.DeleteTags(ctx, &ec2.DeleteTagsInput{
+				Resources: []string{res.id},
+				Tags:      batch,
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete AWS tags: %w", err)
+		}
+		r.tagsChanged(ctx, "aws", nil, awsTagKeys(batch))
+	}
+
+	ordered, unordered := orderAWSTags(res.toAdd, r.AWSTagApplyOrder)
+	batches := slices.Collect(slices.Chunk(ordered, 1))
+	for batch := range slices.Chunk(unordered, maxAWSTagsPerCall) {
+		batches = append(batches, batch)
+	}
+	for _, batch := range batches {
+		err := r.retryAWS(ctx, func() error {
+			_, err := svc.CreateTags(ctx, &ec2.CreateTagsInput{
+				Resources: []string{res.id},
+				Tags:      batch,
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create AWS tags: %w", err)
+		}
+		r.tagsChanged(ctx, "aws", awsTagKeys(batch), nil)
+	}
+
+	return nil
+}
+
+// awsTagMap returns tags as a key to value map, eg: for logging.
+func awsTagMap(tags []types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return m
+}
+
+// awsTagKeys returns the keys of tags.
+func awsTagKeys(tags []types.Tag) []string {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, aws.ToString(tag.Key))
+	}
+	return keys
+}
+
+// fetchAWSTags returns the current tags of the EC2 instance behind providerID.
+func (r *NodeLabelController) fetchAWSTags(ctx context.Context, providerID string) ([]types.TagDescription, error) {
+	svc, err := r.ec2ClientFor(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.AWSDescribeInstances {
+		instance, err := r.describeAWSInstance(ctx, svc, path.Base(providerID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch node's current AWS tags: %w", err)
+		}
+		ctrl.LoggerFrom(ctx).V(1).Info("Described AWS instance", "providerID", providerID, "volumeIDs", instance.volumeIDs, "networkInterfaceIDs", instance.networkInterfaceIDs)
+		return instance.tags, nil
+	}
+
+	tags, err := r.describeAWSTags(ctx, svc, []string{path.Base(providerID)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch node's current AWS tags: %w", err)
+	}
+	return tags, nil
+}
+
+// describeAWSInstance returns the tags and attached resources of the instance instanceID.
+func (r *NodeLabelController) describeAWSInstance(ctx context.Context, svc ec2Client, instanceID string) (*awsInstance, error) {
+	var result *ec2.DescribeInstancesOutput
+	err := r.retryAWS(ctx, func() (err error) {
+		result, err = svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			if aws.ToString(instance.InstanceId) == instanceID {
+				return newAWSInstance(instance), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("instance %s not found", instanceID)
+}
+
+// describeAWSInstances returns the tags and attached resources of the instances, by instance ID.
+// The instances are filtered by ID rather than listed by ID, so a terminated instance is left
+// out instead of failing the request.
+func (r *NodeLabelController) describeAWSInstances(ctx context.Context, svc ec2Client, instanceIDs []string) (map[string]*awsInstance, error) {
+	paginator := ec2.NewDescribeInstancesPaginator(svc, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: instanceIDs,
+			},
+		},
+	})
+
+	instances := make(map[string]*awsInstance, len(instanceIDs))
+	for paginator.HasMorePages() {
+		var result *ec2.DescribeInstancesOutput
+		err := r.retryAWS(ctx, func() (err error) {
+			result, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range result.Reservations {
+			for _, instance := range reservation.Instances {
+				instances[aws.ToString(instance.InstanceId)] = newAWSInstance(instance)
+			}
+		}
+	}
+	return instances, nil
+}
+
+// describeAWSTags returns the tags of the instances, reading all pages of the results: instances
+// can have more tags than fit on a single page, and a page is shared by all instances.
+func (r *NodeLabelController) describeAWSTags(ctx context.Context, svc ec2Client, instanceIDs []string) ([]types.TagDescription, error) {
+	paginator := ec2.NewDescribeTagsPaginator(svc, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: instanceIDs,
+			},
+		},
+	})
+
+	var tags []types.TagDescription
+	for paginator.HasMorePages() {
+		var result *ec2.DescribeTagsOutput
+		err := r.retryAWS(ctx, func() (err error) {
+			result, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, result.Tags...)
+	}
+	return tags, nil
+}
+
+// retryAWS calls the AWS API call fn, retrying throttling and server errors up to AWSMaxRetries times.
+func (r *NodeLabelController) retryAWS(ctx context.Context, fn func() error) error {
+	baseDelay := r.awsRetryBaseDelay
+	if baseDelay == 0 {
+		baseDelay = defaultAWSRetryBaseDelay
+	}
+	return retryAWS(ctx, r.AWSMaxRetries, baseDelay, fn)
+}
+
+// retryGCP calls the GCE API call fn, retrying rate limit, quota and server errors up to
+// GCPMaxRetries times.
+func (r *NodeLabelController) retryGCP(ctx context.Context, fn func() error) error {
+	baseDelay := r.gcpRetryBaseDelay
+	if baseDelay == 0 {
+		baseDelay = defaultGCPRetryBaseDelay
+	}
+	return retryCloudCall(ctx, "gcp", r.GCPMaxRetries, baseDelay, maxGCPRetryDelay, isRetryableGCPError, fn)
+}
+
+// ec2ClientFor returns the EC2 client for the region of the instance behind providerID.
+func (r *NodeLabelController) ec2ClientFor(providerID string) (ec2Client, error) {
+	if r.AZToRegion == nil || r.NewEC2Client == nil {
+		return r.EC2Client, nil
+	}
+
+	az, _, err := parseAWSProviderID(providerID)
+	if err != nil {
+		return nil, err
+	}
+	if az == "" {
+		return r.EC2Client, nil
+	}
+	region, err := r.AZToRegion(az)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := r.regionalEC2Clients.Load(region); ok {
+		return c.(ec2Client), nil
+	}
+	c, _ := r.regionalEC2Clients.LoadOrStore(region, r.NewEC2Client(region))
+	return c.(ec2Client), nil
+}
+
+// syncGCPLabels reconciles the managed labels of the GCE instance behind providerID with
+// desiredLabels. When dryRun is set the changes are computed and logged but not applied.
+func (r *NodeLabelController) syncGCPLabels(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	project, zone, name, err := parseGCPProviderID(providerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse GCP provider ID: %v", err)
+	}
+
+	instance, ok := r.gceInstanceCache.take(instanceKey(providerID))
+	if !ok {
+		if instance, err = r.fetchGCEInstance(ctx, providerID); err != nil {
+			return err
+		}
+	}
+
+	if r.GCPSkipNonRunning && instance.Status != "RUNNING" {
+		ctrl.LoggerFrom(ctx).V(1).Info("Skipping GCP label update of non-running instance", "instance", name, "status", instance.Status)
+		return nil
+	}
+
+	resources := []*gcpResource{{
+		name:        "instance",
+		owner:       instanceKey(providerID),
+		labels:      instance.Labels,
+		fingerprint: instance.LabelFingerprint,
+		logValues:   []any{"instance", name},
+		setLabels: func(ctx context.Context, labels map[string]string, fingerprint string) error {
+			return r.retryGCP(ctx, func() error {
+				return r.GCEClient.SetLabels(ctx, project, zone, name, &gce.InstancesSetLabelsRequest{
+					Labels:           labels,
+					LabelFingerprint: fingerprint,
+				})
+			})
+		},
+	}}
+
+	// the labels of each resource are updated independently, a failure is reported with the
+	// resource's name and doesn't prevent updating the others
+	var errs []error
+	if r.GCPLabelDisks {
+		disks, err := r.gcpDiskResources(ctx, providerID, instance)
+		resources = append(resources, disks...)
+		errs = append(errs, err)
+	}
+
+	managedKeys := r.managedKeys("gcp")
+	// collisions are resolved over all managed keys, so a key's label doesn't change with the
+	// presence of the keys it collides with
+	gcpKeys := sanitizeKeysForGCP(slices.Concat(managedKeys, slices.Collect(maps.Keys(desiredLabels))))
+	sanitizedLabels := sanitizeLabelsWithKeysForGCP(desiredLabels, gcpKeys)
+	// keys that are still invalid once sanitized are skipped, so the other labels are still synced
+	for _, k := range slices.Sorted(maps.Keys(sanitizedLabels)) {
+		if err := validateGCPLabelKey(k); err != nil {
+			ctrl.LoggerFrom(ctx).Info("Skipping invalid GCP label", "instance", name, "key", k, "reason", err.Error())
+			delete(sanitizedLabels, k)
+		}
+	}
+
+	// deletions of all resources are confirmed together, under the instance's two-phase delete
+	// window. Sanitized keys never contain a '/', so the disks' keys are prefixed by their name.
+	var deleteKeys []string
+	for _, res := range resources {
+		r.planGCPLabels(ctx, res, managedKeys, gcpKeys, sanitizedLabels)
+		for _, k := range res.deleteKeys {
+			deleteKeys = append(deleteKeys, res.pendingPrefix+k)
+		}
+	}
+	confirmed := r.confirmDeletes(providerID, deleteKeys)
+	for _, res := range resources {
+		res.deleteKeys = slices.DeleteFunc(slices.Clone(res.deleteKeys), func(k string) bool {
+			return !slices.Contains(confirmed, res.pendingPrefix+k)
+		})
+	}
+
+	for _, res := range resources {
+		if err := r.applyGCPLabels(ctx, providerID, res, dryRun); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update GCP %s labels: %w", res.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// gcpResource is a labelled GCE resource of a node, its instance or one of its disks, and the
+// planned update of its managed labels.
+type gcpResource struct {
+	// name identifies the resource in errors, eg: "instance" or "disk disk-1"
+	name string
+
+	// owner is the key of the resource's tag ownership
+	owner string
+
+	// pendingPrefix prefixes the resource's label keys awaiting a two-phase delete
+	pendingPrefix string
+
+	labels      map[string]string
+	fingerprint string
+	logValues   []any
+	setLabels   func(ctx context.Context, labels map[string]string, fingerprint string) error
+
+	// managed are the desired managed labels and deleteKeys the managed labels to delete
+	managed    map[string]string
+	deleteKeys []string
+}
+
+// gcpDiskResources returns the zonal disks attached to instance. Disks that can't be fetched are
+// reported in the returned error and left out.
+func (r *NodeLabelController) gcpDiskResources(ctx context.Context, providerID string, instance *gce.Instance) ([]*gcpResource, error) {
+	var resources []*gcpResource
+	var errs []error
+	for _, attached := range instance.Disks {
+		project, zone, name, err := parseGCEDiskSource(attached.Source)
+		if err != nil {
+			ctrl.LoggerFrom(ctx).V(1).Info("Skipping GCP disk", "instance", instance.Name, "reason", err)
+			continue
+		}
+
+		var disk *gce.Disk
+		err = r.retryGCP(ctx, func() (err error) {
+			disk, err = r.GCEClient.GetDisk(ctx, project, zone, name)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get GCP disk %s: %w", name, err))
+			continue
+		}
+
+		resources = append(resources, &gcpResource{
+			name:          "disk " + name,
+			owner:         instanceKey(providerID) + "/disks/" + name,
+			pendingPrefix: "disks/" + name + "/",
+			labels:        disk.Labels,
+			fingerprint:   disk.LabelFingerprint,
+			logValues:     []any{"instance", instance.Name, "disk", name},
+			setLabels: func(ctx context.Context, labels map[string]string, fingerprint string) error {
+				return r.retryGCP(ctx, func() error {
+					return r.GCEClient.SetDiskLabels(ctx, project, zone, name, &gce.ZoneSetLabelsRequest{
+						Labels:           labels,
+						LabelFingerprint: fingerprint,
+					})
+				})
+			},
+		})
+	}
+	return resources, errors.Join(errs...)
+}
+
+// planGCPLabels sets the desired managed labels of res, out of the sanitized desired labels, and
+// its managed labels to delete, before two-phase delete confirmation. gcpKeys maps the managed
+// keys to their sanitized keys.
+func (r *NodeLabelController) planGCPLabels(ctx context.Context, res *gcpResource, managedKeys []string, gcpKeys, sanitizedLabels map[string]string) {
+	// create a set of sanitized monitored keys for easy lookup
+	monitoredKeys := make(map[string]bool)
+	for _, k := range managedKeys {
+		monitoredKeys[gcpKeys[k]] = true
+	}
+	res.managed = maps.Clone(sanitizedLabels)
+
+	// the sanitized keys of the managed keys are deterministic, so their labels are the
+	// controller's, eg: team-name for team.name, even once a restart forgot the labels it wrote.
+	// Sanitizing can still map another synced key onto an existing unmanaged label, such labels
+	// are left alone until the controller owns them, unless GCPOverwriteUnmanaged is set.
+	unmanaged := func(k string) bool {
+		if monitoredKeys[k] || r.ownership.owns(res.owner, k) {
+			return false
+		}
+		_, exists := res.labels[k]
+		return exists
+	}
+	collides := func(k string) bool {
+		return !r.GCPOverwriteUnmanaged && unmanaged(k)
+	}
+	logValues := func(kv ...any) []any {
+		return slices.Concat(res.logValues, kv)
+	}
+	for _, k := range slices.Sorted(maps.Keys(res.managed)) {
+		if curr, exists := res.labels[k]; !exists || curr == res.managed[k] || !unmanaged(k) {
+			continue
+		}
+		r.recordUnmanagedCollisions(ctx, "gcp", res.owner, []string{k}, res.logValues)
+		if r.GCPOverwriteUnmanaged {
+			ctrl.LoggerFrom(ctx).Info("Overwriting unmanaged GCP label with the same sanitized key", logValues("key", k)...)
+			continue
+		}
+		ctrl.LoggerFrom(ctx).Info("Refusing to overwrite unmanaged GCP label with the same sanitized key", logValues("key", k)...)
+		delete(res.managed, k)
+	}
+
+	// remove any existing monitored labels that are no longer desired. The labels of keys matched
+	// by LabelRegex can't be told apart once sanitized, so only those the controller wrote since it
+	// started are removed.
+	res.deleteKeys = nil
+	for k := range res.labels {
+		if (monitoredKeys[k] || r.ownership.owns(res.owner, k)) && !collides(k) {
+			if _, exists := res.managed[k]; !exists {
+				res.deleteKeys = append(res.deleteKeys, k)
+			}
+		}
+	}
+	// managed keys with characters GCP doesn't allow used to keep them once sanitized, their
+	// labels move to the new sanitized key
+	for _, k := range managedKeys {
+		legacy := legacySanitizeKeyForGCP(k)
+		if legacy == gcpKeys[k] || monitoredKeys[legacy] || slices.Contains(res.deleteKeys, legacy) {
+			continue
+		}
+		if _, desired := res.managed[legacy]; desired {
+			continue
+		}
+		if _, exists := res.labels[legacy]; exists {
+			ctrl.LoggerFrom(ctx).Info("Deleting GCP label of a managed key sanitized by previous rules", logValues("key", legacy, "newKey", gcpKeys[k])...)
+			res.deleteKeys = append(res.deleteKeys, legacy)
+		}
+	}
+	slices.Sort(res.deleteKeys)
+}
+
+// applyGCPLabels writes the planned managed labels of res, keeping its unmanaged labels.
+func (r *NodeLabelController) applyGCPLabels(ctx context.Context, providerID string, res *gcpResource, dryRun bool) error {
+	newLabels := maps.Clone(res.labels)
+	if newLabels == nil {
+		newLabels = make(map[string]string)
+	}
+	for _, k := range res.deleteKeys {
+		delete(newLabels, k)
+	}
+
+	// add or update desired labels
+	maps.Copy(newLabels, res.managed)
+
+	// skip update if no changes
+	if maps.Equal(res.labels, newLabels) {
+		ctrl.LoggerFrom(ctx).V(1).Info("GCP labels already up to date", res.logValues...)
+		r.ownership.claim(res.owner, slices.Collect(maps.Keys(res.managed))...)
+		return nil
+	}
+
+	setLabels := make(map[string]string)
+	for k, v := range res.managed {
+		if curr, exists := res.labels[k]; !exists || curr != v {
+			setLabels[k] = v
+		}
+	}
 
-	// Labels is a list of label keys to sync from the node to the cloud provider
-	Labels []string
+	if dryRun {
+		ctrl.LoggerFrom(ctx).Info("Skipping GCP label update", slices.Concat([]any{"providerID", providerID}, res.logValues, []any{"setLabels", setLabels, "removeLabels", res.deleteKeys})...)
+		return nil
+	}
 
-	// Cloud is the cloud provider (aws or gcp)
-	Cloud string
-}
+	ctrl.LoggerFrom(ctx).V(1).Info("Updating GCP labels", slices.Concat(res.logValues, []any{"labels", newLabels})...)
 
-func (r *NodeLabelController) SetupCloudProvider(ctx context.Context) error {
-	switch r.Cloud {
-	case "aws":
-		cfg, err := awsconfig.LoadDefaultConfig(ctx)
-		if err != nil {
-			return fmt.Errorf("unable to load AWS config: %v", err)
-		}
-		r.EC2Client = ec2.NewFromConfig(cfg)
-	case "gcp":
-		c, err := gce.NewService(ctx)
-		if err != nil {
-			return fmt.Errorf("unable to create GCP client: %v", err)
-		}
-		r.GCEClient = newGCEComputeClient(c)
-	default:
-		return fmt.Errorf("unsupported cloud provider: %q", r.Cloud)
+	if err := res.setLabels(ctx, newLabels, res.fingerprint); err != nil {
+		return err
 	}
+	r.tagsChanged(ctx, "gcp", slices.Collect(maps.Keys(setLabels)), res.deleteKeys)
+	r.ownership.claim(res.owner, slices.Collect(maps.Keys(res.managed))...)
+	r.ownership.release(res.owner, res.deleteKeys...)
+
 	return nil
 }
 
-func (r *NodeLabelController) SetupWithManager(mgr ctrl.Manager) error {
-	// to reduce the number of API calls to AWS and GCP, filter out node events that
-	// do not involve changes to the monitored label set (r.labels).
-	labelChangePredicate := predicate.Funcs{
-		UpdateFunc: func(e event.UpdateEvent) bool {
-			oldNode, ok := e.ObjectOld.(*corev1.Node)
-			if !ok {
-				return false
-			}
-			newNode, ok := e.ObjectNew.(*corev1.Node)
-			if !ok {
-				return false
-			}
-			return shouldProcessNodeUpdate(oldNode, newNode, r.Labels)
-		},
-
-		CreateFunc: func(e event.CreateEvent) bool {
-			node, ok := e.Object.(*corev1.Node)
-			if !ok {
-				return false
-			}
-			return shouldProcessNodeCreate(node, r.Labels)
-		},
+// syncAzureTags reconciles the managed tags of the Azure VM behind providerID with desiredLabels.
+// When dryRun is set the changes are computed and logged but not applied.
+func (r *NodeLabelController) syncAzureTags(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	subscription, resourceGroup, vm, err := parseAzureProviderID(providerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse Azure provider ID: %v", err)
+	}
+	resourceID := azureVMResourceID(subscription, resourceGroup, vm)
 
-		DeleteFunc: func(e event.DeleteEvent) bool {
-			return false
-		},
+	currentTags, err := r.AzureClient.GetTags(ctx, resourceID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch node's current Azure tags: %w", err)
+	}
 
-		GenericFunc: func(e event.GenericEvent) bool {
-			return false
-		},
+	// Azure tag names are case-insensitive, so keys are compared in lowercase. The current
+	// casing of existing tags is kept for deletes.
+	var managedBy string
+	for k, v := range currentTags {
+		if r.ManagedByTag != "" && strings.EqualFold(k, sanitizeKeyForAzure(r.managedByTagKey())) {
+			managedBy = v
+		}
+	}
+	monitoredKeys := make(map[string]bool)
+	for _, k := range withManagedByKeys(r.managedKeys("azure"), managedBy) {
+		monitoredKeys[strings.ToLower(sanitizeKeyForAzure(k))] = true
+	}
+	sanitizedTags := sanitizeTagsForAzure(desiredLabels)
+	desiredKeys := make(map[string]bool)
+	for k := range sanitizedTags {
+		desiredKeys[strings.ToLower(k)] = true
+	}
+	currentKeys := make(map[string]string)
+	for k := range currentTags {
+		currentKeys[strings.ToLower(k)] = k
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Node{}).
-		WithEventFilter(labelChangePredicate).
-		Complete(r)
-}
+	// find tags to add or update, with the desired casing
+	toAdd := make(map[string]string)
+	var overwritten []string
+	for k, v := range sanitizedTags {
+		if curr, exists := currentKeys[strings.ToLower(k)]; !exists || currentTags[curr] != v {
+			toAdd[k] = v
+			if exists {
+				overwritten = append(overwritten, k)
+			}
+		}
+	}
+	r.recordUnmanagedCollisions(ctx, "azure", instanceKey(providerID), r.unmanagedKeys(managedBy, func(k string) string {
+		return strings.ToLower(sanitizeKeyForAzure(k))
+	}, overwritten), []any{"vm", vm})
 
-// shouldProcessNodeUpdate determines if a node update event should trigger reconciliation
-// based on whether any monitored labels have changed.
-func shouldProcessNodeUpdate(oldNode, newNode *corev1.Node, monitoredLabels []string) bool {
-	if oldNode == nil || newNode == nil {
-		return false
+	// find monitored tags to remove. They're deleted by name and observed value, so a concurrent
+	// change of the value isn't clobbered.
+	var deleteKeys []string
+	for lower, k := range currentKeys {
+		if monitoredKeys[lower] && !desiredKeys[lower] {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	slices.Sort(deleteKeys)
+	toDelete := make(map[string]string)
+	for _, k := range r.confirmDeletes(providerID, deleteKeys) {
+		toDelete[k] = currentTags[k]
 	}
 
-	// Check if any monitored labels changed
-	for _, k := range monitoredLabels {
-		newVal, newExists := newNode.Labels[k]
-		oldVal, oldExists := oldNode.Labels[k]
-		if newExists != oldExists || (newExists && newVal != oldVal) {
-			return true
+	ctrl.LoggerFrom(ctx).V(1).Info("Computed Azure tag changes", "vm", vm, "toAdd", toAdd, "toDelete", toDelete)
+
+	if dryRun {
+		if len(toAdd) > 0 || len(toDelete) > 0 {
+			ctrl.LoggerFrom(ctx).Info("Skipping Azure tag changes", "providerID", providerID, "vm", vm, "mergeTags", toAdd, "deleteTags", toDelete)
 		}
+		return nil
 	}
-	return false
-}
 
-// shouldProcessNodeCreate determines if a newly created node should trigger reconciliation
-// based on whether it has any of the monitored labels.
-func shouldProcessNodeCreate(node *corev1.Node, monitoredLabels []string) bool {
-	if node == nil {
-		return false
+	if len(toAdd) > 0 {
+		if err := r.AzureClient.MergeTags(ctx, resourceID, toAdd); err != nil {
+			return fmt.Errorf("failed to update Azure tags: %w", err)
+		}
+		r.tagsChanged(ctx, "azure", slices.Collect(maps.Keys(toAdd)), nil)
 	}
 
-	for _, k := range monitoredLabels {
-		if _, ok := node.Labels[k]; ok {
-			return true
+	if len(toDelete) > 0 {
+		if err := r.AzureClient.DeleteTags(ctx, resourceID, toDelete); err != nil {
+			return fmt.Errorf("failed to delete Azure tags: %w", err)
 		}
+		r.tagsChanged(ctx, "azure", nil, slices.Collect(maps.Keys(toDelete)))
 	}
-	return false
+
+	return nil
 }
 
-func (r *NodeLabelController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	logger := ctrl.Log.WithName("reconcile").WithValues("node", req.NamespacedName)
+// syncDOTags reconciles the managed tags of the DigitalOcean droplet behind providerID with
+// desiredLabels. DigitalOcean tags have no values, so each label is written as a key:value tag
+// and a changed value replaces the key's tag. When dryRun is set the changes are computed and
+// logged but not applied.
+func (r *NodeLabelController) syncDOTags(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	dropletID, err := parseDOProviderID(providerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse DigitalOcean provider ID: %v", err)
+	}
 
-	var node corev1.Node
-	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
-		logger.Error(err, "unable to fetch Node")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	currentTags, err := r.DOClient.GetDropletTags(ctx, dropletID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch node's current DigitalOcean tags: %w", err)
 	}
 
-	providerID := node.Spec.ProviderID
-	if providerID == "" {
-		logger.Info("Node is missing a spec.ProviderID", "node", node.Name)
-		return ctrl.Result{}, nil
+	// the current tags of the managed keys, by sanitized key
+	managedKeys := r.managedKeys("do")
+	keys := slices.Concat(managedKeys, slices.Collect(maps.Keys(desiredLabels)))
+	currentByKey := make(map[string][]string)
+	for _, tag := range currentTags {
+		if k, ok := doTagKey(tag, keys); ok {
+			currentByKey[k] = append(currentByKey[k], tag)
+		}
+	}
+	desiredTags := make(map[string]string, len(desiredLabels))
+	for k, v := range desiredLabels {
+		desiredTags[sanitizeForDO(k)] = doTag(k, v)
 	}
 
-	labels := make(map[string]string)
-	for _, k := range r.Labels {
-		if value, exists := node.Labels[k]; exists {
-			labels[k] = value
+	// find tags to add, and the previous values of their keys to remove right away
+	var toAdd, toRemove, setKeys []string
+	for _, k := range slices.Sorted(maps.Keys(desiredTags)) {
+		tag := desiredTags[k]
+		if !slices.Contains(currentByKey[k], tag) {
+			toAdd = append(toAdd, tag)
+			setKeys = append(setKeys, k)
+		}
+		for _, curr := range currentByKey[k] {
+			if curr != tag {
+				toRemove = append(toRemove, curr)
+			}
 		}
 	}
 
-	var err error
-	switch r.Cloud {
-	case "aws":
-		err = r.syncAWSTags(ctx, providerID, labels)
-	case "gcp":
-		err = r.syncGCPLabels(ctx, providerID, labels)
+	// find managed keys to remove
+	var deleteKeys []string
+	for _, k := range managedKeys {
+		k = sanitizeForDO(k)
+		if _, desired := desiredTags[k]; !desired && len(currentByKey[k]) > 0 && !slices.Contains(deleteKeys, k) {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	slices.Sort(deleteKeys)
+	deletedKeys := r.confirmDeletes(providerID, deleteKeys)
+	for _, k := range deletedKeys {
+		toRemove = append(toRemove, currentByKey[k]...)
 	}
 
-	if err != nil {
-		logger.Error(err, "failed to sync labels")
-		return ctrl.Result{}, err
+	ctrl.LoggerFrom(ctx).V(1).Info("Computed DigitalOcean tag changes", "dropletID", dropletID, "toAdd", toAdd, "toRemove", toRemove)
+
+	if dryRun {
+		if len(toAdd) > 0 || len(toRemove) > 0 {
+			ctrl.LoggerFrom(ctx).Info("Skipping DigitalOcean tag changes", "providerID", providerID, "dropletID", dropletID, "addTags", toAdd, "removeTags", toRemove)
+		}
+		return nil
 	}
 
-	logger.Info("Successfully synced labels to cloud provider", "labels", labels)
-	return ctrl.Result{}, nil
+	// tags are added before the previous values of their keys are removed, so a key is never
+	// missing from the droplet
+	for _, tag := range toAdd {
+		if err := r.DOClient.TagDroplet(ctx, dropletID, tag); err != nil {
+			return fmt.Errorf("failed to add DigitalOcean tag %q: %w", tag, err)
+		}
+	}
+	if len(setKeys) > 0 {
+		r.tagsChanged(ctx, "do", setKeys, nil)
+	}
+	for _, tag := range toRemove {
+		if err := r.DOClient.UntagDroplet(ctx, dropletID, tag); err != nil {
+			return fmt.Errorf("failed to remove DigitalOcean tag %q: %w", tag, err)
+		}
+	}
+	if len(deletedKeys) > 0 {
+		r.tagsChanged(ctx, "do", nil, deletedKeys)
+	}
+
+	return nil
 }
 
-func (r *NodeLabelController) syncAWSTags(ctx context.Context, providerID string, desiredLabels map[string]string) error {
-	instanceID := path.Base(providerID)
-	if instanceID == "" {
-		return fmt.Errorf("invalid AWS provider ID format: %q", providerID)
+// syncOCITags reconciles the managed freeform tags of the OCI instance behind providerID with
+// desiredLabels. The freeform tags are replaced as a whole, keeping the unmanaged tags, and the
+// update fails if the instance changed since its tags were read. When dryRun is set the changes
+// are computed and logged but not applied.
+func (r *NodeLabelController) syncOCITags(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	instanceID, err := parseOCIProviderID(providerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse OCI provider ID: %v", err)
 	}
 
-	result, err := r.EC2Client.DescribeTags(ctx, &ec2.DescribeTagsInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("resource-id"),
-				Values: []string{instanceID},
-			},
-		},
-	})
+	currentTags, etag, err := r.OCIClient.GetInstanceTags(ctx, instanceID)
 	if err != nil {
-		return fmt.Errorf("failed to fetch node's current AWS tags: %v", err)
+		return fmt.Errorf("failed to fetch node's current OCI tags: %w", err)
 	}
 
-	currentTags := make(map[string]string)
-	for _, tag := range result.Tags {
-		if key := aws.ToString(tag.Key); key != "" && slices.Contains(r.Labels, key) {
-			currentTags[key] = aws.ToString(tag.Value)
-		}
+	var managedBy string
+	if r.ManagedByTag != "" {
+		managedBy = currentTags[sanitizeKeyForOCI(r.managedByTagKey())]
 	}
-
-	toAdd := make([]types.Tag, 0)
-	toDelete := make([]types.Tag, 0)
+	monitoredKeys := make(map[string]bool)
+	for _, k := range withManagedByKeys(r.managedKeys("oci"), managedBy) {
+		monitoredKeys[sanitizeKeyForOCI(k)] = true
+	}
+	sanitizedTags := sanitizeTagsForOCI(desiredLabels)
 
 	// find tags to add or update
-	for k, v := range desiredLabels {
+	toAdd := make(map[string]string)
+	var overwritten []string
+	for k, v := range sanitizedTags {
 		if curr, exists := currentTags[k]; !exists || curr != v {
-			toAdd = append(toAdd, types.Tag{
-				Key:   aws.String(k),
-				Value: aws.String(v),
-			})
+			toAdd[k] = v
+			if exists {
+				overwritten = append(overwritten, k)
+			}
 		}
 	}
+	r.recordUnmanagedCollisions(ctx, "oci", instanceKey(providerID), r.unmanagedKeys(managedBy, sanitizeKeyForOCI, overwritten), []any{"instanceID", instanceID})
 
 	// find monitored tags to remove
+	var deleteKeys []string
 	for k := range currentTags {
-		if slices.Contains(r.Labels, k) {
-			if _, exists := desiredLabels[k]; !exists {
-				toDelete = append(toDelete, types.Tag{
-					Key: aws.String(k),
-				})
+		if _, desired := sanitizedTags[k]; monitoredKeys[k] && !desired {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	slices.Sort(deleteKeys)
+	deleteKeys = r.confirmDeletes(providerID, deleteKeys)
+
+	ctrl.LoggerFrom(ctx).V(1).Info("Computed OCI tag changes", "instanceID", instanceID, "toAdd", toAdd, "toDelete", deleteKeys)
+
+	if len(toAdd) == 0 && len(deleteKeys) == 0 {
+		return nil
+	}
+	if dryRun {
+		ctrl.LoggerFrom(ctx).Info("Skipping OCI tag changes", "providerID", providerID, "instanceID", instanceID, "setTags", toAdd, "deleteTags", deleteKeys)
+		return nil
+	}
+
+	newTags := maps.Clone(currentTags)
+	if newTags == nil {
+		newTags = make(map[string]string)
+	}
+	for _, k := range deleteKeys {
+		delete(newTags, k)
+	}
+	maps.Copy(newTags, toAdd)
+
+	if err := r.OCIClient.UpdateInstanceTags(ctx, instanceID, newTags, etag); err != nil {
+		return fmt.Errorf("failed to update OCI tags: %w", err)
+	}
+	r.tagsChanged(ctx, "oci", slices.Collect(maps.Keys(toAdd)), deleteKeys)
+
+	return nil
+}
+
+// syncOpenStackMetadata reconciles the managed metadata of the Nova instance behind providerID
+// with desiredLabels. When dryRun is set the changes are computed and logged but not applied.
+func (r *NodeLabelController) syncOpenStackMetadata(ctx context.Context, providerID string, desiredLabels map[string]string, dryRun bool) error {
+	serverID, err := parseOpenStackProviderID(providerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse OpenStack provider ID: %v", err)
+	}
+
+	currentMetadata, err := r.OpenStackClient.GetServerMetadata(ctx, serverID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch node's current OpenStack metadata: %w", err)
+	}
+
+	var managedBy string
+	if r.ManagedByTag != "" {
+		managedBy = currentMetadata[sanitizeKeyForOpenStack(r.managedByTagKey())]
+	}
+	monitoredKeys := make(map[string]bool)
+	for _, k := range withManagedByKeys(r.managedKeys("openstack"), managedBy) {
+		monitoredKeys[sanitizeKeyForOpenStack(k)] = true
+	}
+	sanitizedMetadata := sanitizeMetadataForOpenStack(desiredLabels)
+
+	// find metadata to add or update
+	toAdd := make(map[string]string)
+	var overwritten []string
+	for k, v := range sanitizedMetadata {
+		if curr, exists := currentMetadata[k]; !exists || curr != v {
+			toAdd[k] = v
+			if exists {
+				overwritten = append(overwritten, k)
 			}
 		}
 	}
+	r.recordUnmanagedCollisions(ctx, "openstack", instanceKey(providerID), r.unmanagedKeys(managedBy, sanitizeKeyForOpenStack, overwritten), []any{"serverID", serverID})
+
+	// find monitored metadata to remove
+	var deleteKeys []string
+	for k := range currentMetadata {
+		if _, desired := sanitizedMetadata[k]; monitoredKeys[k] && !desired {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	slices.Sort(deleteKeys)
+	deleteKeys = r.confirmDeletes(providerID, deleteKeys)
+
+	ctrl.LoggerFrom(ctx).V(1).Info("Computed OpenStack metadata changes", "serverID", serverID, "toAdd", toAdd, "toDelete", deleteKeys)
+
+	if dryRun {
+		if len(toAdd) > 0 || len(deleteKeys) > 0 {
+			ctrl.LoggerFrom(ctx).Info("Skipping OpenStack metadata changes", "providerID", providerID, "serverID", serverID, "setMetadata", toAdd, "deleteMetadata", deleteKeys)
+		}
+		return nil
+	}
 
 	if len(toAdd) > 0 {
-		_, err := r.EC2Client.CreateTags(ctx, &ec2.CreateTagsInput{
-			Resources: []string{instanceID},
-			Tags:      toAdd,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to create AWS tags: %v", err)
+		if err := r.OpenStackClient.UpdateServerMetadata(ctx, serverID, toAdd); err != nil {
+			return fmt.Errorf("failed to update OpenStack metadata: %w", err)
 		}
+		r.tagsChanged(ctx, "openstack", slices.Collect(maps.Keys(toAdd)), nil)
 	}
 
-	if len(toDelete) > 0 {
-		_, err := r.EC2Client.DeleteTags(ctx, &ec2.DeleteTagsInput{
-			Resources: []string{instanceID},
-			Tags:      toDelete,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to delete AWS tags: %v", err)
+	for _, k := range deleteKeys {
+		if err := r.OpenStackClient.DeleteServerMetadata(ctx, serverID, k); err != nil {
+			return fmt.Errorf("failed to delete OpenStack metadata %q: %w", k, err)
 		}
 	}
+	if len(deleteKeys) > 0 {
+		r.tagsChanged(ctx, "openstack", nil, deleteKeys)
+	}
 
 	return nil
 }
 
-func (r *NodeLabelController) syncGCPLabels(ctx context.Context, providerID string, desiredLabels map[string]string) error {
+// fetchGCEInstance returns the GCE instance behind providerID.
+func (r *NodeLabelController) fetchGCEInstance(ctx context.Context, providerID string) (*gce.Instance, error) {
 	project, zone, name, err := parseGCPProviderID(providerID)
 	if err != nil {
-		return fmt.Errorf("failed to parse GCP provider ID: %v", err)
+		return nil, fmt.Errorf("failed to parse GCP provider ID: %v", err)
 	}
 
-	instance, err := r.GCEClient.GetInstance(ctx, project, zone, name)
+	var instance *gce.Instance
+	err = r.retryGCP(ctx, func() (err error) {
+		instance, err = r.GCEClient.GetInstance(ctx, project, zone, name)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get GCP instance: %v", err)
+		return nil, fmt.Errorf("failed to get GCP instance: %w", err)
 	}
+	return instance, nil
+}
 
-	newLabels := maps.Clone(instance.Labels)
-	if newLabels == nil {
-		newLabels = make(map[string]string)
+// regionFromProviderID derives the region of an AWS or GCP node from the zone of its provider ID.
+func regionFromProviderID(providerID string) (string, error) {
+	cloud, err := detectCloudFromProviderID(providerID)
+	if err != nil {
+		return "", err
+	}
+	switch cloud {
+	case "aws":
+		az, _, err := parseAWSProviderID(providerID)
+		if err != nil {
+			return "", err
+		}
+		return regionFromAZ(az)
+	case "gcp":
+		_, zone, _, err := parseGCPProviderID(providerID)
+		if err != nil {
+			return "", err
+		}
+		return regionFromGCPZone(zone)
 	}
+	return "", fmt.Errorf("%s provider IDs have no zone", cloud)
+}
 
-	// create a set of sanitized monitored keys for easy lookup
-	monitoredKeys := make(map[string]string) // sanitized -> original
-	for _, k := range r.Labels {
-		monitoredKeys[sanitizeKeyForGCP(k)] = k
+// cloudFor returns the cloud of the instance behind providerID: the configured cloud, or with
+// auto-detection the cloud of its provider ID.
+func (r *NodeLabelController) cloudFor(providerID string) string {
+	if r.Cloud != cloudAuto {
+		return r.Cloud
+	}
+	if cloud, err := detectCloudFromProviderID(providerID); err == nil {
+		return cloud
+	}
+	return r.Cloud
+}
+
+// clouds returns the clouds whose instances are tagged.
+func (r *NodeLabelController) clouds() []string {
+	if r.Cloud == cloudAuto && len(r.AutoClouds) > 0 {
+		return r.AutoClouds
 	}
+	if r.Cloud == cloudAuto {
+		return supportedClouds
+	}
+	return []string{r.Cloud}
+}
 
-	// remove any existing monitored labels that are no longer desired
-	for k := range newLabels {
-		if orig, isMonitored := monitoredKeys[k]; isMonitored {
-			if _, exists := desiredLabels[orig]; !exists {
-				delete(newLabels, k)
-			}
+// handlesCloud reports whether instances of cloud are tagged.
+func (r *NodeLabelController) handlesCloud(cloud string) bool {
+	return slices.Contains(r.clouds(), cloud)
+}
+
+// detectCloudFromProviderID returns the cloud a provider ID belongs to: "aws", "gcp", "azure", "do"
+// (for digitalocean:// provider IDs), "oci" or "openstack".
+func detectCloudFromProviderID(providerID string) (string, error) {
+	switch {
+	case strings.HasPrefix(providerID, "aws://"):
+		return "aws", nil
+	case strings.HasPrefix(providerID, "gce://"):
+		return "gcp", nil
+	case strings.HasPrefix(providerID, "azure://"):
+		return "azure", nil
+	case strings.HasPrefix(providerID, "digitalocean://"):
+		return "do", nil
+	case isOCIProviderID(providerID):
+		return "oci", nil
+	case strings.HasPrefix(providerID, "openstack://"):
+		return "openstack", nil
+	}
+	return "", fmt.Errorf("unknown cloud for provider ID %q", providerID)
+}
+
+// instanceKey returns a key identifying the cloud instance behind a provider ID. Provider
+// IDs that differ only in formatting (eg: the AWS availability zone segment) map to the same key.
+func instanceKey(providerID string) string {
+	switch {
+	case strings.HasPrefix(providerID, "aws://"):
+		return "aws/" + path.Base(providerID)
+	case strings.HasPrefix(providerID, "gce://"):
+		if project, zone, name, err := parseGCPProviderID(providerID); err == nil {
+			return path.Join("gcp", project, zone, name)
+		}
+	case strings.HasPrefix(providerID, "azure://"):
+		if subscription, resourceGroup, vm, err := parseAzureProviderID(providerID); err == nil {
+			return strings.ToLower(path.Join("azure", subscription, resourceGroup, vm))
+		}
+	case strings.HasPrefix(providerID, "digitalocean://"):
+		if dropletID, err := parseDOProviderID(providerID); err == nil {
+			return "do/" + dropletID
+		}
+	case isOCIProviderID(providerID):
+		if instanceID, err := parseOCIProviderID(providerID); err == nil {
+			return "oci/" + instanceID
+		}
+	case strings.HasPrefix(providerID, "openstack://"):
+		if instanceID, err := parseOpenStackProviderID(providerID); err == nil {
+			return "openstack/" + instanceID
 		}
 	}
+	return providerID
+}
 
-	// add or update desired labels
-	for k, v := range desiredLabels {
-		newLabels[sanitizeKeyForGCP(k)] = sanitizeValueForGCP(v)
+// sampleNode reports whether a node's tags should be written under the given sample rate.
+// The decision is derived from a hash of the node name so it's stable across reconciles
+// and restarts.
+func sampleNode(name string, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
 	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return float64(h.Sum32()%10000) < rate*10000
+}
 
-	// skip update if no changes
-	if maps.Equal(instance.Labels, newLabels) {
-		return nil
+// newCorrelationID returns a short random ID used to correlate the log lines of a single reconcile.
+func newCorrelationID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
 	}
+	return hex.EncodeToString(b)
+}
 
-	err = r.GCEClient.SetLabels(ctx, project, zone, name, &gce.InstancesSetLabelsRequest{
-		Labels:           newLabels,
-		LabelFingerprint: instance.LabelFingerprint,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to update GCP instance labels: %v", err)
+// providerID returns the provider ID of node's instance. For GCP nodes the project, zone and
+// instance name can be overridden by the node's GCP*Annotation annotations, eg: not those of AWS
+// nodes with --cloud=auto.
+func (r *NodeLabelController) providerID(node *corev1.Node) string {
+	providerID := node.Spec.ProviderID
+	if !r.handlesCloud("gcp") || (providerID != "" && r.cloudFor(providerID) != "gcp") {
+		return providerID
 	}
 
-	return nil
+	// the segments are validated once overridden, eg: a malformed zone can be fixed by an annotation
+	project, zone, name, _ := splitGCPProviderID(providerID)
+	overridden := false
+	for _, override := range []struct {
+		annotation string
+		value      *string
+	}{
+		{r.GCPProjectAnnotation, &project},
+		{r.GCPZoneAnnotation, &zone},
+		{r.GCPInstanceAnnotation, &name},
+	} {
+		if v := node.Annotations[override.annotation]; override.annotation != "" && v != "" {
+			*override.value = v
+			overridden = true
+		}
+	}
+
+	if !overridden || project == "" || zone == "" || name == "" {
+		return providerID
+	}
+	return "gce://" + path.Join(project, zone, name)
 }
 
+// parseGCPProviderID returns the project, zone and instance name of a GCP provider ID, eg:
+// gce://my-project/us-central1-a/instance-1. The zone is validated, so a malformed provider ID
+// fails here rather than with a 404 of the GCE API.
 func parseGCPProviderID(providerID string) (string, string, string, error) {
+	project, zone, name, err := splitGCPProviderID(providerID)
+	if err != nil {
+		return "", "", "", err
+	}
+	if project == "" || name == "" {
+		return "", "", "", fmt.Errorf("invalid GCP provider ID %q: empty project or instance name", providerID)
+	}
+	if err := validateGCPZone(zone); err != nil {
+		return "", "", "", fmt.Errorf("invalid GCP provider ID %q: %v", providerID, err)
+	}
+	return project, zone, name, nil
+}
+
+// splitGCPProviderID returns the project, zone and instance name segments of a GCP provider ID,
+// without validating them.
+func splitGCPProviderID(providerID string) (string, string, string, error) {
 	if !strings.HasPrefix(providerID, "gce://") {
 		return "", "", "", fmt.Errorf("providerID missing \"gce://\" prefix, this might not be a GCE node? %q", providerID)
 	}
@@ -301,30 +2623,127 @@ func parseGCPProviderID(providerID string) (string, string, string, error) {
 	return parts[0], parts[1], parts[2], nil
 }
 
+// sanitizeLabelsForGCP sanitizes the keys and values of labels for GCP, counting the keys and
+// values it changes. Keys colliding once sanitized are resolved by sanitizeKeysForGCP.
 func sanitizeLabelsForGCP(labels map[string]string) map[string]string {
+	return sanitizeLabelsWithKeysForGCP(labels, sanitizeKeysForGCP(slices.Collect(maps.Keys(labels))))
+}
+
+// sanitizeLabelsWithKeysForGCP is sanitizeLabelsForGCP with the sanitized keys looked up in keys,
+// eg: to resolve collisions over all managed keys rather than those of labels only.
+func sanitizeLabelsWithKeysForGCP(labels, keys map[string]string) map[string]string {
 	newLabels := make(map[string]string, len(labels))
 	for k, v := range labels {
-		newLabels[sanitizeKeyForGCP(k)] = sanitizeValueForGCP(v)
+		key, ok := keys[k]
+		if !ok {
+			key = sanitizeKeyForGCP(k)
+		}
+		value := sanitizeValueForGCP(v)
+		if key != k {
+			sanitizedKeys.Inc()
+		}
+		if value != v {
+			sanitizedValues.Inc()
+		}
+		// values are only truncated past 63 characters, other changes aren't truncations
+		if utf8.RuneCountInString(v) > 63 {
+			truncatedValues.Inc()
+		}
+		newLabels[key] = value
 	}
 	return newLabels
 }
 
+// sanitizeKeysForGCP maps keys to their sanitized GCP label keys. Distinct keys that collide once
+// sanitized, eg: Example/Key and example/key, would overwrite each other: the key that's already
+// sanitized, or else the first one in order, keeps the sanitized key and the others get a suffix
+// derived from a hash of the original key.
+func sanitizeKeysForGCP(keys []string) map[string]string {
+	byKey := make(map[string][]string)
+	for _, k := range slices.Sorted(slices.Values(keys)) {
+		sanitized := sanitizeKeyForGCP(k)
+		if !slices.Contains(byKey[sanitized], k) {
+			byKey[sanitized] = append(byKey[sanitized], k)
+		}
+	}
+
+	sanitized := make(map[string]string, len(keys))
+	for key, originals := range byKey {
+		keeper := originals[0]
+		if slices.Contains(originals, key) {
+			keeper = key
+		}
+		for _, k := range originals {
+			if k == keeper {
+				sanitized[k] = key
+				continue
+			}
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(k))
+			suffix := fmt.Sprintf("-%08x", h.Sum32())
+			sanitized[k] = strings.TrimRight(key[:min(len(key), 63-len(suffix))], "-_") + suffix
+		}
+	}
+	return sanitized
+}
+
+// gcpKeyCollisions returns the keys that collide with another key once sanitized for GCP, mapped
+// to their suffixed GCP label keys.
+func gcpKeyCollisions(keys []string) map[string]string {
+	collisions := make(map[string]string)
+	for k, key := range sanitizeKeysForGCP(keys) {
+		if key != sanitizeKeyForGCP(k) {
+			collisions[k] = key
+		}
+	}
+	return collisions
+}
+
 // sanitizeKeyForGCP sanitizes a Kubernetes label key to fit GCP's label key constraints
 func sanitizeKeyForGCP(key string) string {
 	key = strings.ToLower(key)
 	key = strings.NewReplacer("/", "_", ".", "-").Replace(key) // Replace disallowed characters
+	key = strings.Map(gcpLabelRune, key)                       // eg: the ':' of a k8s: tag prefix
 	key = strings.TrimRight(key, "-_")                         // Ensure it does not end with '-' or '_'
 
+	// GCP counts characters, and truncating bytes could split a multibyte one
+	if runes := []rune(key); len(runes) > 63 {
+		key = strings.TrimRight(string(runes[:63]), "-_")
+	}
+	return key
+}
+
+// legacySanitizeKeyForGCP is sanitizeKeyForGCP before it replaced all the characters GCP doesn't
+// allow, eg: the ':' of k8s:env, to find the labels written under the previous sanitized keys.
+func legacySanitizeKeyForGCP(key string) string {
+	key = strings.ToLower(key)
+	key = strings.NewReplacer("/", "_", ".", "-").Replace(key)
+	key = strings.TrimRight(key, "-_")
+
 	if len(key) > 63 {
 		key = key[:63]
 	}
 	return key
 }
 
-// sanitizeKeyForGCP sanitizes a Kubernetes label value to fit GCP's label value constraints
+// gcpLabelRune replaces the characters GCP doesn't allow in label keys and values with an
+// underscore. Lowercase letters, digits, underscores and dashes are allowed.
+func gcpLabelRune(r rune) rune {
+	if unicode.In(r, unicode.Ll, unicode.Lo, unicode.N) || r == '_' || r == '-' {
+		return r
+	}
+	return '_'
+}
+
+// sanitizeValueForGCP sanitizes a Kubernetes label value to fit GCP's label value constraints:
+// lowercase letters, digits, underscores and dashes, up to 63 characters.
 func sanitizeValueForGCP(value string) string {
-	if len(value) > 63 {
-		value = value[:63]
+	value = strings.ToLower(value)
+	value = strings.NewReplacer("/", "_", ".", "-").Replace(value)
+	value = strings.Map(gcpLabelRune, value)
+
+	if runes := []rune(value); len(runes) > 63 {
+		value = string(runes[:63])
 	}
 	return value
 }
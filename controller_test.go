@@ -2,21 +2,45 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 // mockEC2Client is a mock implementation of ec2Client for testing
@@ -24,12 +48,46 @@ type mockEC2Client struct {
 	currentTags []types.TagDescription
 	createdTags []types.Tag
 	deletedTags []types.Tag
+
+	// volumeIDs and networkInterfaceIDs are attached to the instance returned by DescribeInstances
+	volumeIDs           []string
+	networkInterfaceIDs []string
+
+	// describeTagsCalls and describeInstancesCalls count the calls of the read methods
+	describeTagsCalls      int
+	describeInstancesCalls int
+
+	// describeErr is returned by DescribeTags and DescribeInstances when set
+	describeErr error
 }
 
 func (m *mockEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	m.describeTagsCalls++
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
 	return &ec2.DescribeTagsOutput{Tags: m.currentTags}, nil
 }
 
+func (m *mockEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	m.describeInstancesCalls++
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+
+	instance := types.Instance{InstanceId: aws.String(params.InstanceIds[0])}
+	for _, tag := range m.currentTags {
+		instance.Tags = append(instance.Tags, types.Tag{Key: tag.Key, Value: tag.Value})
+	}
+	for _, id := range m.volumeIDs {
+		instance.BlockDeviceMappings = append(instance.BlockDeviceMappings, types.InstanceBlockDeviceMapping{Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String(id), DeleteOnTermination: aws.Bool(true)}})
+	}
+	for _, id := range m.networkInterfaceIDs {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, types.InstanceNetworkInterface{NetworkInterfaceId: aws.String(id)})
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{instance}}}}, nil
+}
+
 func (m *mockEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
 	m.createdTags = params.Tags
 	return &ec2.CreateTagsOutput{}, nil
@@ -44,6 +102,21 @@ func (m *mockEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsIn
 type mockGCEClient struct {
 	instance *gce.Instance
 	labels   map[string]string
+
+	// disks are returned by GetDisk by name, diskLabels records the labels set by SetDiskLabels
+	disks      map[string]*gce.Disk
+	diskLabels map[string]map[string]string
+
+	// setDiskLabelsErr is returned by SetDiskLabels when set
+	setDiskLabelsErr error
+
+	// setLabelsErr is returned by SetLabels when set
+	setLabelsErr error
+
+	// setLabelsErrs are returned by the first SetLabels calls, in order, and setLabelsCalls
+	// counts the calls
+	setLabelsErrs  []error
+	setLabelsCalls int
 }
 
 func (m *mockGCEClient) GetInstance(ctx context.Context, project, zone, instance string) (*gce.Instance, error) {
@@ -51,10 +124,174 @@ func (m *mockGCEClient) GetInstance(ctx context.Context, project, zone, instance
 }
 
 func (m *mockGCEClient) SetLabels(ctx context.Context, project, zone, instance string, req *gce.InstancesSetLabelsRequest) error {
+	m.setLabelsCalls++
+	if len(m.setLabelsErrs) > 0 {
+		err := m.setLabelsErrs[0]
+		m.setLabelsErrs = m.setLabelsErrs[1:]
+		return err
+	}
+	if m.setLabelsErr != nil {
+		return m.setLabelsErr
+	}
 	m.labels = req.Labels
 	return nil
 }
 
+func (m *mockGCEClient) GetDisk(ctx context.Context, project, zone, disk string) (*gce.Disk, error) {
+	d, ok := m.disks[disk]
+	if !ok {
+		return nil, fmt.Errorf("disk %s not found", disk)
+	}
+	return d, nil
+}
+
+func (m *mockGCEClient) SetDiskLabels(ctx context.Context, project, zone, disk string, req *gce.ZoneSetLabelsRequest) error {
+	if m.setDiskLabelsErr != nil {
+		return m.setDiskLabelsErr
+	}
+	if m.diskLabels == nil {
+		m.diskLabels = make(map[string]map[string]string)
+	}
+	m.diskLabels[disk] = req.Labels
+	return nil
+}
+
+// mockAzureClient is a mock implementation of azureClient for testing
+type mockAzureClient struct {
+	currentTags map[string]string
+	mergedTags  map[string]string
+	deletedTags map[string]string
+	resourceIDs []string
+}
+
+func (m *mockAzureClient) GetTags(ctx context.Context, resourceID string) (map[string]string, error) {
+	m.resourceIDs = append(m.resourceIDs, resourceID)
+	return m.currentTags, nil
+}
+
+func (m *mockAzureClient) MergeTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	m.mergedTags = tags
+	return nil
+}
+
+func (m *mockAzureClient) DeleteTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	m.deletedTags = tags
+	return nil
+}
+
+// paginatedEC2Client is an ec2Client whose DescribeTags returns its tags one page at a time
+type paginatedEC2Client struct {
+	mockEC2Client
+	pages     [][]types.TagDescription
+	nextToken []*string
+}
+
+func (m *paginatedEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	m.nextToken = append(m.nextToken, params.NextToken)
+
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(*params.NextToken)
+	}
+	out := &ec2.DescribeTagsOutput{Tags: m.pages[page]}
+	if page+1 < len(m.pages) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+// throttlingEC2Client is a mockEC2Client whose calls fail with a throttling error until
+// throttledCalls calls were made
+type throttlingEC2Client struct {
+	mockEC2Client
+	throttledCalls int
+	calls          int
+}
+
+func (m *throttlingEC2Client) throttle() error {
+	m.calls++
+	if m.calls <= m.throttledCalls {
+		return &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."}
+	}
+	return nil
+}
+
+func (m *throttlingEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	if err := m.throttle(); err != nil {
+		return nil, err
+	}
+	return m.mockEC2Client.DescribeTags(ctx, params, optFns...)
+}
+
+func (m *throttlingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	if err := m.throttle(); err != nil {
+		return nil, err
+	}
+	return m.mockEC2Client.CreateTags(ctx, params, optFns...)
+}
+
+// callRecordingEC2Client is a mockEC2Client that records the tags of every CreateTags and
+// DeleteTags call, and the order of the calls
+type callRecordingEC2Client struct {
+	mockEC2Client
+	createCalls [][]types.Tag
+	deleteCalls [][]types.Tag
+	calls       []string
+
+	// createErr is returned by the CreateTags call number failCreateCall, counting from 1
+	createErr      error
+	failCreateCall int
+}
+
+func (m *callRecordingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.createCalls = append(m.createCalls, params.Tags)
+	m.calls = append(m.calls, "CreateTags")
+	if len(m.createCalls) == m.failCreateCall {
+		return nil, m.createErr
+	}
+	return m.mockEC2Client.CreateTags(ctx, params, optFns...)
+}
+
+func (m *callRecordingEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	m.deleteCalls = append(m.deleteCalls, params.Tags)
+	m.calls = append(m.calls, "DeleteTags")
+	return m.mockEC2Client.DeleteTags(ctx, params, optFns...)
+}
+
+// concurrencyTrackingEC2Client is an ec2Client that records how many syncs were in flight at
+// once, from the DescribeTags read to the CreateTags write.
+type concurrencyTrackingEC2Client struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *concurrencyTrackingEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	m.mu.Lock()
+	m.inFlight++
+	m.maxInFlight = max(m.maxInFlight, m.inFlight)
+	m.mu.Unlock()
+
+	// widen the race window between reading and writing tags
+	time.Sleep(20 * time.Millisecond)
+	return &ec2.DescribeTagsOutput{}, nil
+}
+
+func (m *concurrencyTrackingEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{}, nil
+}
+
+func (m *concurrencyTrackingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *concurrencyTrackingEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
 func TestReconcileAWS(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -90,7 +327,19 @@ func TestReconcileAWS(t *testing.T) {
 				{Key: aws.String("env"), Value: aws.String("prod")},
 			},
 			deletesTags: []types.Tag{
-				{Key: aws.String("env")},
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+		},
+		{
+			name:         "delete is scoped to the observed value",
+			labelsToCopy: []string{"env", "team"},
+			node:         createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"),
+			currentTags: []types.TagDescription{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
+			},
+			deletesTags: []types.Tag{
+				{Key: aws.String("team"), Value: aws.String("platform")},
 			},
 		},
 		{
@@ -106,7 +355,2929 @@ func TestReconcileAWS(t *testing.T) {
 				{Key: aws.String("env"), Value: aws.String("staging")},
 				{Key: aws.String("cost-center"), Value: aws.String("12345")},
 			},
-			createsTags: []types.Tag{
+			createsTags: []types.Tag{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			k8s := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(tt.node).
+				Build()
+
+			mock := &mockEC2Client{currentTags: tt.currentTags}
+
+			r := &NodeLabelController{
+				Client:    k8s,
+				Labels:    tt.labelsToCopy,
+				Cloud:     "aws",
+				EC2Client: mock,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: client.ObjectKey{Name: tt.node.Name},
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.createsTags, mock.createdTags)
+			assert.Equal(t, tt.deletesTags, mock.deletedTags)
+		})
+	}
+}
+
+func TestReconcileAWSDescribeTagsPagination(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "platform"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &paginatedEC2Client{
+		pages: [][]types.TagDescription{
+			{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("cost-center"), Value: aws.String("12345")},
+			},
+			{
+				{Key: aws.String("team"), Value: aws.String("platform")},
+				{Key: aws.String("zone"), Value: aws.String("us-east-1a")},
+			},
+		},
+	}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env", "team", "zone"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []*string{nil, aws.String("1")}, mock.nextToken)
+	// tags on the second page are neither recreated nor missed for deletion
+	assert.Nil(t, mock.createdTags)
+	assert.Equal(t, []types.Tag{{Key: aws.String("zone"), Value: aws.String("us-east-1a")}}, mock.deletedTags)
+}
+
+func TestReconcileAWSThrottlingRetries(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+
+	t.Run("retried until success", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+
+		// DescribeTags is throttled twice, CreateTags once
+		mock := &throttlingEC2Client{throttledCalls: 2}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, AWSMaxRetries: 3, awsRetryBaseDelay: time.Millisecond}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, 4, mock.calls)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+	})
+
+	t.Run("retries used up", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+
+		mock := &throttlingEC2Client{throttledCalls: 10}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, AWSMaxRetries: 2, awsRetryBaseDelay: time.Millisecond}
+
+		// the node is requeued after a backoff rather than failing
+		res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, 3, mock.calls)
+		assert.GreaterOrEqual(t, res.RequeueAfter, defaultCloudRetryBaseDelay/2)
+		assert.LessOrEqual(t, res.RequeueAfter, defaultCloudRetryBaseDelay)
+	})
+}
+
+func TestReconcileAWSLongAnnotationValue(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := withAnnotations(createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{
+		"example.com/description": strings.Repeat("x", 1000),
+	})
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{}
+	r := &NodeLabelController{Client: k8s, Annotations: []string{"example.com/description"}, Cloud: "aws", EC2Client: mock}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	want := strings.Repeat("x", maxAWSTagValueLength)
+	assert.Equal(t, []types.Tag{{Key: aws.String("example.com/description"), Value: aws.String(want)}}, mock.createdTags)
+
+	// the truncated tag is up to date
+	mock.createdTags = nil
+	mock.currentTags = []types.TagDescription{{Key: aws.String("example.com/description"), Value: aws.String(want)}}
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Nil(t, mock.createdTags)
+}
+
+func TestReconcileAWSReservedKey(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "aws:env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the reserved tag is neither created nor deleted, the other tags are still synced
+	mock := &mockEC2Client{currentTags: []types.TagDescription{
+		{Key: aws.String("aws:env"), Value: aws.String("staging")},
+		{Key: aws.String("team"), Value: aws.String("a")},
+	}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env", "aws:env", "team"}, Cloud: "aws", EC2Client: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+	assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("a")}}, mock.deletedTags)
+}
+
+func TestReconcileInvalidKeys(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	longKey := "example.com/" + strings.Repeat("k", maxAWSTagKeyLength)
+	labels := map[string]string{"env": "prod", longKey: "a", "team#1": "b", "1team": "c", "zone": "us-1"}
+	keys := []string{"env", longKey, "team#1", "1team", "zone"}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", labels, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the invalid keys are skipped, the valid tags are still synced in the same call
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: keys, Cloud: "aws", EC2Client: mock}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("1team"), Value: aws.String("c")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("zone"), Value: aws.String("us-1")},
+		}, mock.createdTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", labels, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// keys are sanitized, those still invalid are skipped
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"owner": "ops"}}}
+		r := &NodeLabelController{Client: k8s, Labels: keys, Cloud: "gcp", GCEClient: mock}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"owner":                    "ops",
+			"env":                      "prod",
+			sanitizeKeyForGCP(longKey): "a",
+			"team_1":                   "b",
+			"zone":                     "us-1",
+		}, mock.labels)
+	})
+}
+
+func TestValidateAWSTagKeys(t *testing.T) {
+	r := &NodeLabelController{Labels: []string{"env"}, KeyAliases: map[string]string{"env": "environment"}}
+	assert.NoError(t, r.validateAWSTagKeys())
+
+	r.Labels = append(r.Labels, "aws:team")
+	assert.ErrorContains(t, r.validateAWSTagKeys(), `tag keys ["aws:team"] use the reserved AWS prefix "aws:"`)
+
+	// keys are validated after their prefix is applied
+	r = &NodeLabelController{Labels: []string{"env"}, TagPrefix: "AWS:"}
+	assert.ErrorContains(t, r.validateAWSTagKeys(), `tag keys ["AWS:env"]`)
+
+	r.Cloud = "aws"
+	assert.ErrorContains(t, r.SetupCloudProvider(context.Background()), "reserved AWS prefix")
+}
+
+func TestReconcileAWSBatches(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// more labels than fit in a single call, half of them already tagged with an old value
+	count := maxAWSTagsPerCall + 10
+	labels := make(map[string]string)
+	var keys []string
+	var currentTags []types.TagDescription
+	var wantTags, wantDeletes []types.Tag
+	for i := range count {
+		k := fmt.Sprintf("key-%03d", i)
+		keys = append(keys, k)
+		labels[k] = "new"
+		wantTags = append(wantTags, types.Tag{Key: aws.String(k), Value: aws.String("new")})
+	}
+	for i := range count {
+		k := fmt.Sprintf("stale-%03d", i)
+		keys = append(keys, k)
+		currentTags = append(currentTags, types.TagDescription{Key: aws.String(k), Value: aws.String("old")})
+		wantDeletes = append(wantDeletes, types.Tag{Key: aws.String(k), Value: aws.String("old")})
+	}
+
+	node := createNode("node1", labels, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &callRecordingEC2Client{mockEC2Client: mockEC2Client{currentTags: currentTags}}
+	r := &NodeLabelController{Client: k8s, Labels: keys, Cloud: "aws", EC2Client: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]types.Tag{wantTags[:maxAWSTagsPerCall], wantTags[maxAWSTagsPerCall:]}, mock.createCalls)
+	assert.Equal(t, [][]types.Tag{wantDeletes[:maxAWSTagsPerCall], wantDeletes[maxAWSTagsPerCall:]}, mock.deleteCalls)
+	// stale tags are deleted first, to make room for the new ones
+	assert.Equal(t, []string{"DeleteTags", "DeleteTags", "CreateTags", "CreateTags"}, mock.calls)
+}
+
+func TestReconcileAWSTagApplyOrder(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "a", "owner": "alice", "cost-center": "123", "project": "x"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the up to date project tag isn't created again
+	mock := &callRecordingEC2Client{mockEC2Client: mockEC2Client{currentTags: []types.TagDescription{
+		{Key: aws.String("project"), Value: aws.String("x")},
+		{Key: aws.String("stale"), Value: aws.String("old")},
+	}}}
+	r := &NodeLabelController{
+		Client:           k8s,
+		Labels:           []string{"env", "team", "owner", "cost-center", "project", "stale"},
+		Cloud:            "aws",
+		EC2Client:        mock,
+		AWSTagApplyOrder: []string{"team", "project", "env"},
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]types.Tag{
+		{{Key: aws.String("team"), Value: aws.String("a")}},
+		{{Key: aws.String("env"), Value: aws.String("prod")}},
+		{{Key: aws.String("cost-center"), Value: aws.String("123")}, {Key: aws.String("owner"), Value: aws.String("alice")}},
+	}, mock.createCalls)
+	assert.Equal(t, []string{"DeleteTags", "CreateTags", "CreateTags", "CreateTags"}, mock.calls)
+}
+
+func TestReconcileAWSBatchError(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	labels := make(map[string]string)
+	var keys []string
+	for i := range 2*maxAWSTagsPerCall + 1 {
+		k := fmt.Sprintf("key-%03d", i)
+		keys = append(keys, k)
+		labels[k] = "new"
+	}
+	node := createNode("node1", labels, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the second of three batches fails, the last one isn't attempted
+	mock := &callRecordingEC2Client{createErr: errors.New("TagLimitExceeded"), failCreateCall: 2}
+	r := &NodeLabelController{Client: k8s, Labels: keys, Cloud: "aws", EC2Client: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	assert.ErrorContains(t, err, "failed to create AWS tags: TagLimitExceeded")
+	assert.Len(t, mock.createCalls, 2)
+}
+
+func TestReconcileGCP(t *testing.T) {
+	tests := []struct {
+		name          string
+		labelsToCopy  []string
+		node          *corev1.Node
+		currentLabels map[string]string
+		wantLabels    map[string]string
+	}{
+		{
+			name:          "sync new labels",
+			labelsToCopy:  []string{"env", "team"},
+			node:          createNode("node1", map[string]string{"env": "prod", "team": "platform"}, "gce://my-project/us-central1-a/instance-1"),
+			currentLabels: map[string]string{"env": "staging"},
+			wantLabels: map[string]string{
+				"env":  "prod",
+				"team": "platform",
+			},
+		},
+		{
+			name:         "preserve unmanaged labels",
+			labelsToCopy: []string{"env"},
+			node:         createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
+			currentLabels: map[string]string{
+				"env":         "staging",
+				"cost-center": "12345",
+			},
+			wantLabels: map[string]string{
+				"env":         "prod",
+				"cost-center": "12345",
+			},
+		},
+		{
+			name:         "remove label",
+			labelsToCopy: []string{"env"},
+			node:         createNode("node1", nil, "gce://my-project/us-central1-a/instance-1"),
+			currentLabels: map[string]string{
+				"env":         "prod",
+				"cost-center": "12345",
+			},
+			wantLabels: map[string]string{
+				"cost-center": "12345",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			k8s := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(tt.node).
+				Build()
+
+			mock := &mockGCEClient{instance: &gce.Instance{Labels: tt.currentLabels}}
+
+			r := &NodeLabelController{
+				Client:    k8s,
+				Labels:    tt.labelsToCopy,
+				Cloud:     "gcp",
+				GCEClient: mock,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: client.ObjectKey{Name: tt.node.Name},
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantLabels, mock.labels)
+		})
+	}
+}
+
+func TestReconcileAzure(t *testing.T) {
+	const providerID = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"
+
+	tests := []struct {
+		name         string
+		labelsToSync []string
+		nodeLabels   map[string]string
+		currentTags  map[string]string
+		wantMerged   map[string]string
+		wantDeleted  map[string]string
+	}{
+		{
+			name:         "sync new tags",
+			labelsToSync: []string{"env", "team"},
+			nodeLabels:   map[string]string{"env": "prod", "team": "platform"},
+			currentTags:  map[string]string{"env": "staging"},
+			wantMerged:   map[string]string{"env": "prod", "team": "platform"},
+		},
+		{
+			name:         "preserve unmanaged tags",
+			labelsToSync: []string{"env"},
+			nodeLabels:   map[string]string{"env": "prod"},
+			currentTags:  map[string]string{"env": "prod", "cost-center": "12345"},
+		},
+		{
+			name:         "remove tag with its observed value",
+			labelsToSync: []string{"env", "team"},
+			nodeLabels:   map[string]string{"env": "prod"},
+			currentTags:  map[string]string{"env": "prod", "team": "platform", "cost-center": "12345"},
+			wantDeleted:  map[string]string{"team": "platform"},
+		},
+		{
+			name:         "tag names are case-insensitive",
+			labelsToSync: []string{"env", "team"},
+			nodeLabels:   map[string]string{"env": "prod"},
+			currentTags:  map[string]string{"Env": "prod", "TEAM": "platform"},
+			wantDeleted:  map[string]string{"TEAM": "platform"},
+		},
+		{
+			name:         "differently cased tag is updated",
+			labelsToSync: []string{"env"},
+			nodeLabels:   map[string]string{"env": "prod"},
+			currentTags:  map[string]string{"Env": "staging"},
+			wantMerged:   map[string]string{"env": "prod"},
+		},
+		{
+			name:         "sanitized keys",
+			labelsToSync: []string{"topology.kubernetes.io/zone"},
+			nodeLabels:   map[string]string{"topology.kubernetes.io/zone": "eastus-1"},
+			currentTags:  map[string]string{"topology.kubernetes.io_zone": "eastus-2"},
+			wantMerged:   map[string]string{"topology.kubernetes.io_zone": "eastus-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			node := createNode("node1", tt.nodeLabels, providerID)
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			mock := &mockAzureClient{currentTags: tt.currentTags}
+			r := &NodeLabelController{
+				Client:      k8s,
+				Labels:      tt.labelsToSync,
+				Cloud:       "azure",
+				AzureClient: mock,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"}, mock.resourceIDs)
+			assert.Equal(t, tt.wantMerged, mock.mergedTags)
+			assert.Equal(t, tt.wantDeleted, mock.deletedTags)
+		})
+	}
+}
+
+func TestReconcileCloudLabels(t *testing.T) {
+	cloudLabels := map[string][]string{
+		"aws": {"team"},
+		"gcp": {"zone"},
+	}
+	nodeLabels := map[string]string{"env": "prod", "team": "platform", "zone": "a"}
+
+	t.Run("aws", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		require.NoError(t, corev1.AddToScheme(scheme))
+
+		node := createNode("node1", nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				// env is only managed for nodes without a cloud specific key set
+				{Key: aws.String("env"), Value: aws.String("staging")},
+			},
+		}
+		r := &NodeLabelController{
+			Client:      k8s,
+			Labels:      []string{"env"},
+			CloudLabels: cloudLabels,
+			Cloud:       "aws",
+			EC2Client:   mock,
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		require.NoError(t, corev1.AddToScheme(scheme))
+
+		node := createNode("node1", nodeLabels, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"team": "billing"}}}
+		r := &NodeLabelController{
+			Client:      k8s,
+			Labels:      []string{"env"},
+			CloudLabels: cloudLabels,
+			Cloud:       "gcp",
+			GCEClient:   mock,
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{"team": "billing", "zone": "a"}, mock.labels)
+	})
+
+	t.Run("azure falls back to labels", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		require.NoError(t, corev1.AddToScheme(scheme))
+
+		node := createNode("node1", nodeLabels, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockAzureClient{}
+		r := &NodeLabelController{
+			Client:      k8s,
+			Labels:      []string{"env"},
+			CloudLabels: cloudLabels,
+			Cloud:       "azure",
+			AzureClient: mock,
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.mergedTags)
+	})
+}
+
+func TestReconcileCloudKeyAliases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"topology.kubernetes.io/region": "us-east-1"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{}
+	r := &NodeLabelController{
+		Client:      k8s,
+		Labels:      []string{"topology.kubernetes.io/region"},
+		CloudLabels: map[string][]string{"aws": {"topology.kubernetes.io/region"}},
+		Cloud:       "aws",
+		EC2Client:   mock,
+		KeyAliases:  map[string]string{"topology.kubernetes.io/region": "region"},
+		CloudKeyAliases: map[string]map[string]string{
+			"aws": {"topology.kubernetes.io/region": "Region"},
+		},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{{Key: aws.String("Region"), Value: aws.String("us-east-1")}}, mock.createdTags)
+
+	// deletes are detected by the cloud specific tag key
+	delete(node.Labels, "topology.kubernetes.io/region")
+	require.NoError(t, k8s.Update(context.Background(), node))
+	mock.currentTags = []types.TagDescription{
+		{Key: aws.String("Region"), Value: aws.String("us-east-1")},
+		{Key: aws.String("region"), Value: aws.String("us-east-1")},
+	}
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{{Key: aws.String("Region"), Value: aws.String("us-east-1")}}, mock.deletedTags)
+}
+
+func TestReconcileAnnotationTags(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	annotations, tagKeys, err := parseAnnotationTags("finops.example.com/cost-center:CostCenter,example.com/owner:Owner")
+	require.NoError(t, err)
+
+	node := withAnnotations(createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{
+		"finops.example.com/cost-center": "12345",
+	})
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{
+		currentTags: []types.TagDescription{
+			{Key: aws.String("Owner"), Value: aws.String("team-a")},
+			{Key: aws.String("owner"), Value: aws.String("team-b")},
+		},
+	}
+	r := &NodeLabelController{Client: k8s, Annotations: annotations, KeyAliases: tagKeys, Cloud: "aws", EC2Client: mock}
+
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{{Key: aws.String("CostCenter"), Value: aws.String("12345")}}, mock.createdTags)
+
+	// the owner annotation is missing: its tag is removed by the target name only
+	assert.Equal(t, []types.Tag{{Key: aws.String("Owner"), Value: aws.String("team-a")}}, mock.deletedTags)
+}
+
+func TestReconcileMissingValue(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "topology.kubernetes.io/region": "us-east-1"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the team tag of a removed label is set to the missing value instead of being deleted
+		mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("team"), Value: aws.String("a")}}}
+		r := &NodeLabelController{
+			Client:      k8s,
+			Labels:      []string{"env", "team", "topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"},
+			Annotations: []string{"example.com/owner"},
+			KeyAliases: map[string]string{
+				"topology.kubernetes.io/region":            "region",
+				"failure-domain.beta.kubernetes.io/region": "region",
+			},
+			MissingValue: "unknown",
+			Cloud:        "aws",
+			EC2Client:    mock,
+		}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		// the missing legacy region label doesn't override the region label with the same tag key
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("example.com/owner"), Value: aws.String("unknown")},
+			{Key: aws.String("region"), Value: aws.String("us-east-1")},
+			{Key: aws.String("team"), Value: aws.String("unknown")},
+		}, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"team": "a"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, MissingValue: "Unknown", Cloud: "gcp", GCEClient: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "team": "unknown"}, mock.labels)
+	})
+}
+
+func TestReconcileStaticTags(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+	staticTags := map[string]string{"managed-by": "k8s-node-tagger", "cluster": "prod-us-east"}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "cluster": "from-label"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the outdated static tag is updated, the removed team label's tag deleted
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("managed-by"), Value: aws.String("someone")},
+			{Key: aws.String("team"), Value: aws.String("a")},
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team", "cluster"}, StaticTags: staticTags, Cloud: "aws", EC2Client: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("cluster"), Value: aws.String("prod-us-east")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("managed-by"), Value: aws.String("k8s-node-tagger")},
+		}, mock.createdTags)
+		assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("a")}}, mock.deletedTags)
+	})
+
+	t.Run("aws without labels", func(t *testing.T) {
+		node := createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("k8s:cluster"), Value: aws.String("prod-us-east")},
+			{Key: aws.String("k8s:managed-by"), Value: aws.String("k8s-node-tagger")},
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, StaticTags: staticTags, TagPrefix: "k8s:", Cloud: "aws", EC2Client: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"team": "a", "cluster": "old"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, StaticTags: staticTags, Cloud: "gcp", GCEClient: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "cluster": "prod-us-east", "managed-by": "k8s-node-tagger"}, mock.labels)
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockAzureClient{currentTags: map[string]string{"cluster": "prod-us-east", "owner": "someone"}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, StaticTags: staticTags, Cloud: "azure", AzureClient: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "managed-by": "k8s-node-tagger"}, mock.mergedTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("cluster"), Value: aws.String("prod-us-east")},
+		}}
+		r := &NodeLabelController{Labels: []string{"env"}, StaticTags: staticTags, Cloud: "aws", EC2Client: mock}
+
+		require.NoError(t, r.cleanupInstance(context.Background(), "node1", "aws:///us-east-1a/i-1234567890abcdef0", false))
+		assert.Equal(t, []types.Tag{{Key: aws.String("cluster"), Value: aws.String("prod-us-east")}}, mock.deletedTags)
+	})
+}
+
+func TestReconcileManagedByTag(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "team": "a"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the owner label was synced before it was removed from the configuration
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("k8s-node-tagger"), Value: aws.String("env owner")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("owner"), Value: aws.String("alice")},
+			{Key: aws.String("cost-center"), Value: aws.String("123")},
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, ManagedByTag: "k8s-node-tagger", Cloud: "aws", EC2Client: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("k8s-node-tagger"), Value: aws.String("env team")},
+			{Key: aws.String("team"), Value: aws.String("a")},
+		}, mock.createdTags)
+		assert.Equal(t, []types.Tag{{Key: aws.String("owner"), Value: aws.String("alice")}}, mock.deletedTags)
+	})
+
+	t.Run("aws without synced labels", func(t *testing.T) {
+		node := createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("k8s-node-tagger"), Value: aws.String("env")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"team"}, ManagedByTag: "k8s-node-tagger", Cloud: "aws", EC2Client: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.createdTags)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("k8s-node-tagger"), Value: aws.String("env")},
+		}, mock.deletedTags)
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockAzureClient{currentTags: map[string]string{
+			"K8s-Node-Tagger": "env owner",
+			"env":             "prod",
+			"Owner":           "alice",
+			"cost-center":     "123",
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, ManagedByTag: "k8s-node-tagger", Cloud: "azure", AzureClient: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"k8s-node-tagger": "env"}, mock.mergedTags)
+		assert.Equal(t, map[string]string{"Owner": "alice"}, mock.deletedTags)
+	})
+}
+
+func TestValidateManagedByTag(t *testing.T) {
+	r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws"}
+	assert.NoError(t, r.validateManagedByTag())
+
+	r.ManagedByTag = "k8s-node-tagger"
+	assert.NoError(t, r.validateManagedByTag())
+
+	r.Cloud = "gcp"
+	assert.ErrorContains(t, r.validateManagedByTag(), "not supported on GCP")
+
+	r.Cloud = "aws"
+	r.KeyAliases = map[string]string{"team": "Team Name"}
+	assert.ErrorContains(t, r.validateManagedByTag(), `tag key "Team Name" can't be listed in the managed-by tag`)
+
+	r.KeyAliases = nil
+	r.Labels = nil
+	for i := range 30 {
+		r.Labels = append(r.Labels, fmt.Sprintf("label-%03d", i))
+	}
+	assert.ErrorContains(t, r.validateManagedByTag(), "exceed the 256 characters of the managed-by tag")
+
+	r.Cloud = "azure"
+	assert.ErrorContains(t, r.SetupCloudProvider(context.Background()), "exceed the 256 characters")
+}
+
+func TestReconcileTagPrefix(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		node.UID = "1234"
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				// tags of other tools are left alone
+				{Key: aws.String("env"), Value: aws.String("staging")},
+				{Key: aws.String("team"), Value: aws.String("a")},
+				{Key: aws.String("k8s:team"), Value: aws.String("a")},
+			},
+		}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, TagPrefix: "k8s:", NodeUIDTag: "node-uid"}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("k8s:env"), Value: aws.String("prod")},
+			{Key: aws.String("k8s:node-uid"), Value: aws.String("1234")},
+		}, mock.createdTags)
+		assert.Equal(t, []types.Tag{{Key: aws.String("k8s:team"), Value: aws.String("a")}}, mock.deletedTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"env": "staging", "team": "a", "k8s_team": "a"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "gcp", GCEClient: mock, TagPrefix: "k8s:"}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "staging", "team": "a", "k8s_env": "prod"}, mock.labels)
+	})
+
+	t.Run("gcp labels of the previous sanitized keys", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"env": "staging", "k8s:env": "staging", "k8s:team": "a"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "gcp", GCEClient: mock, TagPrefix: "k8s:"}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "staging", "k8s_env": "prod"}, mock.labels)
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// '/' isn't allowed in Azure tag names, the prefix is sanitized like the rest of the key
+		mock := &mockAzureClient{currentTags: map[string]string{"env": "staging", "team": "a", "k8s_team": "a"}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "azure", AzureClient: mock, TagPrefix: "k8s/"}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"k8s_env": "prod"}, mock.mergedTags)
+		assert.Equal(t, map[string]string{"k8s_team": "a"}, mock.deletedTags)
+	})
+}
+
+func TestMonitoredLabels(t *testing.T) {
+	r := &NodeLabelController{
+		Labels: []string{"env", "team"},
+		CloudLabels: map[string][]string{
+			"aws": {"team", "cost-center"},
+			"gcp": {"zone"},
+		},
+	}
+	assert.Equal(t, []string{"cost-center", "env", "team", "zone"}, r.monitoredLabels())
+
+	r.ClusterNameTag = "cluster"
+	r.ClusterName = &clusterNameResolver{Label: defaultClusterNameLabel}
+	assert.Contains(t, r.monitoredLabels(), defaultClusterNameLabel)
+
+	r.ClusterName.Name = "prod"
+	assert.NotContains(t, r.monitoredLabels(), defaultClusterNameLabel)
+
+	r.TagRegionFromProviderID = true
+	assert.Contains(t, r.monitoredLabels(), corev1.LabelTopologyRegion)
+}
+
+func TestGCPProviderIDOverride(t *testing.T) {
+	r := &NodeLabelController{
+		Cloud:                 "gcp",
+		GCPProjectAnnotation:  "example.com/gcp-project",
+		GCPZoneAnnotation:     "example.com/gcp-zone",
+		GCPInstanceAnnotation: "example.com/gcp-instance",
+	}
+
+	tests := []struct {
+		name        string
+		providerID  string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name:       "no annotations",
+			providerID: "gce://my-project/us-central1-a/instance-1",
+			want:       "gce://my-project/us-central1-a/instance-1",
+		},
+		{
+			name:        "zone and instance overridden",
+			providerID:  "gce://my-project/us-central1-a/instance-1",
+			annotations: map[string]string{"example.com/gcp-zone": "us-central1-b", "example.com/gcp-instance": "instance-2"},
+			want:        "gce://my-project/us-central1-b/instance-2",
+		},
+		{
+			name:        "malformed zone overridden",
+			providerID:  "gce://my-project/us-central1/instance-1",
+			annotations: map[string]string{"example.com/gcp-zone": "us-central1-a"},
+			want:        "gce://my-project/us-central1-a/instance-1",
+		},
+		{
+			name:       "all overridden",
+			providerID: "gce://wrong/wrong/wrong",
+			annotations: map[string]string{
+				"example.com/gcp-project":  "other-project",
+				"example.com/gcp-zone":     "europe-west1-c",
+				"example.com/gcp-instance": "instance-3",
+			},
+			want: "gce://other-project/europe-west1-c/instance-3",
+		},
+		{
+			name:       "missing provider ID is built from the annotations",
+			providerID: "",
+			annotations: map[string]string{
+				"example.com/gcp-project":  "other-project",
+				"example.com/gcp-zone":     "europe-west1-c",
+				"example.com/gcp-instance": "instance-3",
+			},
+			want: "gce://other-project/europe-west1-c/instance-3",
+		},
+		{
+			name:        "incomplete override keeps the provider ID",
+			providerID:  "",
+			annotations: map[string]string{"example.com/gcp-instance": "instance-3"},
+			want:        "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := withAnnotations(createNode("node1", nil, tt.providerID), tt.annotations)
+			assert.Equal(t, tt.want, r.providerID(node))
+		})
+	}
+
+	t.Run("other clouds are not overridden", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "aws", GCPInstanceAnnotation: "example.com/gcp-instance"}
+		node := withAnnotations(createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{"example.com/gcp-instance": "instance-3"})
+		assert.Equal(t, "aws:///us-east-1a/i-1234567890abcdef0", r.providerID(node))
+
+		// nor the nodes of other clouds when GCP is one of several
+		r = &NodeLabelController{Cloud: cloudAuto, GCPInstanceAnnotation: "example.com/gcp-instance"}
+		assert.Equal(t, "aws:///us-east-1a/i-1234567890abcdef0", r.providerID(node))
+		node = withAnnotations(createNode("node2", nil, "gce://my-project/us-central1-a/instance-1"), map[string]string{"example.com/gcp-instance": "instance-3"})
+		assert.Equal(t, "gce://my-project/us-central1-a/instance-3", r.providerID(node))
+	})
+}
+
+func TestReconcileGCPProviderIDOverrideRetarget(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &countingGCEClient{gets: map[string]int{}, labels: map[string]map[string]string{}}
+	r := &NodeLabelController{
+		Client:                k8s,
+		Labels:                []string{"env"},
+		Cloud:                 "gcp",
+		GCEClient:             mock,
+		GCPInstanceAnnotation: "example.com/gcp-instance",
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"env": "prod"}, mock.labels["instance-1"])
+
+	// the annotation change passes the event filter
+	retargeted := withAnnotations(node.DeepCopy(), map[string]string{"example.com/gcp-instance": "instance-2"})
+	assert.True(t, r.eventFilter().Update(event.UpdateEvent{ObjectOld: node, ObjectNew: retargeted}))
+
+	// the managed labels move to the new instance
+	require.NoError(t, k8s.Update(context.Background(), retargeted))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, mock.labels["instance-2"])
+	assert.Empty(t, mock.labels["instance-1"])
+}
+
+// locationRecordingGCEClient is a mockGCEClient that records the instances it was called for
+type locationRecordingGCEClient struct {
+	mockGCEClient
+	instances []string
+}
+
+func (m *locationRecordingGCEClient) GetInstance(ctx context.Context, project, zone, instance string) (*gce.Instance, error) {
+	m.instances = append(m.instances, path.Join(project, zone, instance))
+	return m.mockGCEClient.GetInstance(ctx, project, zone, instance)
+}
+
+func (m *locationRecordingGCEClient) SetLabels(ctx context.Context, project, zone, instance string, req *gce.InstancesSetLabelsRequest) error {
+	m.instances = append(m.instances, path.Join(project, zone, instance))
+	return m.mockGCEClient.SetLabels(ctx, project, zone, instance, req)
+}
+
+func TestReconcileGCPProviderIDOverride(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := withAnnotations(
+		createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
+		map[string]string{"example.com/gcp-zone": "us-central1-b", "example.com/gcp-instance": "instance-2"},
+	)
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &locationRecordingGCEClient{mockGCEClient: mockGCEClient{instance: &gce.Instance{}}}
+	r := &NodeLabelController{
+		Client:                k8s,
+		Labels:                []string{"env"},
+		Cloud:                 "gcp",
+		GCEClient:             mock,
+		GCPZoneAnnotation:     "example.com/gcp-zone",
+		GCPInstanceAnnotation: "example.com/gcp-instance",
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"my-project/us-central1-b/instance-2", "my-project/us-central1-b/instance-2"}, mock.instances)
+	assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+}
+
+func TestReconcileGCPSkipNonRunning(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		skip        bool
+		wantUpdated bool
+	}{
+		{name: "running instance is synced", status: "RUNNING", skip: true, wantUpdated: true},
+		{name: "terminated instance is skipped", status: "TERMINATED", skip: true},
+		{name: "suspended instance is skipped", status: "SUSPENDED", skip: true},
+		{name: "terminated instance is synced when disabled", status: "TERMINATED", wantUpdated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			mock := &mockGCEClient{instance: &gce.Instance{Status: tt.status}}
+			r := &NodeLabelController{
+				Client:            k8s,
+				Labels:            []string{"env"},
+				Cloud:             "gcp",
+				GCEClient:         mock,
+				GCPSkipNonRunning: tt.skip,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+
+			if tt.wantUpdated {
+				assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+			} else {
+				assert.Nil(t, mock.labels)
+			}
+		})
+	}
+}
+
+func TestReconcileGCPUnmanagedCollision(t *testing.T) {
+	tests := []struct {
+		name           string
+		nodeLabels     map[string]string
+		currentLabels  map[string]string
+		overwrite      bool
+		wantLabels     map[string]string
+		wantCollisions float64
+	}{
+		{
+			name:          "label of a sanitized managed key is overwritten",
+			nodeLabels:    map[string]string{"team.name": "platform", "env": "prod"},
+			currentLabels: map[string]string{"team-name": "billing"},
+			wantLabels:    map[string]string{"team-name": "platform", "env": "prod"},
+		},
+		{
+			name:          "label of a sanitized managed key is deleted",
+			nodeLabels:    map[string]string{"env": "prod"},
+			currentLabels: map[string]string{"team-name": "billing"},
+			wantLabels:    map[string]string{"env": "prod"},
+		},
+		{
+			name:          "key that is managed verbatim is overwritten",
+			nodeLabels:    map[string]string{"env": "prod"},
+			currentLabels: map[string]string{"env": "staging"},
+			wantLabels:    map[string]string{"env": "prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			node := createNode("node1", tt.nodeLabels, "gce://my-project/us-central1-a/instance-1")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			mock := &mockGCEClient{instance: &gce.Instance{Labels: tt.currentLabels}}
+			r := &NodeLabelController{
+				Client:                k8s,
+				Labels:                []string{"team.name", "env"},
+				Cloud:                 "gcp",
+				GCEClient:             mock,
+				GCPOverwriteUnmanaged: tt.overwrite,
+			}
+
+			collisions := testutil.ToFloat64(unmanagedCollisions.WithLabelValues("gcp"))
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLabels, mock.labels)
+			assert.Equal(t, tt.wantCollisions, testutil.ToFloat64(unmanagedCollisions.WithLabelValues("gcp"))-collisions)
+		})
+	}
+
+	t.Run("labels written by the controller are owned", func(t *testing.T) {
+		scheme := runtime.NewScheme()
+		require.NoError(t, corev1.AddToScheme(scheme))
+
+		node := createNode("node1", map[string]string{"team.name": "platform"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{}}
+		r := &NodeLabelController{
+			Client:    k8s,
+			Labels:    []string{"team.name"},
+			Cloud:     "gcp",
+			GCEClient: mock,
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+		collisions := testutil.ToFloat64(unmanagedCollisions.WithLabelValues("gcp"))
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"team-name": "platform"}, mock.labels)
+
+		// the label's value changes, the controller's own label is updated
+		node.Labels["team.name"] = "billing"
+		require.NoError(t, k8s.Update(context.Background(), node))
+		mock.instance = &gce.Instance{Labels: mock.labels}
+
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"team-name": "billing"}, mock.labels)
+
+		// and deleted once removed from the node
+		delete(node.Labels, "team.name")
+		require.NoError(t, k8s.Update(context.Background(), node))
+		mock.instance = &gce.Instance{Labels: mock.labels}
+
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Empty(t, mock.labels)
+		assert.Equal(t, collisions, testutil.ToFloat64(unmanagedCollisions.WithLabelValues("gcp")))
+	})
+}
+
+func TestReconcileAWSUnmanagedCollision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "a"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{
+		currentTags: []types.TagDescription{
+			{Key: aws.String("env"), Value: aws.String("staging")},
+			{Key: aws.String("team"), Value: aws.String("b")},
+			{Key: aws.String("k8s-node-tagger"), Value: aws.String("team")},
+		},
+	}
+	r := &NodeLabelController{
+		Client:       k8s,
+		Labels:       []string{"env", "team"},
+		Cloud:        "aws",
+		EC2Client:    mock,
+		ManagedByTag: "k8s-node-tagger",
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+	collisions := testutil.ToFloat64(unmanagedCollisions.WithLabelValues("aws"))
+
+	// env wasn't written by the controller, team was
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unmanagedCollisions.WithLabelValues("aws"))-collisions)
+
+	// the same collision is only counted once
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unmanagedCollisions.WithLabelValues("aws"))-collisions)
+
+	// without the managed-by tag, the tags written by the controller are unknown
+	r = &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock}
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unmanagedCollisions.WithLabelValues("aws"))-collisions)
+}
+
+func TestReconcileClusterNameTag(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	kubeSystem := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "kube-system-uid"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, kubeSystem).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				{Key: aws.String("cluster"), Value: aws.String("old-cluster")},
+			},
+		}
+		r := &NodeLabelController{
+			Client:         k8s,
+			Labels:         []string{"env"},
+			Cloud:          "aws",
+			EC2Client:      mock,
+			ClusterNameTag: "cluster",
+			ClusterName:    &clusterNameResolver{Reader: k8s},
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("cluster"), Value: aws.String("kube-system-uid")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+		}, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "cluster-name": "prod-us-central"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, kubeSystem).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"cost-center": "12345"}}}
+		r := &NodeLabelController{
+			Client:         k8s,
+			Labels:         []string{"env"},
+			Cloud:          "gcp",
+			GCEClient:      mock,
+			ClusterNameTag: "cluster",
+			ClusterName:    &clusterNameResolver{Reader: k8s, Label: defaultClusterNameLabel},
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{
+			"env":         "prod",
+			"cluster":     "prod-us-central",
+			"cost-center": "12345",
+		}, mock.labels)
+	})
+}
+
+func TestReconcileNodeUIDTag(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	node.UID = "uid-1"
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{}
+	r := &NodeLabelController{
+		Client:     k8s,
+		Labels:     []string{"env"},
+		Cloud:      "aws",
+		EC2Client:  mock,
+		NodeUIDTag: "k8s-node-uid",
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("k8s-node-uid"), Value: aws.String("uid-1")},
+	}, mock.createdTags)
+
+	// the node is recreated with the same name, on the same instance
+	require.NoError(t, k8s.Delete(context.Background(), node))
+	recreated := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	recreated.UID = "uid-2"
+	require.NoError(t, k8s.Create(context.Background(), recreated))
+
+	mock.currentTags = []types.TagDescription{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("k8s-node-uid"), Value: aws.String("uid-1")},
+	}
+	mock.createdTags = nil
+
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("k8s-node-uid"), Value: aws.String("uid-2")},
+	}, mock.createdTags)
+	assert.Nil(t, mock.deletedTags)
+}
+
+func TestReconcileNodeNameTag(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// the node name wins over a synced label of the same tag key
+	node := createNode("node1", map[string]string{"env": "prod", "k8s-node-name": "other"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{currentTags: []types.TagDescription{
+		{Key: aws.String("k8s:k8s-node-name"), Value: aws.String("node0")},
+	}}
+	r := &NodeLabelController{
+		Client:      k8s,
+		Labels:      []string{"env", "k8s-node-name"},
+		Cloud:       "aws",
+		EC2Client:   mock,
+		TagPrefix:   "k8s:",
+		NodeNameTag: "k8s-node-name",
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("k8s:env"), Value: aws.String("prod")},
+		{Key: aws.String("k8s:k8s-node-name"), Value: aws.String("node1")},
+	}, mock.createdTags)
+	assert.Contains(t, r.managedKeys("aws"), "k8s:k8s-node-name")
+}
+
+func TestReconcileCorrelationID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "aws",
+		EC2Client: &mockEC2Client{},
+	}
+
+	// reconcile twice, capturing the correlation IDs of every log line
+	var ids [][]string
+	for range 2 {
+		var lineIDs []string
+		logger := funcr.NewJSON(func(obj string) {
+			var line map[string]any
+			require.NoError(t, json.Unmarshal([]byte(obj), &line))
+			id, _ := line["correlationID"].(string)
+			lineIDs = append(lineIDs, id)
+		}, funcr.Options{Verbosity: 1})
+
+		ctx := ctrl.LoggerInto(context.Background(), logger)
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		ids = append(ids, lineIDs)
+	}
+
+	for _, lineIDs := range ids {
+		// at least the sync method's and Reconcile's own log lines
+		require.GreaterOrEqual(t, len(lineIDs), 2)
+		assert.NotEmpty(t, lineIDs[0])
+		for _, id := range lineIDs {
+			assert.Equal(t, lineIDs[0], id, "all log lines of a reconcile should share one correlation ID")
+		}
+	}
+	assert.NotEqual(t, ids[0][0], ids[1][0], "each reconcile should get its own correlation ID")
+}
+
+func TestSampleNode(t *testing.T) {
+	// sampling disabled
+	assert.True(t, sampleNode("node1", 0))
+	assert.True(t, sampleNode("node1", 1))
+
+	sampled := 0
+	for i := range 10000 {
+		name := fmt.Sprintf("ip-10-0-%d-%d.ec2.internal", i/256, i%256)
+
+		got := sampleNode(name, 0.1)
+		assert.Equal(t, got, sampleNode(name, 0.1), "sampling must be deterministic for %q", name)
+		if got {
+			sampled++
+			// a node sampled at a lower rate stays sampled when the rate is raised
+			assert.True(t, sampleNode(name, 0.5), "%q sampled at 0.1 but not at 0.5", name)
+		}
+	}
+	assert.InDelta(t, 1000, sampled, 150)
+}
+
+func TestReconcileMissingProviderID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	mock := &mockEC2Client{}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, MissingProviderIDRequeue: 15 * time.Second}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	result, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, result.RequeueAfter)
+	assert.Nil(t, mock.createdTags)
+
+	// consecutive requeues back off, up to a maximum
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, maxMissingProviderIDRequeue, maxMissingProviderIDRequeue} {
+		result, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, want, result.RequeueAfter)
+	}
+
+	// the requeued reconcile syncs the node once its provider ID is set
+	node.Spec.ProviderID = "aws:///us-east-1a/i-1234567890abcdef0"
+	require.NoError(t, k8s.Update(context.Background(), node))
+	result, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+	_, ok := r.missingProviderIDAttempts.Load(node.Name)
+	assert.False(t, ok)
+
+	// without a requeue interval the node waits for its next event
+	r.MissingProviderIDRequeue = 0
+	node.Spec.ProviderID = ""
+	require.NoError(t, k8s.Update(context.Background(), node))
+	result, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+}
+
+func TestReconcileRateLimit(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// the node has no provider ID, so reconciles are throttled even without a tag sync
+	node := createNode("node1", map[string]string{"env": "prod"}, "")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: &mockEC2Client{}, ReconcileRateLimiter: newRateLimiter(20)}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	// the first reconcile starts right away, the others wait 1/20s for their turn
+	const reconciles = 4
+	start := time.Now()
+	for range reconciles {
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), (reconciles-1)*time.Second/20-10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Reconcile(ctx, req)
+	assert.ErrorContains(t, err, "reconcile rate limiter")
+}
+
+func TestReconcileSampleRate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// pick one node name inside and one outside a 50% sample
+	var in, out string
+	for i := 0; in == "" || out == ""; i++ {
+		name := fmt.Sprintf("node%d", i)
+		if sampleNode(name, 0.5) {
+			in = name
+		} else {
+			out = name
+		}
+	}
+
+	for name, wantWrite := range map[string]bool{in: true, out: false} {
+		t.Run(name, func(t *testing.T) {
+			node := createNode(name, map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			mock := &mockEC2Client{
+				currentTags: []types.TagDescription{
+					{Key: aws.String("team"), Value: aws.String("platform")},
+				},
+			}
+			r := &NodeLabelController{
+				Client:     k8s,
+				Labels:     []string{"env", "team"},
+				Cloud:      "aws",
+				EC2Client:  mock,
+				SampleRate: 0.5,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+
+			if wantWrite {
+				assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+				assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}, mock.deletedTags)
+			} else {
+				assert.Nil(t, mock.createdTags)
+				assert.Nil(t, mock.deletedTags)
+			}
+		})
+	}
+}
+
+// logRecorder returns a context whose logger records its log lines, decoded from JSON, in logs
+func logRecorder(t *testing.T, logs *[]map[string]any) context.Context {
+	logger := funcr.NewJSON(func(obj string) {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal([]byte(obj), &line))
+		*logs = append(*logs, line)
+	}, funcr.Options{})
+	return ctrl.LoggerInto(context.Background(), logger)
+}
+
+// findLog returns the first log line with msg
+func findLog(t *testing.T, logs []map[string]any, msg string) map[string]any {
+	t.Helper()
+	for _, line := range logs {
+		if line["msg"] == msg {
+			return line
+		}
+	}
+	require.Failf(t, "log line not found", "msg: %q", msg)
+	return nil
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	nodeLabels := map[string]string{"env": "prod"}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				{Key: aws.String("team"), Value: aws.String("platform")},
+			},
+		}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, DryRun: true}
+
+		var logs []map[string]any
+		_, err := r.Reconcile(logRecorder(t, &logs), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+
+		line := findLog(t, logs, "Skipping AWS tag changes")
+		assert.Equal(t, "aws:///us-east-1a/i-1234567890abcdef0", line["providerID"])
+		assert.Equal(t, map[string]any{"env": "prod"}, line["createTags"])
+		assert.Equal(t, map[string]any{"team": "platform"}, line["deleteTags"])
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", nodeLabels, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"team": "platform"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "gcp", GCEClient: mock, DryRun: true}
+
+		var logs []map[string]any
+		_, err := r.Reconcile(logRecorder(t, &logs), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.labels)
+
+		line := findLog(t, logs, "Skipping GCP label update")
+		assert.Equal(t, "gce://my-project/us-central1-a/instance-1", line["providerID"])
+		assert.Equal(t, map[string]any{"env": "prod"}, line["setLabels"])
+		assert.Equal(t, []any{"team"}, line["removeLabels"])
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		node := createNode("node1", nodeLabels, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockAzureClient{currentTags: map[string]string{"team": "platform"}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "azure", AzureClient: mock, DryRun: true}
+
+		var logs []map[string]any
+		_, err := r.Reconcile(logRecorder(t, &logs), req)
+		require.NoError(t, err)
+
+		line := findLog(t, logs, "Skipping Azure tag changes")
+		assert.Equal(t, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", line["providerID"])
+		assert.Equal(t, map[string]any{"env": "prod"}, line["mergeTags"])
+		assert.Equal(t, map[string]any{"team": "platform"}, line["deleteTags"])
+		assert.Nil(t, mock.mergedTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("cleanup on delete", func(t *testing.T) {
+		node := createNode("node1", nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+		}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, DryRun: true, CleanupOnDelete: true}
+
+		require.True(t, r.shouldProcessNodeDelete(node))
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.deletedTags)
+	})
+}
+
+func TestReconcileSharedInstanceIsSerialized(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// a virtual node and the real node referencing the same instance ID
+	nodes := []*corev1.Node{
+		createNode("real-node", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"),
+		createNode("virtual-node", map[string]string{"env": "staging"}, "aws:///i-1234567890abcdef0"),
+	}
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes[0], nodes[1]).Build()
+
+	mock := &concurrencyTrackingEC2Client{}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, mock.maxInFlight, "reconciles of nodes sharing an instance must not overlap")
+	assert.Equal(t, 0, mock.inFlight)
+}
+
+func TestInstanceKey(t *testing.T) {
+	assert.Equal(t, "aws/i-1234567890abcdef0", instanceKey("aws:///us-east-1a/i-1234567890abcdef0"))
+	assert.Equal(t, "aws/i-1234567890abcdef0", instanceKey("aws:///i-1234567890abcdef0"))
+	assert.Equal(t, "gcp/my-project/us-central1-a/instance-1", instanceKey("gce://my-project/us-central1-a/instance-1"))
+	assert.Equal(t, "gce://my-project", instanceKey("gce://my-project"))
+	assert.Equal(t, "azure/sub/rg/vm-1", instanceKey("azure:///subscriptions/sub/resourceGroups/RG/providers/Microsoft.Compute/virtualMachines/vm-1"))
+}
+
+// recordingTransport is an http.RoundTripper that records requests and answers them with a
+// canned response instead of reaching the network.
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	body     string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	rt.mu.Lock()
+	rt.requests = append(rt.requests, req)
+	rt.bodies = append(rt.bodies, string(body))
+	rt.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(rt.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestReadyzCheck(t *testing.T) {
+	t.Run("not ready until the cloud provider is set up", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "gcp", GCPClientOptions: []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))}}
+		assert.EqualError(t, r.ReadyzCheck(nil), `cloud provider "gcp" is not set up`)
+
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+		assert.NoError(t, r.ReadyzCheck(nil))
+	})
+
+	t.Run("failed setup", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "mainframe"}
+		require.Error(t, r.SetupCloudProvider(context.Background()))
+		assert.Error(t, r.ReadyzCheck(nil))
+	})
+
+	t.Run("sink", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "aws", Sink: &fileSink{}}
+		assert.NoError(t, r.ReadyzCheck(nil))
+	})
+}
+
+func TestSetupCloudProviderHTTPClient(t *testing.T) {
+	t.Run("aws", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("AWS_REGION", "us-east-1")
+		t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+		t.Setenv("AWS_CA_BUNDLE", "")
+
+		rt := &recordingTransport{body: `<DescribeTagsResponse><tagSet></tagSet></DescribeTagsResponse>`}
+		r := &NodeLabelController{
+			Cloud:      "aws",
+			HTTPClient: &http.Client{Transport: rt},
+		}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+
+		_, err := r.EC2Client.DescribeTags(context.Background(), &ec2.DescribeTagsInput{})
+		require.NoError(t, err)
+
+		require.Len(t, rt.requests, 1)
+		assert.Equal(t, "ec2.us-east-1.amazonaws.com", rt.requests[0].URL.Host)
+	})
+
+	t.Run("aws region", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("AWS_REGION", "us-east-1")
+		t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+		t.Setenv("AWS_CA_BUNDLE", "")
+
+		rt := &recordingTransport{body: `<DescribeTagsResponse><tagSet></tagSet></DescribeTagsResponse>`}
+		r := &NodeLabelController{
+			Cloud:      "aws",
+			HTTPClient: &http.Client{Transport: rt},
+			AWSRegion:  "eu-central-1",
+		}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+		assert.Equal(t, "eu-central-1", r.EC2Client.(*ec2.Client).Options().Region)
+
+		// API calls are only retried by retryAWS
+		assert.IsType(t, aws.NopRetryer{}, r.EC2Client.(*ec2.Client).Options().Retryer)
+		assert.IsType(t, aws.NopRetryer{}, r.NewEC2Client("us-west-2").(*ec2.Client).Options().Retryer)
+
+		_, err := r.EC2Client.DescribeTags(context.Background(), &ec2.DescribeTagsInput{})
+		require.NoError(t, err)
+		require.Len(t, rt.requests, 1)
+		assert.Equal(t, "ec2.eu-central-1.amazonaws.com", rt.requests[0].URL.Host)
+	})
+
+	t.Run("aws assume role", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("AWS_REGION", "us-east-1")
+		t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+		t.Setenv("AWS_CA_BUNDLE", "")
+
+		rt := &recordingTransport{body: `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>AKIDROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+			<Expiration>2100-01-01T00:00:00Z</Expiration>
+		</Credentials></AssumeRoleResult></AssumeRoleResponse>`}
+		r := &NodeLabelController{
+			Cloud:            "aws",
+			HTTPClient:       &http.Client{Transport: rt},
+			AWSAssumeRoleARN: "arn:aws:iam::123456789012:role/node-tagger",
+			AWSExternalID:    "external-id",
+		}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+
+		for _, c := range []ec2Client{r.EC2Client, r.NewEC2Client("eu-west-1")} {
+			creds, ok := c.(*ec2.Client).Options().Credentials.(*aws.CredentialsCache)
+			require.True(t, ok)
+			assert.True(t, creds.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+		}
+
+		// the role is assumed before the first EC2 call, which is signed with its credentials
+		_, _ = r.EC2Client.DescribeTags(context.Background(), &ec2.DescribeTagsInput{})
+		require.Len(t, rt.requests, 2)
+		assert.Equal(t, "sts.us-east-1.amazonaws.com", rt.requests[0].URL.Host)
+		assert.Contains(t, rt.bodies[0], "ExternalId=external-id")
+		assert.Equal(t, "ec2.us-east-1.amazonaws.com", rt.requests[1].URL.Host)
+		assert.Contains(t, rt.requests[1].Header.Get("Authorization"), "Credential=AKIDROLE/")
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		rt := &recordingTransport{body: `{"name": "instance-1", "status": "RUNNING"}`}
+		r := &NodeLabelController{
+			Cloud:            "gcp",
+			HTTPClient:       &http.Client{Transport: rt},
+			GCPClientOptions: []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))},
+		}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+
+		instance, err := r.GCEClient.GetInstance(context.Background(), "my-project", "us-central1-a", "instance-1")
+		require.NoError(t, err)
+		assert.Equal(t, "instance-1", instance.Name)
+
+		require.Len(t, rt.requests, 1)
+		assert.Equal(t, "compute.googleapis.com", rt.requests[0].URL.Host)
+	})
+}
+
+func TestSyncAWSTagsEndpointURL(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+
+	// a stub of the EC2 API, eg: LocalStack
+	var mu sync.Mutex
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		forms = append(forms, req.PostForm)
+		mu.Unlock()
+
+		switch action := req.PostForm.Get("Action"); action {
+		case "DescribeTags":
+			fmt.Fprint(w, `<DescribeTagsResponse><tagSet><item><resourceId>i-1234567890abcdef0</resourceId><key>team</key><value>a</value></item></tagSet></DescribeTagsResponse>`)
+		default:
+			fmt.Fprintf(w, `<%[1]sResponse><return>true</return></%[1]sResponse>`, action)
+		}
+	}))
+	defer srv.Close()
+
+	r := &NodeLabelController{Labels: []string{"env", "team"}, Cloud: "aws", AWSEndpointURL: srv.URL}
+	require.NoError(t, r.SetupCloudProvider(context.Background()))
+
+	require.NoError(t, r.syncTags(context.Background(), "aws:///us-east-1a/i-1234567890abcdef0", map[string]string{"env": "prod"}, false))
+
+	require.Len(t, forms, 3)
+	assert.Equal(t, "DescribeTags", forms[0].Get("Action"))
+	assert.Equal(t, "DeleteTags", forms[1].Get("Action"))
+	assert.Equal(t, "team", forms[1].Get("Tag.1.Key"))
+	assert.Equal(t, "CreateTags", forms[2].Get("Action"))
+	assert.Equal(t, "i-1234567890abcdef0", forms[2].Get("ResourceId.1"))
+	assert.Equal(t, "env", forms[2].Get("Tag.1.Key"))
+	assert.Equal(t, "prod", forms[2].Get("Tag.1.Value"))
+}
+
+func TestSetupCloudProviderAuto(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+	// GCP credentials can't be loaded, so only the GCP client is missing
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path.Join(t.TempDir(), "missing.json"))
+
+	r := &NodeLabelController{Labels: []string{"env"}, Cloud: "auto"}
+	require.NoError(t, r.SetupCloudProvider(context.Background()))
+	assert.NotNil(t, r.EC2Client)
+	assert.Nil(t, r.GCEClient)
+	assert.NoError(t, r.ReadyzCheck(nil))
+
+	err := r.syncTags(context.Background(), "gce://my-project/us-central1-a/instance-1", map[string]string{"env": "prod"}, false)
+	assert.EqualError(t, err, `cloud provider "gcp" is not set up`)
+}
+
+func TestReconcileAutoCloud(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	nodes := []client.Object{
+		createNode("aws-node", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"),
+		createNode("gcp-node", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
+		createNode("azure-node", map[string]string{"env": "prod"}, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"),
+		createNode("other-node", map[string]string{"env": "prod"}, "kind://docker/kind/kind-worker"),
+	}
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes...).Build()
+
+	ec2Mock := &mockEC2Client{}
+	gceMock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}}
+	azureMock := &mockAzureClient{}
+	r := &NodeLabelController{
+		Client:      k8s,
+		Labels:      []string{"env"},
+		Cloud:       "auto",
+		EC2Client:   ec2Mock,
+		GCEClient:   gceMock,
+		AzureClient: azureMock,
+	}
+
+	for _, node := range nodes {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.GetName()}})
+		require.NoError(t, err, node.GetName())
+	}
+
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, ec2Mock.createdTags)
+	assert.Equal(t, map[string]string{"env": "prod"}, gceMock.labels)
+	assert.Equal(t, map[string]string{"env": "prod"}, azureMock.mergedTags)
+
+	clouds, _ := r.summary.snapshot()
+	assert.Equal(t, 1, clouds["aws"].Reconciled)
+	assert.Equal(t, 1, clouds["gcp"].Reconciled)
+	assert.Equal(t, 1, clouds["azure"].Reconciled)
+	assert.Equal(t, 1, clouds["unknown"].Reconciled, "nodes of unknown clouds are counted under unknown")
+	assert.NotContains(t, clouds, "auto")
+}
+
+func TestReconcileAutoClouds(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	nodes := []client.Object{
+		createNode("aws-node", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"),
+		createNode("gcp-node", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
+		createNode("azure-node", map[string]string{"env": "prod"}, "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"),
+	}
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes...).Build()
+
+	ec2Mock := &mockEC2Client{}
+	gceMock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}}
+	azureMock := &mockAzureClient{}
+	r := &NodeLabelController{
+		Client:      k8s,
+		Labels:      []string{"env"},
+		Cloud:       "auto",
+		AutoClouds:  []string{"aws", "gcp"},
+		EC2Client:   ec2Mock,
+		GCEClient:   gceMock,
+		AzureClient: azureMock,
+	}
+
+	for _, node := range nodes {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.GetName()}})
+		require.NoError(t, err, node.GetName())
+	}
+
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, ec2Mock.createdTags)
+	assert.Equal(t, map[string]string{"env": "prod"}, gceMock.labels)
+	assert.Nil(t, azureMock.mergedTags, "nodes of clouds missing from AutoClouds are skipped")
+}
+
+func TestNewCloudHTTPClient(t *testing.T) {
+	hc, err := newCloudHTTPClient(0, "")
+	require.NoError(t, err)
+	assert.Nil(t, hc, "SDK defaults should be used when nothing is configured")
+
+	hc, err = newCloudHTTPClient(10*time.Second, "http://proxy.internal:3128")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, hc.Timeout)
+
+	req, err := http.NewRequest(http.MethodGet, "https://ec2.us-east-1.amazonaws.com", nil)
+	require.NoError(t, err)
+	proxy, err := hc.Transport.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.internal:3128", proxy.String())
+
+	_, err = newCloudHTTPClient(0, "://bad")
+	assert.Error(t, err)
+}
+
+func TestReconcileProviderIDCloudTransition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	reconcile := func(t *testing.T, r *NodeLabelController) {
+		t.Helper()
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+		require.NoError(t, err)
+	}
+	setProviderID := func(t *testing.T, k8s client.Client, providerID string) {
+		t.Helper()
+		var node corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), client.ObjectKey{Name: "node1"}, &node))
+		node.Spec.ProviderID = providerID
+		require.NoError(t, k8s.Update(context.Background(), &node))
+	}
+
+	t.Run("away from the configured cloud", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock}
+		reconcile(t, r)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+
+		// the node moves to GCP: its managed tags are removed from the old EC2 instance and nothing
+		// is applied to the new instance through the EC2 client
+		mock.currentTags = []types.TagDescription{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("cost-center"), Value: aws.String("12345")},
+		}
+		mock.createdTags = nil
+		setProviderID(t, k8s, "gce://my-project/us-central1-a/instance-1")
+		reconcile(t, r)
+
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.deletedTags)
+		assert.Nil(t, mock.createdTags)
+	})
+
+	t.Run("into the configured cloud", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock}
+		reconcile(t, r)
+
+		// the old GCE instance is unreachable with only an EC2 client, the new instance is tagged
+		mock.createdTags = nil
+		setProviderID(t, k8s, "aws:///us-east-1a/i-1234567890abcdef0")
+		reconcile(t, r)
+
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+}
+
+func TestReconcileCleanupOnDelete(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		// the node is deleted: its managed tags are removed, unmanaged tags are left alone
+		mock.currentTags = []types.TagDescription{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("cost-center"), Value: aws.String("12345")},
+		}
+		require.NoError(t, k8s.Delete(context.Background(), node))
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.deletedTags)
+
+		// the deleted node's provider ID is forgotten
+		_, ok := r.providerIDs.Load("node1")
+		assert.False(t, ok)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"env": "prod", "cost-center": "12345"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, CleanupOnDelete: true}
+
+		// the node was never reconciled, its provider ID is taken from the delete event
+		require.True(t, r.shouldProcessNodeDelete(node))
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"cost-center": "12345"}, mock.labels)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		mock.currentTags = []types.TagDescription{{Key: aws.String("env"), Value: aws.String("prod")}}
+		require.NoError(t, k8s.Delete(context.Background(), node))
+		assert.False(t, r.shouldProcessNodeDelete(node))
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("instance referenced by another node", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		other := createNode("node2", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, other).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true}
+		for _, name := range []string{"node1", "node2"} {
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: name}})
+			require.NoError(t, err)
+		}
+
+		mock.currentTags = []types.TagDescription{{Key: aws.String("env"), Value: aws.String("prod")}}
+		require.NoError(t, k8s.Delete(context.Background(), node))
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.deletedTags)
+	})
+}
+
+func TestEventFilterDisabledNode(t *testing.T) {
+	const annotation = "node-tagger.planetscale.com/disabled"
+	r := &NodeLabelController{Labels: []string{"env"}, DisabledAnnotation: annotation, CleanupOnDelete: true, CleanupFinalizer: "example.com/node-tagger-cleanup"}
+	filter := r.eventFilter()
+
+	enabled := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	disabled := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{annotation: "true"})
+	relabeled := disabled.DeepCopy()
+	relabeled.Labels["env"] = "staging"
+	notDisabled := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{annotation: "false"})
+
+	assert.True(t, filter.Create(event.CreateEvent{Object: enabled}))
+	assert.False(t, filter.Create(event.CreateEvent{Object: disabled}))
+	assert.True(t, filter.Create(event.CreateEvent{Object: notDisabled}))
+
+	assert.False(t, filter.Update(event.UpdateEvent{ObjectOld: disabled, ObjectNew: relabeled}), "label changes of disabled nodes are ignored")
+	assert.False(t, filter.Update(event.UpdateEvent{ObjectOld: enabled, ObjectNew: disabled}))
+	assert.True(t, filter.Update(event.UpdateEvent{ObjectOld: disabled, ObjectNew: enabled}), "re-enabled nodes are synced")
+
+	assert.True(t, filter.Delete(event.DeleteEvent{Object: enabled}))
+	assert.False(t, filter.Delete(event.DeleteEvent{Object: disabled}))
+
+	// the finalizer of disabled nodes is still released
+	finalizing := disabled.DeepCopy()
+	finalizing.Finalizers = []string{r.CleanupFinalizer}
+	finalizing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	assert.True(t, filter.Update(event.UpdateEvent{ObjectOld: disabled, ObjectNew: finalizing}))
+}
+
+func TestEventFilterResync(t *testing.T) {
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	node.ResourceVersion = "1"
+	updated := node.DeepCopy()
+	updated.ResourceVersion = "2"
+	resync := event.UpdateEvent{ObjectOld: node, ObjectNew: node.DeepCopy()}
+
+	r := &NodeLabelController{Labels: []string{"env"}}
+	assert.False(t, r.eventFilter().Update(resync), "resyncs are ignored by default")
+
+	r.ResyncPeriod = time.Hour
+	assert.True(t, r.eventFilter().Update(resync))
+	assert.False(t, r.eventFilter().Update(event.UpdateEvent{ObjectOld: node, ObjectNew: updated}), "updates not changing a monitored key are still ignored")
+}
+
+func TestReconcileDisabledNode(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	const annotation = "node-tagger.planetscale.com/disabled"
+	const finalizer = "example.com/node-tagger-cleanup"
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("skipped", func(t *testing.T) {
+		node := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{annotation: "true"})
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("team"), Value: aws.String("a")}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "aws", EC2Client: mock, DisabledAnnotation: annotation, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Empty(t, got.Finalizers)
+	})
+
+	t.Run("finalizer released without cleanup", func(t *testing.T) {
+		node := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"), map[string]string{annotation: "true"})
+		node.Finalizers = []string{finalizer}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+		require.NoError(t, k8s.Delete(context.Background(), node))
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("env"), Value: aws.String("prod")}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, DisabledAnnotation: annotation, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.deletedTags)
+
+		var got corev1.Node
+		assert.True(t, apierrors.IsNotFound(k8s.Get(context.Background(), req.NamespacedName, &got)))
+	})
+}
+
+func TestEventFilterNodeSelector(t *testing.T) {
+	r := &NodeLabelController{Labels: []string{"env"}, NodeSelector: labels.SelectorFromSet(labels.Set{"node-pool": "batch"}), CleanupOnDelete: true}
+	filter := r.eventFilter()
+
+	selected := createNode("node1", map[string]string{"env": "prod", "node-pool": "batch"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	other := createNode("node1", map[string]string{"env": "prod", "node-pool": "web"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	relabeled := other.DeepCopy()
+	relabeled.Labels["env"] = "staging"
+
+	assert.True(t, filter.Create(event.CreateEvent{Object: selected}))
+	assert.False(t, filter.Create(event.CreateEvent{Object: other}))
+
+	assert.False(t, filter.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: relabeled}), "label changes of other nodes are ignored")
+	assert.False(t, filter.Update(event.UpdateEvent{ObjectOld: selected, ObjectNew: other}))
+	assert.True(t, filter.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: selected}), "newly selected nodes are synced")
+
+	assert.True(t, filter.Delete(event.DeleteEvent{Object: selected}))
+	assert.False(t, filter.Delete(event.DeleteEvent{Object: other}))
+}
+
+func TestReconcileNodeSelector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	selector := labels.SelectorFromSet(labels.Set{"node-pool": "batch"})
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("not matching", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "node-pool": "web"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, NodeSelector: selector}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Zero(t, mock.describeTagsCalls)
+		assert.Nil(t, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("matching", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "node-pool": "batch"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, NodeSelector: selector}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, mock.createdTags, 1)
+		assert.Equal(t, "env", *mock.createdTags[0].Key)
+	})
+}
+
+func TestReconcileLabelKeyPatterns(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{
+		"env":                              "prod",
+		"topology.kubernetes.io/zone":      "us-east-1a",
+		"topology.kubernetes.io/region":    "us-east-1",
+		"node.kubernetes.io/instance-type": "m5.large",
+	}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the pool label was removed from the node, its tag matches the pattern so it's deleted. The
+	// team tag matches no pattern, it's left alone.
+	mock := &mockEC2Client{currentTags: []types.TagDescription{
+		{Key: aws.String("topology.kubernetes.io/zone"), Value: aws.String("us-east-1a")},
+		{Key: aws.String("example.com/pool"), Value: aws.String("batch")},
+		{Key: aws.String("team"), Value: aws.String("a")},
+	}}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env", "topology.kubernetes.io/*", "example.com/*"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+	require.NoError(t, err)
+
+	created := make(map[string]string)
+	for _, tag := range mock.createdTags {
+		created[*tag.Key] = *tag.Value
+	}
+	assert.Equal(t, map[string]string{"env": "prod", "topology.kubernetes.io/region": "us-east-1"}, created)
+	require.Len(t, mock.deletedTags, 1)
+	assert.Equal(t, "example.com/pool", *mock.deletedTags[0].Key)
+}
+
+func TestReconcileLabelRegex(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	tests := []struct {
+		name        string
+		regex       string
+		wantCreated map[string]string
+		wantDeleted []string
+	}{
+		{
+			name:        "matching",
+			regex:       `^example\.com/`,
+			wantCreated: map[string]string{"env": "prod", "example.com/team": "a"},
+			wantDeleted: []string{"example.com/pool"},
+		},
+		{
+			name:        "not matching",
+			regex:       `^finops\.example\.com/`,
+			wantCreated: map[string]string{"env": "prod"},
+		},
+		{
+			name:        "unanchored",
+			regex:       `example\.com/`,
+			wantCreated: map[string]string{"env": "prod", "example.com/team": "a", "team.example.com/owner": "b"},
+			wantDeleted: []string{"example.com/pool"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := createNode("node1", map[string]string{"env": "prod", "example.com/team": "a", "team.example.com/owner": "b"}, "aws:///us-east-1a/i-1234567890abcdef0")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			// the pool label was removed from the node
+			mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("example.com/pool"), Value: aws.String("batch")}}}
+			r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, LabelRegex: regexp.MustCompile(tt.regex), Cloud: "aws", EC2Client: mock}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+			require.NoError(t, err)
+
+			created := make(map[string]string)
+			for _, tag := range mock.createdTags {
+				created[*tag.Key] = *tag.Value
+			}
+			assert.Equal(t, tt.wantCreated, created)
+			var deleted []string
+			for _, tag := range mock.deletedTags {
+				deleted = append(deleted, *tag.Key)
+			}
+			assert.Equal(t, tt.wantDeleted, deleted)
+
+			filter := r.eventFilter()
+			relabeled := node.DeepCopy()
+			relabeled.Labels["team.example.com/owner"] = "c"
+			assert.Equal(t, tt.name == "unanchored", filter.Update(event.UpdateEvent{ObjectOld: node, ObjectNew: relabeled}))
+		})
+	}
+}
+
+func TestReconcileGCPLabelRegex(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "example.com/team": "a", "example.com/pool": "batch"}, "gce://my-project/us-central1-a/instance-1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	// example-com_owner can't be told apart from the label of a removed matching key
+	mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"example-com_owner": "b"}}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, LabelRegex: regexp.MustCompile(`^example\.com/`)}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "example-com_team": "a", "example-com_pool": "batch", "example-com_owner": "b"}, mock.labels)
+
+	// the label of a matching key removed from the node is deleted
+	delete(node.Labels, "example.com/pool")
+	require.NoError(t, k8s.Update(context.Background(), node))
+	mock.instance.Labels = mock.labels
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "example-com_team": "a", "example-com_owner": "b"}, mock.labels)
+}
+
+func TestReconcileCleanupFinalizer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	const finalizer = "example.com/node-tagger-cleanup"
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("deletion waits for the cleanup", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Equal(t, []string{finalizer}, got.Finalizers)
+
+		// the controller restarts, so the node's provider ID is only known from the node itself
+		r = &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		mock.currentTags = []types.TagDescription{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("cost-center"), Value: aws.String("12345")},
+		}
+		require.NoError(t, k8s.Delete(context.Background(), &got))
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.True(t, r.isFinalizing(&got))
+
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.deletedTags)
+
+		// the finalizer is removed and the node is gone, its delete event doesn't clean up again
+		err = k8s.Get(context.Background(), req.NamespacedName, &got)
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.False(t, r.shouldProcessNodeDelete(&got))
+	})
+
+	t.Run("failed cleanup keeps the finalizer", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		node.Finalizers = []string{finalizer}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+		require.NoError(t, k8s.Delete(context.Background(), node))
+
+		mock := &mockEC2Client{describeErr: errors.New("access denied")}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		_, err := r.Reconcile(context.Background(), req)
+		require.Error(t, err)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Equal(t, []string{finalizer}, got.Finalizers)
+	})
+
+	t.Run("failed cleanup releases the finalizer after the maximum hold", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		node.Finalizers = []string{finalizer}
+		node.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-maxCleanupFinalizerHold)}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{describeErr: errors.New("access denied")}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		assert.True(t, apierrors.IsNotFound(k8s.Get(context.Background(), req.NamespacedName, &got)))
+	})
+
+	t.Run("deleted instance releases the finalizer", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		node.Finalizers = []string{finalizer}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+		require.NoError(t, k8s.Delete(context.Background(), node))
+
+		mock := &mockEC2Client{describeErr: &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound", Message: "The instance ID does not exist"}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		assert.True(t, apierrors.IsNotFound(k8s.Get(context.Background(), req.NamespacedName, &got)))
+		_, ok := r.providerIDs.Load(node.Name)
+		assert.False(t, ok)
+	})
+
+	t.Run("node of an unhandled cloud", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		node.Finalizers = []string{finalizer}
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: &mockEC2Client{}, CleanupOnDelete: true, CleanupFinalizer: finalizer}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Empty(t, got.Finalizers)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: &mockEC2Client{}, CleanupOnDelete: true, CleanupFinalizer: finalizer, DryRun: true}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Empty(t, got.Finalizers)
+	})
+
+	t.Run("node not in the sample", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		const sampleRate = 0.0001
+		require.False(t, sampleNode(node.Name, sampleRate))
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: &mockEC2Client{}, CleanupOnDelete: true, CleanupFinalizer: finalizer, SampleRate: sampleRate}
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got corev1.Node
+		require.NoError(t, k8s.Get(context.Background(), req.NamespacedName, &got))
+		assert.Empty(t, got.Finalizers)
+	})
+}
+
+func TestControllerOptions(t *testing.T) {
+	assert.Equal(t, 1, (&NodeLabelController{}).controllerOptions().MaxConcurrentReconciles)
+	assert.Equal(t, 8, (&NodeLabelController{MaxConcurrentReconciles: 8}).controllerOptions().MaxConcurrentReconciles)
+}
+
+func TestReconcileGCPDisks(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	newMock := func() *mockGCEClient {
+		return &mockGCEClient{
+			instance: &gce.Instance{
+				Name:   "instance-1",
+				Labels: map[string]string{"env": "staging"},
+				Disks: []*gce.AttachedDisk{
+					{Source: "https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/disks/boot-1"},
+					{Source: "https://www.googleapis.com/compute/v1/projects/my-project/regions/us-central1/disks/regional-1"},
+				},
+			},
+			disks: map[string]*gce.Disk{
+				"boot-1": {Name: "boot-1", Labels: map[string]string{"env": "staging", "backup": "daily"}},
+			},
+		}
+	}
+
+	t.Run("instance and disks", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+		mock := newMock()
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, GCPLabelDisks: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+
+		// regional disks are skipped
+		assert.Equal(t, map[string]map[string]string{"boot-1": {"env": "prod", "backup": "daily"}}, mock.diskLabels)
+	})
+
+	t.Run("disk update fails", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+		mock := newMock()
+		mock.setDiskLabelsErr = errors.New("permission denied")
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, GCPLabelDisks: true}
+
+		// the instance is updated, the error identifies the disk
+		_, err := r.Reconcile(context.Background(), req)
+		require.EqualError(t, err, "failed to update GCP disk boot-1 labels: permission denied")
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+
+		// the retry only updates the disk
+		mock.instance.Labels, mock.labels, mock.setDiskLabelsErr = mock.labels, nil, nil
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.labels)
+		assert.Equal(t, map[string]map[string]string{"boot-1": {"env": "prod", "backup": "daily"}}, mock.diskLabels)
+	})
+
+	t.Run("disk fetch fails", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+		mock := newMock()
+		mock.disks = nil
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, GCPLabelDisks: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.EqualError(t, err, "failed to get GCP disk boot-1: disk boot-1 not found")
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+	})
+
+	t.Run("disks disabled", func(t *testing.T) {
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+		mock := newMock()
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+		assert.Nil(t, mock.diskLabels)
+	})
+}
+
+func TestReconcileCloudMismatch(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	tests := []struct {
+		name       string
+		cloud      string
+		providerID string
+	}{
+		{name: "gcp node with --cloud aws", cloud: "aws", providerID: "gce://my-project/us-central1-a/instance-1"},
+		{name: "aws node with --cloud gcp", cloud: "gcp", providerID: "aws:///us-east-1a/i-1234567890abcdef0"},
+		{name: "aws node with --cloud azure", cloud: "azure", providerID: "aws:///us-east-1a/i-1234567890abcdef0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := createNode("node1", map[string]string{"env": "prod"}, tt.providerID)
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			ec2Mock := &mockEC2Client{}
+			gceMock := &mockGCEClient{instance: &gce.Instance{}}
+			azureMock := &mockAzureClient{}
+			r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: tt.cloud, EC2Client: ec2Mock, GCEClient: gceMock, AzureClient: azureMock}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+			assert.Nil(t, ec2Mock.createdTags)
+			assert.Nil(t, gceMock.labels)
+			assert.Nil(t, azureMock.resourceIDs)
+
+			_, ok := r.instanceNodes.Load(instanceKey(tt.providerID))
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestReconcileDeletedNodeForgetsInstance(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	providerID := "aws:///us-east-1a/i-1234567890abcdef0"
+	node := createNode("node1", map[string]string{"env": "prod"}, providerID)
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: &mockEC2Client{}}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	_, ok := r.instanceNodes.Load(instanceKey(providerID))
+	require.True(t, ok)
+
+	require.NoError(t, k8s.Delete(context.Background(), node))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	_, ok = r.instanceNodes.Load(instanceKey(providerID))
+	assert.False(t, ok)
+	_, ok = r.providerIDs.Load(node.Name)
+	assert.False(t, ok)
+}
+
+func TestRegionFromProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{providerID: "aws:///us-east-1a/i-1234567890abcdef0", want: "us-east-1"},
+		{providerID: "aws:///i-1234567890abcdef0", wantErr: true},
+		{providerID: "gce://my-project/us-central1-a/instance-1", want: "us-central1"},
+		{providerID: "gce://my-project/europe-west4-b/instance-1", want: "europe-west4"},
+		{providerID: "gce://my-project/zone/instance-1", wantErr: true},
+		{providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", wantErr: true},
+		{providerID: "kind://docker/kind/kind-worker", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.providerID, func(t *testing.T) {
+			got, err := regionFromProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReconcileRegionFromProviderID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///eu-west-1b/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("region"), Value: aws.String("us-east-1")}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, TagRegionFromProviderID: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("region"), Value: aws.String("eu-west-1")},
+		}, mock.createdTags)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, TagRegionFromProviderID: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "region": "us-central1"}, mock.labels)
+	})
+
+	t.Run("region label", func(t *testing.T) {
+		node := createNode("node1", map[string]string{corev1.LabelTopologyRegion: "us-east-2"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, TagRegionFromProviderID: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("region"), Value: aws.String("us-east-2")}}, mock.createdTags)
+	})
+
+	t.Run("synced region label", func(t *testing.T) {
+		node := createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the derived region uses the label's tag key
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{corev1.LabelTopologyRegion}, KeyAliases: map[string]string{corev1.LabelTopologyRegion: "Region"}, Cloud: "aws", EC2Client: mock, TagRegionFromProviderID: true}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("Region"), Value: aws.String("us-east-1")}}, mock.createdTags)
+	})
+}
+
+func TestDetectCloudFromProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{providerID: "aws:///us-east-1a/i-1234567890abcdef0", want: "aws"},
+		{providerID: "gce://my-project/us-central1-a/instance-1", want: "gcp"},
+		{providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", want: "azure"},
+		{providerID: "openstack:///2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e", want: "openstack"},
+		{providerID: "kind://docker/kind/kind-worker", wantErr: true},
+		{providerID: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.providerID, func(t *testing.T) {
+			got, err := detectCloudFromProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReconcileKeyAliases(t *testing.T) {
+	overridden := maps.Clone(defaultKeyAliases)
+	overridden["topology.kubernetes.io/region"] = "Region"
+
+	tests := []struct {
+		name        string
+		keyAliases  map[string]string
+		nodeLabels  map[string]string
+		currentTags []types.TagDescription
+		createsTags []types.Tag
+		deletesTags []types.Tag
+	}{
+		{
+			name:       "default aliases",
+			keyAliases: defaultKeyAliases,
+			nodeLabels: map[string]string{
+				"topology.kubernetes.io/region":    "us-east-1",
+				"node.kubernetes.io/instance-type": "m5.large",
+			},
+			createsTags: []types.Tag{
+				{Key: aws.String("instance-type"), Value: aws.String("m5.large")},
+				{Key: aws.String("region"), Value: aws.String("us-east-1")},
+			},
+		},
+		{
+			name:       "overridden alias",
+			keyAliases: overridden,
+			nodeLabels: map[string]string{
+				"topology.kubernetes.io/region": "us-east-1",
+			},
+			createsTags: []types.Tag{
+				{Key: aws.String("Region"), Value: aws.String("us-east-1")},
+			},
+		},
+		{
+			name:       "deletion uses the aliased key",
+			keyAliases: defaultKeyAliases,
+			nodeLabels: map[string]string{
+				"node.kubernetes.io/instance-type": "m5.large",
+			},
+			currentTags: []types.TagDescription{
+				{Key: aws.String("instance-type"), Value: aws.String("m5.large")},
+				{Key: aws.String("region"), Value: aws.String("us-east-1")},
+				// the unaliased key isn't managed anymore and must be left alone
+				{Key: aws.String("topology.kubernetes.io/region"), Value: aws.String("us-east-1")},
+			},
+			deletesTags: []types.Tag{
+				{Key: aws.String("region"), Value: aws.String("us-east-1")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+
+			node := createNode("node1", tt.nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+			mock := &mockEC2Client{currentTags: tt.currentTags}
+			r := &NodeLabelController{
+				Client:     k8s,
+				Labels:     []string{"topology.kubernetes.io/region", "node.kubernetes.io/instance-type"},
+				Cloud:      "aws",
+				EC2Client:  mock,
+				KeyAliases: tt.keyAliases,
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.createsTags, mock.createdTags)
+			assert.Equal(t, tt.deletesTags, mock.deletedTags)
+		})
+	}
+}
+
+func TestReconcileConsolidateDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		consolidate bool
+		nodeLabels  map[string]string
+		currentTags []types.TagDescription
+		createsTags []types.Tag
+		deletesTags []types.Tag
+	}{
+		{
+			name:        "duplicates are consolidated to the managed key",
+			consolidate: true,
+			nodeLabels:  map[string]string{"env": "prod"},
+			currentTags: []types.TagDescription{
+				{Key: aws.String("Env"), Value: aws.String("staging")},
+				{Key: aws.String("ENV"), Value: aws.String("prod")},
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("Team"), Value: aws.String("platform")},
+			},
+			deletesTags: []types.Tag{
+				{Key: aws.String("ENV"), Value: aws.String("prod")},
+				{Key: aws.String("Env"), Value: aws.String("staging")},
+			},
+		},
+		{
+			name:        "managed key is created next to the deleted duplicate",
+			consolidate: true,
+			nodeLabels:  map[string]string{"env": "prod"},
+			currentTags: []types.TagDescription{
+				{Key: aws.String("Env"), Value: aws.String("prod")},
+			},
+			createsTags: []types.Tag{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+			deletesTags: []types.Tag{
+				{Key: aws.String("Env"), Value: aws.String("prod")},
+			},
+		},
+		{
+			name:       "duplicates are left alone by default",
+			nodeLabels: map[string]string{"env": "prod"},
+			currentTags: []types.TagDescription{
+				{Key: aws.String("Env"), Value: aws.String("staging")},
 				{Key: aws.String("env"), Value: aws.String("prod")},
 			},
 		},
@@ -117,23 +3288,19 @@ func TestReconcileAWS(t *testing.T) {
 			scheme := runtime.NewScheme()
 			require.NoError(t, corev1.AddToScheme(scheme))
 
-			k8s := fake.NewClientBuilder().
-				WithScheme(scheme).
-				WithObjects(tt.node).
-				Build()
+			node := createNode("node1", tt.nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
 
 			mock := &mockEC2Client{currentTags: tt.currentTags}
-
 			r := &NodeLabelController{
-				Client:    k8s,
-				Labels:    tt.labelsToCopy,
-				Cloud:     "aws",
-				EC2Client: mock,
+				Client:                   k8s,
+				Labels:                   []string{"env"},
+				Cloud:                    "aws",
+				EC2Client:                mock,
+				ConsolidateDuplicateKeys: tt.consolidate,
 			}
 
-			_, err := r.Reconcile(context.Background(), ctrl.Request{
-				NamespacedName: client.ObjectKey{Name: tt.node.Name},
-			})
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
 			require.NoError(t, err)
 
 			assert.Equal(t, tt.createsTags, mock.createdTags)
@@ -142,47 +3309,38 @@ func TestReconcileAWS(t *testing.T) {
 	}
 }
 
-func TestReconcileGCP(t *testing.T) {
+func TestReconcileStripAffixes(t *testing.T) {
 	tests := []struct {
-		name          string
-		labelsToCopy  []string
-		node          *corev1.Node
-		currentLabels map[string]string
-		wantLabels    map[string]string
+		name        string
+		nodeLabels  map[string]string
+		currentTags []types.TagDescription
+		createsTags []types.Tag
+		deletesTags []types.Tag
 	}{
 		{
-			name:          "sync new labels",
-			labelsToCopy:  []string{"env", "team"},
-			node:          createNode("node1", map[string]string{"env": "prod", "team": "platform"}, "gce://my-project/us-central1-a/instance-1"),
-			currentLabels: map[string]string{"env": "staging"},
-			wantLabels: map[string]string{
-				"env":  "prod",
-				"team": "platform",
-			},
-		},
-		{
-			name:         "preserve unmanaged labels",
-			labelsToCopy: []string{"env"},
-			node:         createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
-			currentLabels: map[string]string{
-				"env":         "staging",
-				"cost-center": "12345",
+			name: "keys and values are stripped",
+			nodeLabels: map[string]string{
+				"corp/env-managed":  "prod-managed",
+				"corp/team-managed": "v-platform",
 			},
-			wantLabels: map[string]string{
-				"env":         "prod",
-				"cost-center": "12345",
+			createsTags: []types.Tag{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
 			},
 		},
 		{
-			name:         "remove label",
-			labelsToCopy: []string{"env"},
-			node:         createNode("node1", nil, "gce://my-project/us-central1-a/instance-1"),
-			currentLabels: map[string]string{
-				"env":         "prod",
-				"cost-center": "12345",
+			name: "deletion uses the stripped key",
+			nodeLabels: map[string]string{
+				"corp/env-managed": "prod",
 			},
-			wantLabels: map[string]string{
-				"cost-center": "12345",
+			currentTags: []types.TagDescription{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
+				// the unstripped key isn't managed and must be left alone
+				{Key: aws.String("corp/team-managed"), Value: aws.String("platform")},
+			},
+			deletesTags: []types.Tag{
+				{Key: aws.String("team"), Value: aws.String("platform")},
 			},
 		},
 	}
@@ -192,30 +3350,336 @@ func TestReconcileGCP(t *testing.T) {
 			scheme := runtime.NewScheme()
 			require.NoError(t, corev1.AddToScheme(scheme))
 
-			k8s := fake.NewClientBuilder().
-				WithScheme(scheme).
-				WithObjects(tt.node).
-				Build()
-
-			mock := &mockGCEClient{instance: &gce.Instance{Labels: tt.currentLabels}}
+			node := createNode("node1", tt.nodeLabels, "aws:///us-east-1a/i-1234567890abcdef0")
+			k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
 
+			mock := &mockEC2Client{currentTags: tt.currentTags}
 			r := &NodeLabelController{
-				Client:    k8s,
-				Labels:    tt.labelsToCopy,
-				Cloud:     "gcp",
-				GCEClient: mock,
+				Client:             k8s,
+				Labels:             []string{"corp/env-managed", "corp/team-managed"},
+				Cloud:              "aws",
+				EC2Client:          mock,
+				StripKeyPrefixes:   []string{"corp/"},
+				StripKeySuffixes:   []string{"-managed"},
+				StripValuePrefixes: []string{"v-"},
+				StripValueSuffixes: []string{"-managed"},
 			}
 
-			_, err := r.Reconcile(context.Background(), ctrl.Request{
-				NamespacedName: client.ObjectKey{Name: tt.node.Name},
-			})
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
 			require.NoError(t, err)
 
-			assert.Equal(t, tt.wantLabels, mock.labels)
+			assert.Equal(t, tt.createsTags, mock.createdTags)
+			assert.Equal(t, tt.deletesTags, mock.deletedTags)
 		})
 	}
 }
 
+func TestReconcileTwoPhaseDelete(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	t.Run("aws", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
+			},
+		}
+		r := &NodeLabelController{
+			Client:                 k8s,
+			Labels:                 []string{"env", "team"},
+			Cloud:                  "aws",
+			EC2Client:              mock,
+			TwoPhaseDeleteInterval: time.Nanosecond,
+		}
+
+		// first observation: nothing is deleted and the node is requeued to confirm
+		res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Nil(t, mock.deletedTags)
+		assert.Equal(t, time.Nanosecond, res.RequeueAfter)
+
+		// second observation confirms the deletion
+		res, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}, mock.deletedTags)
+		assert.Zero(t, res.RequeueAfter)
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", nil, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"env": "prod"}}}
+		r := &NodeLabelController{
+			Client:                 k8s,
+			Labels:                 []string{"env"},
+			Cloud:                  "gcp",
+			GCEClient:              mock,
+			TwoPhaseDeleteInterval: time.Nanosecond,
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Nil(t, mock.labels, "no update expected before the deletion is confirmed")
+
+		_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{}, mock.labels)
+	})
+
+	t.Run("label restored before confirmation", func(t *testing.T) {
+		node := createNode("node1", nil, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{
+			currentTags: []types.TagDescription{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+			},
+		}
+		r := &NodeLabelController{
+			Client:                 k8s,
+			Labels:                 []string{"env"},
+			Cloud:                  "aws",
+			EC2Client:              mock,
+			TwoPhaseDeleteInterval: time.Hour,
+		}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		// the label flaps back, which clears the pending deletion
+		node.Labels = map[string]string{"env": "prod"}
+		require.NoError(t, k8s.Update(context.Background(), node))
+		res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+
+		assert.Nil(t, mock.deletedTags)
+		assert.Zero(t, res.RequeueAfter)
+		assert.False(t, r.pendingDeletes.has(instanceKey(node.Spec.ProviderID)))
+	})
+}
+
+// nodeLastErrorSeries returns the node_tagger_node_last_error series of a node, keyed by error label.
+func nodeLastErrorSeries(t *testing.T, node string) map[string]float64 {
+	t.Helper()
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(nodeLastError))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	series := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["node"] == node {
+				series[labels["error"]] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return series
+}
+
+func TestReconcileNodeLastErrorMetric(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("last-error-node", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{describeErr: errors.New("UnauthorizedOperation")}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.Error(t, err)
+	assert.Equal(t, map[string]float64{err.Error(): 1}, nodeLastErrorSeries(t, node.Name))
+
+	// a different error replaces the previous series
+	mock.describeErr = errors.New("RequestLimitExceeded")
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.Error(t, err)
+	assert.Equal(t, map[string]float64{err.Error(): 1}, nodeLastErrorSeries(t, node.Name))
+
+	// success clears it
+	mock.describeErr = nil
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Empty(t, nodeLastErrorSeries(t, node.Name))
+}
+
+// scrapeSyncMetrics gathers the controller-runtime metrics registry and returns the value of the
+// tag sync counters, and the sample count of the sync duration histogram, of cloud.
+func scrapeSyncMetrics(t *testing.T, cloud string) map[string]float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "node_tagger_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() != "cloud" || l.GetValue() != cloud {
+					continue
+				}
+				switch {
+				case m.GetCounter() != nil:
+					values[mf.GetName()] = m.GetCounter().GetValue()
+				case m.GetHistogram() != nil:
+					values[mf.GetName()] = float64(m.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestReconcileSyncMetrics(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "platform"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{
+		currentTags: []types.TagDescription{
+			{Key: aws.String("zone"), Value: aws.String("us-east-1a")},
+		},
+	}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env", "team", "zone"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	before := scrapeSyncMetrics(t, "aws")
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	after := scrapeSyncMetrics(t, "aws")
+
+	assert.Equal(t, 2.0, after["node_tagger_tags_created_total"]-before["node_tagger_tags_created_total"])
+	assert.Equal(t, 1.0, after["node_tagger_tags_deleted_total"]-before["node_tagger_tags_deleted_total"])
+	assert.Equal(t, 0.0, after["node_tagger_sync_errors_total"]-before["node_tagger_sync_errors_total"])
+	assert.Equal(t, 1.0, after["node_tagger_sync_duration_seconds"]-before["node_tagger_sync_duration_seconds"])
+
+	// a failed sync is counted as an error and still timed
+	mock.describeErr = errors.New("RequestLimitExceeded")
+	before = after
+	_, err = r.Reconcile(context.Background(), req)
+	require.Error(t, err)
+	after = scrapeSyncMetrics(t, "aws")
+
+	assert.Equal(t, 0.0, after["node_tagger_tags_created_total"]-before["node_tagger_tags_created_total"])
+	assert.Equal(t, 1.0, after["node_tagger_sync_errors_total"]-before["node_tagger_sync_errors_total"])
+	assert.Equal(t, 1.0, after["node_tagger_sync_duration_seconds"]-before["node_tagger_sync_duration_seconds"])
+}
+
+func TestCleanupSyncMetrics(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("gcp", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Labels: map[string]string{"env": "prod", "team": "platform"}}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team"}, Cloud: "gcp", GCEClient: mock, CleanupOnDelete: true}
+
+		before := scrapeSyncMetrics(t, "gcp")
+		require.True(t, r.shouldProcessNodeDelete(node))
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		after := scrapeSyncMetrics(t, "gcp")
+
+		assert.Equal(t, 2.0, after["node_tagger_tags_deleted_total"]-before["node_tagger_tags_deleted_total"])
+		assert.Equal(t, 1.0, after["node_tagger_sync_duration_seconds"]-before["node_tagger_sync_duration_seconds"])
+	})
+
+	t.Run("failed cleanup", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		mock := &mockEC2Client{describeErr: errors.New("RequestLimitExceeded")}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CleanupOnDelete: true}
+
+		before := scrapeSyncMetrics(t, "aws")
+		require.True(t, r.shouldProcessNodeDelete(node))
+		_, err := r.Reconcile(context.Background(), req)
+		require.Error(t, err)
+		after := scrapeSyncMetrics(t, "aws")
+
+		assert.Equal(t, 1.0, after["node_tagger_sync_errors_total"]-before["node_tagger_sync_errors_total"])
+	})
+}
+
+func TestNodeErrorTrackerCardinality(t *testing.T) {
+	var tracker nodeErrorTracker
+	t.Cleanup(func() {
+		for i := range maxErrorNodes + 1 {
+			tracker.clear(fmt.Sprintf("capped-node-%d", i))
+		}
+	})
+
+	for i := range maxErrorNodes + 1 {
+		tracker.set(fmt.Sprintf("capped-node-%d", i), errors.New(strings.Repeat("x", 2*maxErrorLabelLength)))
+	}
+
+	assert.Len(t, nodeLastErrorSeries(t, "capped-node-0"), 1)
+	assert.Contains(t, nodeLastErrorSeries(t, "capped-node-0"), strings.Repeat("x", maxErrorLabelLength))
+	assert.Empty(t, nodeLastErrorSeries(t, fmt.Sprintf("capped-node-%d", maxErrorNodes)), "nodes beyond the cap must not be exported")
+}
+
+func TestReconcileAnnotations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := withAnnotations(
+		createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0"),
+		map[string]string{"example.com/cost-center": "12345"},
+	)
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{
+		currentTags: []types.TagDescription{
+			{Key: aws.String("example.com/owner"), Value: aws.String("team-a")},
+		},
+	}
+	r := &NodeLabelController{
+		Client:      k8s,
+		Labels:      []string{"env"},
+		Annotations: []string{"example.com/cost-center", "example.com/owner"},
+		Cloud:       "aws",
+		EC2Client:   mock,
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("example.com/cost-center"), Value: aws.String("12345")},
+	}, mock.createdTags)
+	assert.Equal(t, []types.Tag{{Key: aws.String("example.com/owner"), Value: aws.String("team-a")}}, mock.deletedTags)
+}
+
 func TestShouldProcessNodeUpdate(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -259,19 +3723,40 @@ func TestShouldProcessNodeUpdate(t *testing.T) {
 			monitoredLabels: []string{"env", "team"},
 			want:            true,
 		},
+		{
+			name:            "label matching a pattern added",
+			oldLabels:       map[string]string{"env": "prod"},
+			newLabels:       map[string]string{"env": "prod", "topology.kubernetes.io/zone": "us-east-1a"},
+			monitoredLabels: []string{"topology.kubernetes.io/*"},
+			want:            true,
+		},
+		{
+			name:            "label matching a pattern removed",
+			oldLabels:       map[string]string{"topology.kubernetes.io/zone": "us-east-1a"},
+			newLabels:       nil,
+			monitoredLabels: []string{"topology.kubernetes.io/*"},
+			want:            true,
+		},
+		{
+			name:            "label not matching a pattern changed",
+			oldLabels:       map[string]string{"topology.kubernetes.io/zone": "us-east-1a", "env": "staging"},
+			newLabels:       map[string]string{"topology.kubernetes.io/zone": "us-east-1a", "env": "prod"},
+			monitoredLabels: []string{"topology.kubernetes.io/*"},
+			want:            false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			oldNode := createNode("node1", tt.oldLabels, "")
 			newNode := createNode("node1", tt.newLabels, "")
-			got := shouldProcessNodeUpdate(oldNode, newNode, tt.monitoredLabels)
+			got := shouldProcessNodeUpdate(oldNode, newNode, tt.monitoredLabels, nil)
 			assert.Equal(t, tt.want, got)
 		})
 	}
 
 	// extra safety test for nil node input
-	assert.False(t, shouldProcessNodeUpdate(nil, nil, []string{"env"}))
+	assert.False(t, shouldProcessNodeUpdate(nil, nil, []string{"env"}, nil))
 }
 
 func TestShouldProcessNodeCreate(t *testing.T) {
@@ -305,18 +3790,59 @@ func TestShouldProcessNodeCreate(t *testing.T) {
 			monitoredLabels: []string{"env"},
 			want:            false,
 		},
+		{
+			name:            "node with a label matching a pattern",
+			labels:          map[string]string{"topology.kubernetes.io/zone": "us-east-1a"},
+			monitoredLabels: []string{"topology.kubernetes.io/*"},
+			want:            true,
+		},
+		{
+			name:            "node without a label matching a pattern",
+			labels:          map[string]string{"env": "prod"},
+			monitoredLabels: []string{"topology.kubernetes.io/*"},
+			want:            false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			node := createNode("node1", tt.labels, "")
-			got := shouldProcessNodeCreate(node, tt.monitoredLabels)
+			got := shouldProcessNodeCreate(node, tt.monitoredLabels, nil)
 			assert.Equal(t, tt.want, got)
 		})
 	}
 
 	// extra safety test for nil node input
-	assert.False(t, shouldProcessNodeCreate(nil, []string{"env"}))
+	assert.False(t, shouldProcessNodeCreate(nil, []string{"env"}, nil))
+}
+
+func TestShouldProcessNodeAnnotations(t *testing.T) {
+	labels := []string{"env"}
+	annotations := []string{"example.com/cost-center"}
+
+	// create/resync: a node that only carries a monitored annotation is picked up
+	assert.True(t, shouldProcessNodeCreate(
+		withAnnotations(createNode("node1", nil, ""), map[string]string{"example.com/cost-center": "12345"}),
+		labels, annotations))
+	assert.False(t, shouldProcessNodeCreate(
+		withAnnotations(createNode("node1", nil, ""), map[string]string{"example.com/other": "x"}),
+		labels, annotations))
+
+	// update: monitored annotation added, changed or removed
+	oldNode := createNode("node1", map[string]string{"env": "prod"}, "")
+	newNode := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, ""), map[string]string{"example.com/cost-center": "12345"})
+	assert.True(t, shouldProcessNodeUpdate(oldNode, newNode, labels, annotations))
+	assert.True(t, shouldProcessNodeUpdate(newNode, oldNode, labels, annotations))
+
+	changed := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, ""), map[string]string{"example.com/cost-center": "67890"})
+	assert.True(t, shouldProcessNodeUpdate(newNode, changed, labels, annotations))
+
+	// an unmonitored annotation change is ignored
+	unmonitored := withAnnotations(createNode("node1", map[string]string{"env": "prod"}, ""), map[string]string{
+		"example.com/cost-center": "12345",
+		"example.com/other":       "x",
+	})
+	assert.False(t, shouldProcessNodeUpdate(newNode, unmonitored, labels, annotations))
 }
 
 func TestParseGCPProviderID(t *testing.T) {
@@ -367,6 +3893,43 @@ func TestParseGCPProviderID(t *testing.T) {
 			wantInstance: "instance-1",
 			wantErr:      false,
 		},
+		{
+			name:         "multi-word region",
+			providerID:   "gce://my-project/northamerica-northeast1-b/instance-1",
+			wantProject:  "my-project",
+			wantZone:     "northamerica-northeast1-b",
+			wantInstance: "instance-1",
+		},
+		{
+			name:       "region instead of zone",
+			providerID: "gce://my-project/us-central1/instance-1",
+			wantErr:    true,
+		},
+		{
+			name:       "project and zone swapped",
+			providerID: "gce://us-central1-a/my-project/instance-1",
+			wantErr:    true,
+		},
+		{
+			name:       "uppercase zone",
+			providerID: "gce://my-project/US-CENTRAL1-A/instance-1",
+			wantErr:    true,
+		},
+		{
+			name:       "empty zone",
+			providerID: "gce://my-project//instance-1",
+			wantErr:    true,
+		},
+		{
+			name:       "empty project",
+			providerID: "gce:///us-central1-a/instance-1",
+			wantErr:    true,
+		},
+		{
+			name:       "empty instance name",
+			providerID: "gce://my-project/us-central1-a/",
+			wantErr:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -391,6 +3954,10 @@ func TestSanitizeLabelsForGCP(t *testing.T) {
 		name   string
 		labels map[string]string
 		want   map[string]string
+
+		wantSanitizedKeys   float64
+		wantSanitizedValues float64
+		wantTruncatedValues float64
 	}{
 		{
 			name: "simple labels",
@@ -399,9 +3966,11 @@ func TestSanitizeLabelsForGCP(t *testing.T) {
 				"Another.Key": "Another Value",
 			},
 			want: map[string]string{
-				"example_key": "Example Value",
-				"another-key": "Another Value",
+				"example_key": "example_value",
+				"another-key": "another_value",
 			},
+			wantSanitizedKeys:   2,
+			wantSanitizedValues: 2,
 		},
 		{
 			name: "labels with special characters",
@@ -410,9 +3979,11 @@ func TestSanitizeLabelsForGCP(t *testing.T) {
 				"Project.Version": "Version-1.2.3",
 			},
 			want: map[string]string{
-				"domain-com_key":  "Value_1",
-				"project-version": "Version-1.2.3",
+				"domain-com_key":  "value_1",
+				"project-version": "version-1-2-3",
 			},
+			wantSanitizedKeys:   2,
+			wantSanitizedValues: 2,
 		},
 		{
 			name: "labels exceeding maximum length",
@@ -422,13 +3993,26 @@ func TestSanitizeLabelsForGCP(t *testing.T) {
 			want: map[string]string{
 				strings.Repeat("a", 63): strings.Repeat("b", 63),
 			},
+			wantSanitizedKeys:   1,
+			wantSanitizedValues: 1,
+			wantTruncatedValues: 1,
+		},
+		{
+			name:   "valid labels",
+			labels: map[string]string{"env": "prod", "team-name": strings.Repeat("b", 63)},
+			want:   map[string]string{"env": "prod", "team-name": strings.Repeat("b", 63)},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			keys, values, truncated := testutil.ToFloat64(sanitizedKeys), testutil.ToFloat64(sanitizedValues), testutil.ToFloat64(truncatedValues)
+
 			got := sanitizeLabelsForGCP(tt.labels)
 			assert.Equal(t, tt.want, got, "sanitizeLabelsForGCP() returned unexpected result")
+			assert.Equal(t, tt.wantSanitizedKeys, testutil.ToFloat64(sanitizedKeys)-keys)
+			assert.Equal(t, tt.wantSanitizedValues, testutil.ToFloat64(sanitizedValues)-values)
+			assert.Equal(t, tt.wantTruncatedValues, testutil.ToFloat64(truncatedValues)-truncated)
 		})
 	}
 }
@@ -454,6 +4038,21 @@ func TestSanitizeKeysForGCP(t *testing.T) {
 			key:  strings.Repeat("a", 70),
 			want: strings.Repeat("a", 63),
 		},
+		{
+			name: "multibyte key exceeding maximum length",
+			key:  strings.Repeat("ä", 70),
+			want: strings.Repeat("ä", 63),
+		},
+		{
+			name: "key truncated before a separator",
+			key:  strings.Repeat("a", 62) + "/" + strings.Repeat("b", 10),
+			want: strings.Repeat("a", 62),
+		},
+		{
+			name: "prefixed key",
+			key:  "k8s:env",
+			want: "k8s_env",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -463,6 +4062,88 @@ func TestSanitizeKeysForGCP(t *testing.T) {
 	}
 }
 
+func TestSanitizeKeysForGCPCollisions(t *testing.T) {
+	got := sanitizeKeysForGCP([]string{"example.com/key", "example.com/Key", "team.name", "team-name", "env", strings.Repeat("a", 70), strings.Repeat("A", 70)})
+
+	// keys that don't collide are only sanitized
+	assert.Equal(t, "env", got["env"])
+	assert.Equal(t, strings.Repeat("a", 63), got[strings.Repeat("A", 70)])
+
+	// the first colliding key in order, or the already sanitized one, keeps the sanitized key
+	assert.Equal(t, "example-com_key", got["example.com/Key"])
+	assert.Regexp(t, `^example-com_key-[0-9a-f]{8}$`, got["example.com/key"])
+	assert.Equal(t, "team-name", got["team-name"])
+	assert.Regexp(t, `^team-name-[0-9a-f]{8}$`, got["team.name"])
+
+	// suffixed keys are truncated to fit
+	assert.Regexp(t, `^a{54}-[0-9a-f]{8}$`, got[strings.Repeat("a", 70)])
+
+	// the result doesn't depend on the order of the keys
+	assert.Equal(t, got, sanitizeKeysForGCP([]string{strings.Repeat("A", 70), "team-name", "example.com/key", "env", "team.name", "example.com/Key", strings.Repeat("a", 70)}))
+}
+
+func TestGCPKeyCollisions(t *testing.T) {
+	// both keys sanitize to example-com_key, the second one in order is suffixed
+	got := gcpKeyCollisions([]string{"example.com/key", "example-com/key", "env"})
+	require.Len(t, got, 1)
+	assert.Regexp(t, `^example-com_key-[0-9a-f]{8}$`, got["example.com/key"])
+
+	assert.Empty(t, gcpKeyCollisions([]string{"example.com/key", "env"}))
+}
+
+func TestReconcileGCPKeyCollisions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	labels := []string{"example.com/Key", "example.com/key"}
+	gcpKeys := sanitizeKeysForGCP(labels)
+	node := createNode("node1", map[string]string{"example.com/Key": "a", "example.com/key": "b"}, "gce://my-project/us-central1-a/instance-1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockGCEClient{instance: &gce.Instance{}}
+	r := &NodeLabelController{Client: k8s, Labels: labels, Cloud: "gcp", GCEClient: mock}
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{gcpKeys["example.com/Key"]: "a", gcpKeys["example.com/key"]: "b"}, mock.labels)
+	assert.Len(t, mock.labels, 2)
+
+	// the keys don't move when one of the colliding labels is removed
+	delete(node.Labels, "example.com/Key")
+	require.NoError(t, k8s.Update(context.Background(), node))
+	mock.instance.Labels = mock.labels
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{gcpKeys["example.com/key"]: "b"}, mock.labels)
+}
+
+func TestSanitizeValueForGCP(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "valid value", value: "prod_eu-1", want: "prod_eu-1"},
+		{name: "uppercase", value: "Production", want: "production"},
+		{name: "dots", value: "c5.xlarge", want: "c5-xlarge"},
+		{name: "slash", value: "us-east-1a/Zone", want: "us-east-1a_zone"},
+		{name: "other characters", value: "a b:c@d", want: "a_b_c_d"},
+		{name: "leading and trailing invalid characters", value: ".prod/", want: "-prod_"},
+		{name: "leading and trailing spaces", value: " Prod ", want: "_prod_"},
+		{name: "mixed case with dots", value: "US-East-1.C5.xlarge", want: "us-east-1-c5-xlarge"},
+		{name: "unicode letters", value: "café", want: "café"},
+		{name: "exceeding maximum length", value: strings.Repeat("B", 70), want: strings.Repeat("b", 63)},
+		{name: "exceeding maximum length in characters", value: strings.Repeat("é", 70), want: strings.Repeat("é", 63)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeValueForGCP(tt.value))
+		})
+	}
+}
+
 // ----
 
 // func TestReconcile(t *testing.T) {
@@ -577,3 +4258,65 @@ func createNode(name string, labels map[string]string, providerID string) *corev
 		},
 	}
 }
+
+func withAnnotations(node *corev1.Node, annotations map[string]string) *corev1.Node {
+	node.Annotations = annotations
+	return node
+}
+
+func TestReconcileAWSPerRegionClients(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	nodes := []*corev1.Node{
+		createNode("east-a", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-east-a"),
+		createNode("east-b", map[string]string{"env": "prod"}, "aws:///us-east-1b/i-east-b"),
+		createNode("west", map[string]string{"env": "prod"}, "aws:///eu-west-1a/i-west"),
+		createNode("no-az", map[string]string{"env": "prod"}, "aws:///i-no-az"),
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, n := range nodes {
+		builder = builder.WithObjects(n)
+	}
+	k8s := builder.Build()
+
+	home := &mockEC2Client{}
+	regional := map[string]*mockEC2Client{}
+	r := &NodeLabelController{
+		Client:     k8s,
+		Labels:     []string{"env"},
+		Cloud:      "aws",
+		EC2Client:  home,
+		AZToRegion: regionFromAZ,
+		NewEC2Client: func(region string) ec2Client {
+			m := &mockEC2Client{}
+			regional[region] = m
+			return m
+		},
+	}
+
+	tests := []struct {
+		node       string
+		wantClient func() *mockEC2Client
+	}{
+		{node: "east-a", wantClient: func() *mockEC2Client { return regional["us-east-1"] }},
+		{node: "east-b", wantClient: func() *mockEC2Client { return regional["us-east-1"] }},
+		{node: "west", wantClient: func() *mockEC2Client { return regional["eu-west-1"] }},
+		{node: "no-az", wantClient: func() *mockEC2Client { return home }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: tt.node}})
+			require.NoError(t, err)
+
+			m := tt.wantClient()
+			require.NotNil(t, m)
+			assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, m.createdTags)
+			m.createdTags = nil
+		})
+	}
+
+	// one client per region, reused across instances in the same region
+	assert.Len(t, regional, 2)
+	assert.Nil(t, home.createdTags)
+}
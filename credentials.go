@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"golang.org/x/oauth2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// credentialsProbeTimeout bounds the cloud API calls of a credentials probe
+	credentialsProbeTimeout = 5 * time.Second
+
+	// awsProbeInstanceID is the ID of a nonexistent instance, whose tags are described to probe
+	// the AWS credentials without listing the tags of the account
+	awsProbeInstanceID = "i-00000000000000000"
+
+	// azureManagementScope is the scope of the tokens of the Azure Resource Manager API
+	azureManagementScope = "https://management.azure.com/.default"
+
+	// credentialsFailureThreshold is how many consecutive probes of verified credentials have to
+	// fail for CredentialsCheck to fail
+	credentialsFailureThreshold = 3
+)
+
+// CredentialsCheck is a readiness check that fails until the credentials of the cloud provider
+// worked for a lightweight API call, unless the tag updates go to a Sink. With the auto cloud,
+// the credentials of any of the clouds set up will do. Once verified, the credentials are only
+// probed again every CredentialsRecheckInterval, and the check fails after
+// credentialsFailureThreshold consecutive failed probes so a transient error doesn't flap it.
+func (r *NodeLabelController) CredentialsCheck(req *http.Request) error {
+	if r.Sink != nil {
+		return nil
+	}
+
+	r.credentialsMu.Lock()
+	defer r.credentialsMu.Unlock()
+	verified := r.credentialsVerified.Load()
+	if verified && (r.CredentialsRecheckInterval <= 0 || time.Since(r.credentialsCheckedAt) < r.CredentialsRecheckInterval) {
+		return r.credentialsErr
+	}
+	if !r.cloudReady.Load() {
+		return fmt.Errorf("cloud provider %q is not set up", r.Cloud)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), credentialsProbeTimeout)
+	defer cancel()
+	logger := ctrl.LoggerFrom(ctx)
+
+	cloud, err := r.probeCredentials(ctx)
+	r.credentialsCheckedAt = time.Now()
+	if err == nil {
+		if !verified || r.credentialsErr != nil {
+			logger.Info("Verified cloud credentials", "cloud", cloud)
+		}
+		r.credentialsVerified.Store(true)
+		r.credentialFailures, r.credentialsErr = 0, nil
+		return nil
+	}
+	if !verified {
+		return err
+	}
+
+	r.credentialFailures++
+	if r.credentialFailures < credentialsFailureThreshold {
+		logger.Info("Cloud credentials check failed", "failures", r.credentialFailures, "error", err)
+		return r.credentialsErr
+	}
+	r.credentialsErr = err
+	return err
+}
+
+// probeCredentials runs the credential probes until one succeeds, returning its cloud.
+func (r *NodeLabelController) probeCredentials(ctx context.Context) (string, error) {
+	var errs []error
+	for _, cloud := range slices.Sorted(maps.Keys(r.credentialProbes)) {
+		if err := r.credentialProbes[cloud](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to verify %s credentials: %v", cloud, err))
+			continue
+		}
+		return cloud, nil
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("no credentials to verify for cloud provider %q", r.Cloud)
+	}
+	return "", errors.Join(errs...)
+}
+
+// setCredentialProbe sets the function probing the credentials of cloud.
+func (r *NodeLabelController) setCredentialProbe(cloud string, probe func(context.Context) error) {
+	if r.credentialProbes == nil {
+		r.credentialProbes = make(map[string]func(context.Context) error)
+	}
+	r.credentialProbes[cloud] = probe
+}
+
+// probeAWSCredentials describes the tags of a nonexistent instance with c, which only succeeds
+// with valid credentials allowed to call DescribeTags.
+func probeAWSCredentials(ctx context.Context, c ec2Client) error {
+	_, err := c.DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []string{awsProbeInstanceID},
+			},
+		},
+		MaxResults: aws.Int32(5),
+	})
+	return err
+}
+
+// probeGCPCredentials fetches an OAuth token from ts, the token source of the GCE client.
+func probeGCPCredentials(ts oauth2.TokenSource) error {
+	_, err := ts.Token()
+	return err
+}
+
+// probeAzureCredentials fetches a token of the Azure Resource Manager API with cred.
+func probeAzureCredentials(ctx context.Context, cred azcore.TokenCredential) error {
+	_, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureManagementScope}})
+	return err
+}
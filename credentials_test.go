@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsCheck(t *testing.T) {
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	t.Run("aws", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "aws", AWSRegion: "us-east-1"}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+		mock := &mockEC2Client{describeErr: errors.New("UnauthorizedOperation")}
+		r.EC2Client = mock
+
+		assert.EqualError(t, r.CredentialsCheck(req), "unable to verify aws credentials: UnauthorizedOperation")
+
+		mock.describeErr = nil
+		assert.NoError(t, r.CredentialsCheck(req))
+		assert.Equal(t, 2, mock.describeTagsCalls)
+
+		// verified credentials aren't probed again
+		mock.describeErr = errors.New("UnauthorizedOperation")
+		assert.NoError(t, r.CredentialsCheck(req))
+		assert.Equal(t, 2, mock.describeTagsCalls)
+	})
+
+	t.Run("re-check", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "aws", AWSRegion: "us-east-1", CredentialsRecheckInterval: time.Hour}
+		require.NoError(t, r.SetupCloudProvider(context.Background()))
+		mock := &mockEC2Client{}
+		r.EC2Client = mock
+
+		require.NoError(t, r.CredentialsCheck(req))
+		assert.Equal(t, 1, mock.describeTagsCalls)
+
+		// not probed again within the interval
+		require.NoError(t, r.CredentialsCheck(req))
+		assert.Equal(t, 1, mock.describeTagsCalls)
+
+		// a transient error doesn't fail the check, consecutive ones do
+		mock.describeErr = errors.New("RequestLimitExceeded")
+		for i := 1; i < credentialsFailureThreshold; i++ {
+			r.credentialsCheckedAt = time.Time{}
+			assert.NoError(t, r.CredentialsCheck(req))
+		}
+		r.credentialsCheckedAt = time.Time{}
+		assert.EqualError(t, r.CredentialsCheck(req), "unable to verify aws credentials: RequestLimitExceeded")
+		assert.Equal(t, 1+credentialsFailureThreshold, mock.describeTagsCalls)
+
+		// the failure is reported until the next probe
+		assert.EqualError(t, r.CredentialsCheck(req), "unable to verify aws credentials: RequestLimitExceeded")
+		assert.Equal(t, 1+credentialsFailureThreshold, mock.describeTagsCalls)
+
+		// a successful probe resets the failures
+		mock.describeErr = nil
+		r.credentialsCheckedAt = time.Time{}
+		assert.NoError(t, r.CredentialsCheck(req))
+		mock.describeErr = errors.New("RequestLimitExceeded")
+		r.credentialsCheckedAt = time.Time{}
+		assert.NoError(t, r.CredentialsCheck(req))
+	})
+
+	t.Run("any cloud of auto", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: cloudAuto}
+		r.cloudReady.Store(true)
+		awsErr := errors.New("no credentials")
+		r.setCredentialProbe("aws", func(ctx context.Context) error { return awsErr })
+		r.setCredentialProbe("gcp", func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			assert.True(t, ok, "probes have a timeout")
+			return errors.New("metadata server unreachable")
+		})
+
+		assert.EqualError(t, r.CredentialsCheck(req), "unable to verify aws credentials: no credentials\nunable to verify gcp credentials: metadata server unreachable")
+
+		awsErr = nil
+		assert.NoError(t, r.CredentialsCheck(req))
+	})
+
+	t.Run("not set up", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "gcp"}
+		assert.EqualError(t, r.CredentialsCheck(req), `cloud provider "gcp" is not set up`)
+	})
+
+	t.Run("sink", func(t *testing.T) {
+		r := &NodeLabelController{Cloud: "aws", Sink: &fileSink{}}
+		assert.NoError(t, r.CredentialsCheck(req))
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pendingDeletes tracks tag deletions awaiting confirmation for --two-phase-delete. A tag is only
+// deleted once it has been observed as "should delete" on two reconciles at least an interval
+// apart, which guards against transient cache inconsistencies removing tags by mistake.
+type pendingDeletes struct {
+	mu      sync.Mutex
+	pending map[string]map[string]time.Time // instance key -> tag key -> first observed
+}
+
+// confirm records keys as pending deletion for instance and returns the ones that were already
+// pending for at least interval. Previously pending keys missing from keys are no longer
+// candidates for deletion and are forgotten, so a later deletion starts a new confirmation window.
+func (p *pendingDeletes) confirm(instance string, keys []string, interval time.Duration, now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		p.pending = make(map[string]map[string]time.Time)
+	}
+
+	prev := p.pending[instance]
+	next := make(map[string]time.Time)
+	var confirmed []string
+	for _, k := range keys {
+		firstSeen, ok := prev[k]
+		switch {
+		case !ok:
+			next[k] = now
+		case now.Sub(firstSeen) >= interval:
+			confirmed = append(confirmed, k)
+		default:
+			next[k] = firstSeen
+		}
+	}
+
+	if len(next) == 0 {
+		delete(p.pending, instance)
+	} else {
+		p.pending[instance] = next
+	}
+	return confirmed
+}
+
+// has reports whether instance has deletions awaiting confirmation.
+func (p *pendingDeletes) has(instance string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending[instance]) > 0
+}
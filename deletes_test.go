@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingDeletesConfirm(t *testing.T) {
+	var p pendingDeletes
+	start := time.Now()
+	interval := time.Minute
+
+	// first observation only records the keys
+	assert.Empty(t, p.confirm("aws/i-1", []string{"env", "team"}, interval, start))
+	assert.True(t, p.has("aws/i-1"))
+
+	// still within the window
+	assert.Empty(t, p.confirm("aws/i-1", []string{"env", "team"}, interval, start.Add(30*time.Second)))
+
+	// "team" came back in the meantime, so only "env" is confirmed
+	assert.Equal(t, []string{"env"}, p.confirm("aws/i-1", []string{"env"}, interval, start.Add(interval)))
+	assert.False(t, p.has("aws/i-1"))
+
+	// a key that disappears and reappears starts a new window
+	assert.Empty(t, p.confirm("aws/i-1", []string{"team"}, interval, start.Add(2*interval)))
+	assert.Empty(t, p.confirm("aws/i-1", nil, interval, start.Add(3*interval)))
+	assert.Empty(t, p.confirm("aws/i-1", []string{"team"}, interval, start.Add(4*interval)))
+	assert.Equal(t, []string{"team"}, p.confirm("aws/i-1", []string{"team"}, interval, start.Add(5*interval)))
+
+	// instances are tracked independently
+	assert.Empty(t, p.confirm("aws/i-2", []string{"env"}, interval, start))
+	assert.False(t, p.has("aws/i-1"))
+	assert.True(t, p.has("aws/i-2"))
+}
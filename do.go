@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// doTokenEnv is the environment variable holding the DigitalOcean API token, as for doctl
+	doTokenEnv = "DIGITALOCEAN_ACCESS_TOKEN"
+
+	// maxDOTagLength is the maximum length of a DigitalOcean tag
+	maxDOTagLength = 255
+
+	// doTagSeparator separates the key from the value in the valueless DigitalOcean tags, eg:
+	// env:prod
+	doTagSeparator = ":"
+)
+
+// minimal interface we need for managing DigitalOcean droplet tags
+type doClient interface {
+	GetDropletTags(ctx context.Context, dropletID string) ([]string, error)
+	TagDroplet(ctx context.Context, dropletID, tag string) error
+	UntagDroplet(ctx context.Context, dropletID, tag string) error
+}
+
+var _ doClient = (*doAPIClient)(nil)
+
+// DigitalOcean client implementation using the godo client
+type doAPIClient struct {
+	client *godo.Client
+}
+
+// newDOAPIClient returns a client of the DigitalOcean API authenticated with token. A nil
+// httpClient means http.DefaultClient.
+func newDOAPIClient(httpClient *http.Client, token string, opts ...godo.ClientOpt) (*doAPIClient, error) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	c, err := godo.New(newTokenHTTPClient(httpClient, ts), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &doAPIClient{client: c}, nil
+}
+
+// isRetryableDOError returns whether err is a DigitalOcean API error worth retrying later:
+// throttling and server errors.
+func isRetryableDOError(err error) bool {
+	var derr *godo.ErrorResponse
+	if !errors.As(err, &derr) || derr.Response == nil {
+		return false
+	}
+	return derr.Response.StatusCode == http.StatusTooManyRequests || derr.Response.StatusCode >= http.StatusInternalServerError
+}
+
+// isNotFoundDOError returns whether err is a DigitalOcean API error of a missing
+// resource, eg: a deleted droplet.
+func isNotFoundDOError(err error) bool {
+	var derr *godo.ErrorResponse
+	return errors.As(err, &derr) && derr.Response != nil && derr.Response.StatusCode == http.StatusNotFound
+}
+
+func (c *doAPIClient) GetDropletTags(ctx context.Context, dropletID string) ([]string, error) {
+	id, err := strconv.Atoi(dropletID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid droplet ID %q", dropletID)
+	}
+	droplet, _, err := c.client.Droplets.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return droplet.Tags, nil
+}
+
+// TagDroplet creates tag, which has to exist before it's applied, and tags the droplet with it.
+func (c *doAPIClient) TagDroplet(ctx context.Context, dropletID, tag string) error {
+	if _, _, err := c.client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag}); err != nil {
+		return err
+	}
+	_, err := c.client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{Resources: doDropletResources(dropletID)})
+	return err
+}
+
+// UntagDroplet removes tag from the droplet. The tag itself is left for other droplets.
+func (c *doAPIClient) UntagDroplet(ctx context.Context, dropletID, tag string) error {
+	_, err := c.client.Tags.UntagResources(ctx, tag, &godo.UntagResourcesRequest{Resources: doDropletResources(dropletID)})
+	return err
+}
+
+// checkAccount fetches the account of the token, eg: to verify the credentials.
+func (c *doAPIClient) checkAccount(ctx context.Context) error {
+	_, _, err := c.client.Account.Get(ctx)
+	return err
+}
+
+// doDropletResources returns the resources of the tag and untag requests of a droplet.
+func doDropletResources(dropletID string) []godo.Resource {
+	return []godo.Resource{{ID: dropletID, Type: godo.DropletResourceType}}
+}
+
+// parseDOProviderID parses the droplet ID from a DigitalOcean provider ID of the form
+// digitalocean://<droplet-id>.
+func parseDOProviderID(providerID string) (string, error) {
+	id, ok := strings.CutPrefix(providerID, "digitalocean://")
+	if !ok {
+		return "", fmt.Errorf("invalid DigitalOcean provider ID format: %q", providerID)
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid DigitalOcean droplet ID in provider ID %q", providerID)
+	}
+	return id, nil
+}
+
+// doTag returns the DigitalOcean tag of a key and value: both sanitized and joined by
+// doTagSeparator, truncated to maxDOTagLength.
+func doTag(key, value string) string {
+	tag := sanitizeForDO(key) + doTagSeparator + sanitizeForDO(value)
+	if runes := []rune(tag); len(runes) > maxDOTagLength {
+		tag = string(runes[:maxDOTagLength])
+	}
+	return tag
+}
+
+// sanitizeForDO replaces the characters DigitalOcean doesn't allow in tags with underscores.
+// ASCII letters and digits, colons, dashes and underscores are allowed.
+func sanitizeForDO(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(":-_", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// doTagKey returns the longest of keys, sanitized, that tag is a value of, and whether there's one.
+func doTagKey(tag string, keys []string) (string, bool) {
+	var found string
+	for _, k := range keys {
+		if k = sanitizeForDO(k); strings.HasPrefix(tag, k+doTagSeparator) && len(k) > len(found) {
+			found = k
+		}
+	}
+	return found, found != ""
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type mockDOClient struct {
+	tags    []string
+	added   []string
+	removed []string
+}
+
+func (m *mockDOClient) GetDropletTags(ctx context.Context, dropletID string) ([]string, error) {
+	return m.tags, nil
+}
+
+func (m *mockDOClient) TagDroplet(ctx context.Context, dropletID, tag string) error {
+	m.added = append(m.added, tag)
+	return nil
+}
+
+func (m *mockDOClient) UntagDroplet(ctx context.Context, dropletID, tag string) error {
+	m.removed = append(m.removed, tag)
+	return nil
+}
+
+func TestParseDOProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{providerID: "digitalocean://123456789", want: "123456789"},
+		{providerID: "digitalocean://", wantErr: true},
+		{providerID: "digitalocean://droplet-1", wantErr: true},
+		{providerID: "aws:///us-east-1a/i-1234567890abcdef0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.providerID, func(t *testing.T) {
+			got, err := parseDOProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDOTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		want  string
+	}{
+		{name: "simple", key: "env", value: "prod", want: "env:prod"},
+		{name: "disallowed characters", key: "example.com/team", value: "a b", want: "example_com_team:a_b"},
+		{name: "non-ASCII characters", key: "env", value: "café", want: "env:caf_"},
+		{name: "prefixed key", key: "k8s:env", value: "prod", want: "k8s:env:prod"},
+		{name: "empty value", key: "env", value: "", want: "env:"},
+		{name: "exceeding maximum length", key: "env", value: strings.Repeat("v", 300), want: "env:" + strings.Repeat("v", maxDOTagLength-4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, doTag(tt.key, tt.value))
+		})
+	}
+}
+
+func TestDOTagKey(t *testing.T) {
+	keys := []string{"env", "k8s:env", "example.com/team"}
+
+	for tag, want := range map[string]string{
+		"env:prod":             "env",
+		"k8s:env:prod":         "k8s:env",
+		"example_com_team:a_b": "example_com_team",
+		"env:":                 "env",
+	} {
+		got, ok := doTagKey(tag, keys)
+		assert.True(t, ok, tag)
+		assert.Equal(t, want, got, tag)
+	}
+
+	for _, tag := range []string{"env", "environment:prod", "production"} {
+		_, ok := doTagKey(tag, keys)
+		assert.False(t, ok, tag)
+	}
+}
+
+func TestDOAPIClient(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+		var body map[string]any
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		encoded, _ := json.Marshal(body)
+		requests = append(requests, req.Method+" "+req.URL.EscapedPath()+" "+string(encoded))
+
+		switch {
+		case req.URL.Path == "/v2/droplets/123":
+			_, _ = w.Write([]byte(`{"droplet": {"id": 123, "tags": ["env:prod", "k8s"]}}`))
+		case req.URL.Path == "/v2/droplets/429":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"id": "too_many_requests", "message": "API rate limit exceeded"}`))
+		case req.URL.Path == "/v2/tags":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"tag": {"name": "env:staging"}}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := newDOAPIClient(srv.Client(), "token", godo.SetBaseURL(srv.URL))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tags, err := c.GetDropletTags(ctx, "123")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env:prod", "k8s"}, tags)
+
+	require.NoError(t, c.TagDroplet(ctx, "123", "env:staging"))
+	require.NoError(t, c.UntagDroplet(ctx, "123", "env:prod"))
+	assert.Equal(t, []string{
+		"GET /v2/droplets/123 null",
+		`POST /v2/tags {"name":"env:staging"}`,
+		`POST /v2/tags/env:staging/resources {"resources":[{"resource_id":"123","resource_type":"droplet"}]}`,
+		`DELETE /v2/tags/env:prod/resources {"resources":[{"resource_id":"123","resource_type":"droplet"}]}`,
+	}, requests)
+
+	_, err = c.GetDropletTags(ctx, "429")
+	assert.ErrorContains(t, err, "API rate limit exceeded")
+	assert.True(t, isRetryableDOError(err))
+	assert.False(t, isRetryableDOError(errors.New("connection refused")))
+}
+
+func TestReconcileDO(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "example.com/team": "a"}, "digitalocean://123456789")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the value of env changed, zone is no longer set and the unmanaged tags are left alone
+	mock := &mockDOClient{tags: []string{"k8s", "env:staging", "zone:nyc1", "owner:ops"}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env", "example.com/team", "zone"}, Cloud: "do", DOClient: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env:prod", "example_com_team:a"}, mock.added)
+	slices.Sort(mock.removed)
+	assert.Equal(t, []string{"env:staging", "zone:nyc1"}, mock.removed)
+
+	// up to date
+	mock.tags = []string{"k8s", "env:prod", "example_com_team:a", "owner:ops"}
+	mock.added, mock.removed = nil, nil
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Nil(t, mock.added)
+	assert.Nil(t, mock.removed)
+}
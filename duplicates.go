@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The behaviors of OnDuplicateProviderID for nodes whose provider ID references the same
+// instance as another node's.
+const (
+	// duplicateProviderIDNewest only reconciles the most recently seen node
+	duplicateProviderIDNewest = "newest"
+	// duplicateProviderIDSkip reconciles none of the nodes
+	duplicateProviderIDSkip = "skip"
+	// duplicateProviderIDError fails the reconciles of all the nodes
+	duplicateProviderIDError = "error"
+)
+
+// providerIDIndex indexes the cached nodes by the instance key of their provider ID, including
+// the GCP instance override annotations.
+const providerIDIndex = "spec.providerID"
+
+// indexProviderID returns the providerIDIndex values of obj.
+func (r *NodeLabelController) indexProviderID(obj client.Object) []string {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	if providerID := r.providerID(node); providerID != "" {
+		return []string{instanceKey(providerID)}
+	}
+	return nil
+}
+
+// duplicateNodes returns the other nodes whose provider ID references the same instance as
+// providerID, sorted by name. Without providerIDIndex, eg: with --reconcile-nodes, all nodes are
+// listed and filtered.
+func (r *NodeLabelController) duplicateNodes(ctx context.Context, name, providerID string) ([]*corev1.Node, error) {
+	instance := instanceKey(providerID)
+	var opts []client.ListOption
+	if r.providerIDIndexed {
+		opts = append(opts, client.MatchingFields{providerIDIndex: instance})
+	}
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes, opts...); err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	var others []*corev1.Node
+	for i := range nodes.Items {
+		if node := &nodes.Items[i]; node.Name != name && slices.Contains(r.indexProviderID(node), instance) {
+			others = append(others, node)
+		}
+	}
+	slices.SortFunc(others, func(a, b *corev1.Node) int { return strings.Compare(a.Name, b.Name) })
+	return others, nil
+}
+
+// skipDuplicateProviderID returns whether the reconcile of node should be skipped because its
+// provider ID is shared with other existing nodes, according to OnDuplicateProviderID.
+func (r *NodeLabelController) skipDuplicateProviderID(ctx context.Context, node *corev1.Node, providerID string) (bool, error) {
+	logger := ctrl.LoggerFrom(ctx)
+
+	others, err := r.duplicateNodes(ctx, node.Name, providerID)
+	if err != nil {
+		return false, err
+	}
+	if len(others) == 0 {
+		return false, nil
+	}
+
+	names := make([]string, 0, len(others))
+	for _, other := range others {
+		names = append(names, other.Name)
+	}
+	logger.Info("Provider ID is shared with other nodes", "providerID", providerID, "otherNodes", names, "onDuplicateProviderID", r.OnDuplicateProviderID)
+
+	switch r.OnDuplicateProviderID {
+	case duplicateProviderIDSkip:
+		return true, nil
+	case duplicateProviderIDError:
+		return false, fmt.Errorf("provider ID %q is shared with nodes %v", providerID, names)
+	}
+	for _, other := range others {
+		if newerNode(other, node) {
+			logger.Info("Skipping node, a more recently seen node shares its provider ID", "providerID", providerID, "newerNode", other.Name)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newerNode reports whether node a was seen more recently than b: its kubelet's last heartbeat
+// or, without one, its creation is later. Ties go to the first name in order, so exactly one of
+// several nodes is the newest.
+func newerNode(a, b *corev1.Node) bool {
+	seenA, seenB := nodeLastSeen(a), nodeLastSeen(b)
+	if !seenA.Equal(seenB) {
+		return seenA.After(seenB)
+	}
+	return a.Name < b.Name
+}
+
+// nodeLastSeen returns the latest heartbeat of node's conditions, or its creation time.
+func nodeLastSeen(node *corev1.Node) time.Time {
+	seen := node.CreationTimestamp.Time
+	for _, c := range node.Status.Conditions {
+		if c.LastHeartbeatTime.After(seen) {
+			seen = c.LastHeartbeatTime.Time
+		}
+	}
+	return seen
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func withHeartbeat(node *corev1.Node, t time.Time) *corev1.Node {
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:              corev1.NodeReady,
+		Status:            corev1.ConditionTrue,
+		LastHeartbeatTime: metav1.NewTime(t),
+	}}
+	return node
+}
+
+func TestNewerNode(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	old := withHeartbeat(createNode("a", nil, ""), now.Add(-time.Hour))
+	recent := withHeartbeat(createNode("b", nil, ""), now)
+	assert.True(t, newerNode(recent, old))
+	assert.False(t, newerNode(old, recent))
+
+	// without heartbeats the creation time is used
+	created := createNode("c", nil, "")
+	created.CreationTimestamp = metav1.NewTime(now.Add(time.Minute))
+	assert.True(t, newerNode(created, recent))
+
+	// ties go to the first name
+	a, b := createNode("a", nil, ""), createNode("b", nil, "")
+	assert.True(t, newerNode(a, b))
+	assert.False(t, newerNode(b, a))
+}
+
+func TestReconcileDuplicateProviderID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	const providerID = "aws:///us-east-1a/i-1234567890abcdef0"
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		mode        string
+		wantTagged  map[string]bool
+		wantErr     string
+		otherNodeID string
+	}{
+		{name: "default", mode: "", wantTagged: map[string]bool{"node-b": true}},
+		{name: "newest", mode: duplicateProviderIDNewest, wantTagged: map[string]bool{"node-b": true}},
+		{name: "skip", mode: duplicateProviderIDSkip, wantTagged: map[string]bool{}},
+		{name: "error", mode: duplicateProviderIDError, wantTagged: map[string]bool{}, wantErr: "is shared with nodes"},
+		{
+			name:        "provider ID changed since",
+			mode:        duplicateProviderIDSkip,
+			otherNodeID: "aws:///us-east-1a/i-0fedcba0987654321",
+			wantTagged:  map[string]bool{"node-b": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otherNodeID := providerID
+			if tt.otherNodeID != "" {
+				otherNodeID = tt.otherNodeID
+			}
+			nodes := []*corev1.Node{
+				withHeartbeat(createNode("node-a", map[string]string{"env": "old"}, otherNodeID), now.Add(-time.Hour)),
+				withHeartbeat(createNode("node-b", map[string]string{"env": "new"}, providerID), now),
+			}
+			// node-a sorts first but its heartbeat is older
+			r := &NodeLabelController{Labels: []string{"env"}, Cloud: "aws", OnDuplicateProviderID: tt.mode, providerIDIndexed: true}
+			r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes[0], nodes[1]).
+				WithIndex(&corev1.Node{}, providerIDIndex, r.indexProviderID).Build()
+
+			for _, node := range nodes {
+				if node.Spec.ProviderID != providerID {
+					continue
+				}
+				mock := &mockEC2Client{}
+				r.EC2Client = mock
+
+				_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+				if tt.wantErr != "" {
+					assert.ErrorContains(t, err, tt.wantErr)
+				} else {
+					require.NoError(t, err)
+				}
+				if tt.wantTagged[node.Name] {
+					assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String(node.Labels["env"])}}, mock.createdTags, node.Name)
+				} else {
+					assert.Nil(t, mock.createdTags, node.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDuplicateProviderIDDeletedNode(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &mockEC2Client{}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, OnDuplicateProviderID: duplicateProviderIDError}
+	// a deleted node that referenced the same instance is only remembered, not listed
+	r.providerIDs.Store("deleted-node", "aws:///i-1234567890abcdef0")
+	others, err := r.duplicateNodes(context.Background(), node.Name, node.Spec.ProviderID)
+	require.NoError(t, err)
+	assert.Empty(t, others)
+
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+}
+
+func TestDuplicateNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	nodes := []client.Object{
+		createNode("node-a", nil, "aws:///us-east-1a/i-1234567890abcdef0"),
+		createNode("node-b", nil, "aws:///i-1234567890abcdef0"),
+		createNode("node-c", nil, "aws:///us-east-1a/i-0fedcba0987654321"),
+		createNode("node-d", nil, ""),
+	}
+
+	for _, indexed := range []bool{true, false} {
+		r := &NodeLabelController{Cloud: "aws", providerIDIndexed: indexed}
+		builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes...)
+		if indexed {
+			builder = builder.WithIndex(&corev1.Node{}, providerIDIndex, r.indexProviderID)
+		}
+		r.Client = builder.Build()
+
+		// nodes are found before they're reconciled, eg: right after a restart
+		others, err := r.duplicateNodes(context.Background(), "node-a", "aws:///us-east-1a/i-1234567890abcdef0")
+		require.NoError(t, err)
+		var names []string
+		for _, other := range others {
+			names = append(names, other.Name)
+		}
+		assert.Equal(t, []string{"node-b"}, names, "indexed: %v", indexed)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The reasons of the events recorded on nodes.
+const (
+	// eventReasonTagsSynced reports tags changed on a node's instance
+	eventReasonTagsSynced = "TagsSynced"
+	// eventReasonTagSyncFailed reports a failed sync of a node's tags
+	eventReasonTagSyncFailed = "TagSyncFailed"
+)
+
+// tagChanges collects the keys of the tags changed by a reconcile, to report them in an event.
+type tagChanges struct {
+	mu      sync.Mutex
+	set     []string
+	deleted []string
+}
+
+type tagChangesKey struct{}
+
+// withTagChanges returns a context collecting the tag changes made with it.
+func withTagChanges(ctx context.Context) (context.Context, *tagChanges) {
+	c := &tagChanges{}
+	return context.WithValue(ctx, tagChangesKey{}, c), c
+}
+
+// add records the keys of tags created or updated, and deleted.
+func (c *tagChanges) add(set, deleted []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range set {
+		if !slices.Contains(c.set, k) {
+			c.set = append(c.set, k)
+		}
+	}
+	for _, k := range deleted {
+		if !slices.Contains(c.deleted, k) {
+			c.deleted = append(c.deleted, k)
+		}
+	}
+}
+
+// message describes the changes, empty when there were none.
+func (c *tagChanges) message() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var parts []string
+	if len(c.set) > 0 {
+		parts = append(parts, "set "+strings.Join(slices.Sorted(slices.Values(c.set)), ", "))
+	}
+	if len(c.deleted) > 0 {
+		parts = append(parts, "deleted "+strings.Join(slices.Sorted(slices.Values(c.deleted)), ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// tagsChanged records tags created or updated, and deleted, on a resource of cloud: in the
+// metrics, the summary and the tag changes of ctx.
+func (r *NodeLabelController) tagsChanged(ctx context.Context, cloud string, set, deleted []string) {
+	if len(set) > 0 {
+		tagsCreated.WithLabelValues(cloud).Add(float64(len(set)))
+	}
+	if len(deleted) > 0 {
+		tagsDeleted.WithLabelValues(cloud).Add(float64(len(deleted)))
+	}
+	r.summary.tagsChanged(cloud, len(set), len(deleted))
+	if c, ok := ctx.Value(tagChangesKey{}).(*tagChanges); ok {
+		c.add(set, deleted)
+	}
+}
+
+// recordSynced records an event on node listing the tags changed on its instance, if any.
+func (r *NodeLabelController) recordSynced(node *corev1.Node, cloud string, changes *tagChanges) {
+	if r.Recorder == nil {
+		return
+	}
+	if msg := changes.message(); msg != "" {
+		r.Recorder.Eventf(node, corev1.EventTypeNormal, eventReasonTagsSynced, "Synced tags to the %s instance: %s", cloud, msg)
+	}
+}
+
+// recordSyncFailed records a warning event on node with the error of its failed sync.
+func (r *NodeLabelController) recordSyncFailed(node *corev1.Node, err error) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(node, corev1.EventTypeWarning, eventReasonTagSyncFailed, fmt.Sprintf("Failed to sync tags: %v", err))
+}
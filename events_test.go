@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	t.Run("tags changed", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "zone": "a"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("zone"), Value: aws.String("a")},
+			{Key: aws.String("team"), Value: aws.String("a")},
+		}}
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team", "zone"}, Cloud: "aws", EC2Client: mock, Recorder: recorder}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, recorder.Events, 1)
+		assert.Equal(t, "Normal TagsSynced Synced tags to the aws instance: set env; deleted team", <-recorder.Events)
+	})
+
+	t.Run("tags up to date", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{currentTags: []types.TagDescription{{Key: aws.String("env"), Value: aws.String("prod")}}}
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, Recorder: recorder}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Empty(t, recorder.Events)
+	})
+
+	t.Run("sync failed", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}, setLabelsErr: &googleapi.Error{Code: http.StatusForbidden, Message: "Required 'compute.instances.setLabels' permission"}}
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, Recorder: recorder}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.Error(t, err)
+		require.Len(t, recorder.Events, 1)
+		event := <-recorder.Events
+		assert.Contains(t, event, "Warning TagSyncFailed Failed to sync tags: failed to update GCP instance labels")
+		assert.Contains(t, event, "compute.instances.setLabels")
+	})
+}
@@ -2,14 +2,80 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+	htransport "google.golang.org/api/transport/http"
 )
 
+const (
+	// gcpOperationTimeout bounds the wait for the completion of GCE operations
+	gcpOperationTimeout = 2 * time.Minute
+
+	// defaultGCPRetryBaseDelay is the backoff of the first retry of a rate limited GCE API call.
+	// It doubles with every retry, up to maxGCPRetryDelay. GCE rate limits are enforced per
+	// minute, so the backoff starts higher than AWS'.
+	defaultGCPRetryBaseDelay = time.Second
+	maxGCPRetryDelay         = 30 * time.Second
+
+	// gcpImpersonationScope is the scope of the credentials calling the IAM Credentials API to
+	// impersonate a service account
+	gcpImpersonationScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// gcpZonePattern matches the names of GCP zones, a region followed by a letter, eg: us-central1-a.
+// gcpRegionPattern matches those of regions, eg: us-central1.
+var (
+	gcpZonePattern   = regexp.MustCompile(`^[a-z]+(-[a-z]+)+[0-9]+-[a-z][a-z0-9]*$`)
+	gcpRegionPattern = regexp.MustCompile(`^[a-z]+(-[a-z]+)+[0-9]+$`)
+)
+
+// gcpRateLimitReasons are the reasons of the 403 errors GCE returns for exceeded rate limits and
+// quotas, eg: of concurrent SetLabels calls
+var gcpRateLimitReasons = []string{"rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded"}
+
+// isRetryableGCPError returns whether err is a GCE API error worth retrying: rate limit, quota
+// and server errors.
+func isRetryableGCPError(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusTooManyRequests || gerr.Code >= http.StatusInternalServerError {
+		return true
+	}
+	return gerr.Code == http.StatusForbidden && slices.ContainsFunc(gerr.Errors, func(e googleapi.ErrorItem) bool {
+		return slices.Contains(gcpRateLimitReasons, e.Reason)
+	})
+}
+
+// isNotFoundGCPError returns whether err is a GCE API error of a missing resource, eg: a deleted
+// instance.
+func isNotFoundGCPError(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
 // minimal interface we need for interacting with the GCP GCE API:
 type gceClient interface {
 	GetInstance(ctx context.Context, project, zone, instance string) (*gce.Instance, error)
 	SetLabels(ctx context.Context, project, zone, instance string, req *gce.InstancesSetLabelsRequest) error
+	GetDisk(ctx context.Context, project, zone, disk string) (*gce.Disk, error)
+	SetDiskLabels(ctx context.Context, project, zone, disk string, req *gce.ZoneSetLabelsRequest) error
 }
 
 var _ gceClient = (*gceComputeClient)(nil)
@@ -27,7 +93,146 @@ func (c *gceComputeClient) GetInstance(ctx context.Context, project, zone, insta
 	return c.Instances.Get(project, zone, instance).Context(ctx).Do()
 }
 
+// SetLabels sets the labels of the instance and waits up to gcpOperationTimeout for the operation
+// to complete, so the next reconcile reads the updated labels.
 func (c *gceComputeClient) SetLabels(ctx context.Context, project, zone, instance string, req *gce.InstancesSetLabelsRequest) error {
-	_, err := c.Instances.SetLabels(project, zone, instance, req).Context(ctx).Do()
-	return err
+	op, err := c.Instances.SetLabels(project, zone, instance, req).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return c.waitZoneOperation(ctx, project, zone, op)
+}
+
+func (c *gceComputeClient) GetDisk(ctx context.Context, project, zone, disk string) (*gce.Disk, error) {
+	return c.Disks.Get(project, zone, disk).Context(ctx).Do()
+}
+
+// SetDiskLabels sets the labels of the disk and waits up to gcpOperationTimeout for the operation
+// to complete.
+func (c *gceComputeClient) SetDiskLabels(ctx context.Context, project, zone, disk string, req *gce.ZoneSetLabelsRequest) error {
+	op, err := c.Disks.SetLabels(project, zone, disk, req).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return c.waitZoneOperation(ctx, project, zone, op)
+}
+
+// waitZoneOperation waits up to gcpOperationTimeout for the zonal operation op to complete and
+// returns its errors.
+func (c *gceComputeClient) waitZoneOperation(ctx context.Context, project, zone string, op *gce.Operation) error {
+	ctx, cancel := context.WithTimeout(ctx, gcpOperationTimeout)
+	defer cancel()
+
+	// Wait returns once the operation is DONE or after a server-side deadline, whichever is first
+	for op.Status != "DONE" {
+		var err error
+		op, err = c.ZoneOperations.Wait(project, zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to wait for operation: %v", err)
+		}
+	}
+	return operationError(op)
+}
+
+// operationError returns the errors of a completed operation, if any.
+func operationError(op *gce.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(op.Error.Errors))
+	for _, e := range op.Error.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+	}
+	return fmt.Errorf("operation %s failed: %s", op.Name, strings.Join(msgs, "; "))
+}
+
+// validateGCPZone returns an error when zone isn't the name of a GCP zone, eg: the region of a
+// regional resource or a project ID when the segments of a provider ID are out of order.
+func validateGCPZone(zone string) error {
+	switch {
+	case gcpZonePattern.MatchString(zone):
+		return nil
+	case gcpRegionPattern.MatchString(zone):
+		return fmt.Errorf("%q is a region, not a zone: instances are zonal, eg: %s-a", zone, zone)
+	}
+	return fmt.Errorf("%q is not a zone, expected <region>-<letter>, eg: us-central1-a", zone)
+}
+
+// regionFromGCPZone returns the region of a GCP zone, eg: us-central1 for us-central1-a.
+func regionFromGCPZone(zone string) (string, error) {
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 || i == len(zone)-1 || !strings.Contains(zone[:i], "-") {
+		return "", fmt.Errorf("unable to derive region from zone %q", zone)
+	}
+	return zone[:i], nil
+}
+
+// parseGCEDiskSource parses the project, zone and name of a zonal disk from the source URL of an
+// attached disk, eg: https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/disks/disk-1
+func parseGCEDiskSource(source string) (string, string, string, error) {
+	parts := strings.Split(source, "/")
+	for i := 0; i+5 < len(parts); i++ {
+		if parts[i] == "projects" && parts[i+2] == "zones" && parts[i+4] == "disks" {
+			return parts[i+1], parts[i+3], parts[i+5], nil
+		}
+	}
+	return "", "", "", fmt.Errorf("not a zonal disk: %q", source)
+}
+
+// newGCPHTTPClient wraps base with GCP authentication, of the compute scope unless opts set
+// other scopes. option.WithHTTPClient bypasses the client library's credential handling, so the
+// transport has to be authenticated before it's passed in.
+func newGCPHTTPClient(ctx context.Context, base *http.Client, opts ...option.ClientOption) (*http.Client, error) {
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	trans, err := htransport.NewTransport(ctx, rt, slices.Concat([]option.ClientOption{option.WithScopes(gce.ComputeScope)}, opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: trans,
+		Timeout:   base.Timeout,
+	}, nil
+}
+
+// newGCPCredentials returns the credentials of opts, or else the default credentials, of the
+// compute scope. A non-nil base is the HTTP client of the token requests.
+func newGCPCredentials(ctx context.Context, base *http.Client, opts ...option.ClientOption) (*google.Credentials, error) {
+	if base != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, base)
+	}
+	return transport.Creds(ctx, slices.Concat([]option.ClientOption{option.WithScopes(gce.ComputeScope)}, opts)...)
+}
+
+// newGCPImpersonationTokenSource returns a token source of the compute scope for the service
+// account target, impersonated with the credentials of opts, or else the default credentials.
+// A non-nil base is the HTTP client of the IAM Credentials API calls.
+func newGCPImpersonationTokenSource(ctx context.Context, base *http.Client, target string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	if base != nil {
+		hc, err := newGCPHTTPClient(ctx, base, append(slices.Clone(opts), option.WithScopes(gcpImpersonationScope))...)
+		if err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithHTTPClient(hc)}
+	}
+	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: target,
+		Scopes:          []string{gce.ComputeScope},
+	}, opts...)
+}
+
+// validateGCPLabelKey returns why a sanitized key can't be a GCP label key, or nil: keys have to
+// start with a lowercase letter or an international character.
+func validateGCPLabelKey(key string) error {
+	if key == "" {
+		return errors.New("the key is empty")
+	}
+	if r, _ := utf8.DecodeRuneInString(key); !unicode.In(r, unicode.Ll, unicode.Lo) {
+		return errors.New("the key doesn't start with a lowercase letter")
+	}
+	return nil
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// newTestGCEComputeClient returns a gceComputeClient of a fake compute API which answers the
+// setLabels call with a RUNNING operation, then the wait calls with ops in turn.
+func newTestGCEComputeClient(t *testing.T, ops []*gce.Operation) (*gceComputeClient, *atomic.Int32) {
+	t.Helper()
+
+	var waits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var op *gce.Operation
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/projects/my-project/zones/us-central1-a/instances/instance-1/setLabels"):
+			op = &gce.Operation{Name: "operation-1", Status: "RUNNING"}
+		case strings.HasSuffix(req.URL.Path, "/projects/my-project/zones/us-central1-a/operations/operation-1/wait"):
+			i := int(waits.Add(1)) - 1
+			if i >= len(ops) {
+				http.Error(w, "unexpected wait", http.StatusInternalServerError)
+				return
+			}
+			op = ops[i]
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(op)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := gce.NewService(context.Background(), option.WithEndpoint(srv.URL+"/compute/v1/"), option.WithHTTPClient(srv.Client()))
+	require.NoError(t, err)
+	return newGCEComputeClient(svc), &waits
+}
+
+func TestGCEComputeClientSetLabels(t *testing.T) {
+	req := &gce.InstancesSetLabelsRequest{Labels: map[string]string{"env": "prod"}, LabelFingerprint: "abc"}
+
+	tests := []struct {
+		name      string
+		ops       []*gce.Operation
+		wantWaits int32
+		wantErr   string
+	}{
+		{
+			name: "running then done",
+			ops: []*gce.Operation{
+				{Name: "operation-1", Status: "RUNNING"},
+				{Name: "operation-1", Status: "DONE"},
+			},
+			wantWaits: 2,
+		},
+		{
+			name: "operation error",
+			ops: []*gce.Operation{
+				{Name: "operation-1", Status: "DONE", Error: &gce.OperationError{Errors: []*gce.OperationErrorErrors{
+					{Code: "CONDITION_NOT_MET", Message: "Labels fingerprint either invalid or resource labels have changed"},
+				}}},
+			},
+			wantWaits: 1,
+			wantErr:   "operation operation-1 failed: CONDITION_NOT_MET: Labels fingerprint either invalid or resource labels have changed",
+		},
+		{
+			name:      "wait fails",
+			wantWaits: 1,
+			wantErr:   "unable to wait for operation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, waits := newTestGCEComputeClient(t, tt.ops)
+
+			err := c.SetLabels(context.Background(), "my-project", "us-central1-a", "instance-1", req)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantWaits, waits.Load())
+		})
+	}
+}
+
+func TestSyncGCPLabelsRetries(t *testing.T) {
+	quotaErr := &googleapi.Error{Code: http.StatusForbidden, Message: "Quota exceeded", Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}
+	const providerID = "gce://my-project/us-central1-a/instance-1"
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		errs       []error
+		wantErr    bool
+		wantCalls  int
+	}{
+		{name: "quota error then success", maxRetries: 3, errs: []error{quotaErr}, wantCalls: 2},
+		{name: "server error then success", maxRetries: 3, errs: []error{&googleapi.Error{Code: http.StatusServiceUnavailable}}, wantCalls: 2},
+		{name: "retries used up", maxRetries: 2, errs: []error{quotaErr, quotaErr, quotaErr}, wantErr: true, wantCalls: 3},
+		{name: "no retries", errs: []error{quotaErr}, wantErr: true, wantCalls: 1},
+		{name: "not retryable", maxRetries: 3, errs: []error{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}}, wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}, setLabelsErrs: tt.errs}
+			r := &NodeLabelController{Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, GCPMaxRetries: tt.maxRetries, gcpRetryBaseDelay: time.Millisecond}
+
+			err := r.syncGCPLabels(context.Background(), providerID, map[string]string{"env": "prod"}, false)
+			assert.Equal(t, tt.wantCalls, mock.setLabelsCalls)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, tt.errs[len(tt.errs)-1]))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+		})
+	}
+}
+
+func TestParseGCEDiskSource(t *testing.T) {
+	project, zone, disk, err := parseGCEDiskSource("https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/disks/disk-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"my-project", "us-central1-a", "disk-1"}, []string{project, zone, disk})
+
+	_, _, _, err = parseGCEDiskSource("https://www.googleapis.com/compute/v1/projects/my-project/regions/us-central1/disks/disk-1")
+	assert.Error(t, err)
+}
+
+func TestValidateGCPLabelKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr string
+	}{
+		{key: "team"},
+		{key: "équipe"},
+		{key: "k8s_team"},
+		{key: "", wantErr: "the key is empty"},
+		{key: "1team", wantErr: "the key doesn't start with a lowercase letter"},
+		{key: "_team", wantErr: "the key doesn't start with a lowercase letter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := validateGCPLabelKey(tt.key)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGCPZone(t *testing.T) {
+	tests := []struct {
+		zone    string
+		wantErr string
+	}{
+		{zone: "us-central1-a"},
+		{zone: "europe-west4-b"},
+		{zone: "northamerica-northeast1-c"},
+		{zone: "us-central1", wantErr: `"us-central1" is a region, not a zone: instances are zonal, eg: us-central1-a`},
+		{zone: "my-project", wantErr: `"my-project" is not a zone, expected <region>-<letter>, eg: us-central1-a`},
+		{zone: "us-central1-", wantErr: `"us-central1-" is not a zone, expected <region>-<letter>, eg: us-central1-a`},
+		{zone: "zone", wantErr: `"zone" is not a zone, expected <region>-<letter>, eg: us-central1-a`},
+		{zone: "", wantErr: `"" is not a zone, expected <region>-<letter>, eg: us-central1-a`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			err := validateGCPZone(tt.zone)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// handlerTransport is an http.RoundTripper answering requests to any host with a handler.
+type handlerTransport struct {
+	http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestSetupGCPImpersonation(t *testing.T) {
+	var requests []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Host+req.URL.Path+" "+req.Header.Get("Authorization"))
+		if req.Host == "iamcredentials.googleapis.com" {
+			var body struct {
+				Scope []string `json:"scope"`
+			}
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			assert.Equal(t, []string{gce.ComputeScope}, body.Scope)
+			_ = json.NewEncoder(w).Encode(map[string]string{"accessToken": "impersonated", "expireTime": time.Now().Add(time.Hour).Format(time.RFC3339)})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&gce.Instance{Name: "instance-1"})
+	})
+
+	// the service account is impersonated with the controller's credentials, here a static token
+	r := &NodeLabelController{
+		Cloud:                        "gcp",
+		HTTPClient:                   &http.Client{Transport: handlerTransport{handler}},
+		GCPClientOptions:             []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "controller"}))},
+		GCPImpersonateServiceAccount: "tagger@my-project.iam.gserviceaccount.com",
+	}
+	require.NoError(t, r.SetupCloudProvider(context.Background()))
+
+	_, err := r.GCEClient.GetInstance(context.Background(), "my-project", "us-central1-a", "instance-1")
+	require.NoError(t, err)
+	assert.NoError(t, r.CredentialsCheck(httptest.NewRequest("GET", "/readyz", nil)))
+	assert.Equal(t, []string{
+		"iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/tagger@my-project.iam.gserviceaccount.com:generateAccessToken Bearer controller",
+		"compute.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instances/instance-1 Bearer impersonated",
+	}, requests)
+}
+
+type errTokenSource struct{ err error }
+
+func (ts errTokenSource) Token() (*oauth2.Token, error) {
+	return nil, ts.err
+}
+
+func TestSetupGCPCredentialsProbe(t *testing.T) {
+	// the probe uses the credentials of the GCE client rather than the default credentials
+	r := &NodeLabelController{
+		Cloud:            "gcp",
+		GCPClientOptions: []option.ClientOption{option.WithTokenSource(errTokenSource{errors.New("token revoked")})},
+	}
+	require.NoError(t, r.SetupCloudProvider(context.Background()))
+	assert.ErrorContains(t, r.CredentialsCheck(httptest.NewRequest("GET", "/readyz", nil)), "token revoked")
+
+	_, err := r.GCEClient.GetInstance(context.Background(), "my-project", "us-central1-a", "instance-1")
+	assert.ErrorContains(t, err, "token revoked")
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// keyedMutex serializes work on the same key while letting different keys proceed
+// concurrently. The zero value is ready to use. Entries are removed once no goroutine
+// holds or waits on them, so the map only grows with the number of in-flight keys.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock acquires the lock for key and returns a function that releases it.
+func (k *keyedMutex) Lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedMutexEntry)
+	}
+	e, ok := k.locks[key]
+	if !ok {
+		e = &keyedMutexEntry{}
+		k.locks[key] = e
+	}
+	e.refs++
+	k.mu.Unlock()
+
+	e.Lock()
+	return func() {
+		e.Unlock()
+
+		k.mu.Lock()
+		e.refs--
+		if e.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var km keyedMutex
+
+	// a held key blocks only other lockers of the same key
+	unlockA := km.Lock("a")
+	unlockB := km.Lock("b")
+	unlockB()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := km.Lock("a")
+		close(locked)
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("second Lock(\"a\") acquired while the first was held")
+	default:
+	}
+
+	unlockA()
+	<-locked
+
+	// entries are cleaned up once released
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			km.Lock("c")()
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, km.locks)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultKeyAliases maps well-known Kubernetes node label keys to the short cloud tag keys used
+// when --alias-well-known-keys is set.
+var defaultKeyAliases = map[string]string{
+	"topology.kubernetes.io/region":            "region",
+	"topology.kubernetes.io/zone":              "zone",
+	"failure-domain.beta.kubernetes.io/region": "region",
+	"failure-domain.beta.kubernetes.io/zone":   "zone",
+	"node.kubernetes.io/instance-type":         "instance-type",
+	"beta.kubernetes.io/instance-type":         "instance-type",
+	"kubernetes.io/arch":                       "arch",
+	"kubernetes.io/os":                         "os",
+	"kubernetes.io/hostname":                   "hostname",
+}
+
+// tagKey returns the cloud tag key for a Kubernetes label key on nodes of cloud, with TagPrefix
+// prepended. Aliased keys are used as is, other keys have the configured prefixes and suffixes
+// stripped.
+func (r *NodeLabelController) tagKey(cloud, key string) string {
+	if alias, ok := r.CloudKeyAliases[cloud][key]; ok && alias != "" {
+		return r.TagPrefix + alias
+	}
+	if alias, ok := r.KeyAliases[key]; ok && alias != "" {
+		return r.TagPrefix + alias
+	}
+	if stripped := stripAffixes(key, r.StripKeyPrefixes, r.StripKeySuffixes); stripped != "" {
+		return r.TagPrefix + stripped
+	}
+	return r.TagPrefix + key
+}
+
+// regionTagKey returns the tag key of the region stamped with TagRegionFromProviderID: the tag key
+// of the topology.kubernetes.io/region label when it's synced, region otherwise.
+func (r *NodeLabelController) regionTagKey(cloud string) string {
+	if slices.Contains(r.labelsFor(cloud), corev1.LabelTopologyRegion) {
+		return r.tagKey(cloud, corev1.LabelTopologyRegion)
+	}
+	if slices.Contains(r.patternMatches(cloud, map[string]string{corev1.LabelTopologyRegion: ""}), corev1.LabelTopologyRegion) {
+		return r.TagPrefix + corev1.LabelTopologyRegion
+	}
+	return r.TagPrefix + "region"
+}
+
+// managedByTagKey returns the tag key of the ManagedByTag, with TagPrefix prepended.
+func (r *NodeLabelController) managedByTagKey() string {
+	return r.TagPrefix + r.ManagedByTag
+}
+
+// withManagedByKeys returns keys along with the keys listed by managedBy, the value of an
+// instance's managed-by tag, so tags written under a previous configuration are still managed.
+func withManagedByKeys(keys []string, managedBy string) []string {
+	for _, k := range parseManagedByValue(managedBy) {
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// isKeyPattern reports whether key is a glob pattern, eg: topology.kubernetes.io/*, rather than
+// a literal key.
+func isKeyPattern(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// matchingKeys returns the keys of ms matching the glob pattern, sorted. Patterns follow
+// path.Match, so '*' doesn't match a '/'.
+func matchingKeys(pattern string, ms ...map[string]string) []string {
+	var keys []string
+	for _, m := range ms {
+		for k := range m {
+			if ok, _ := path.Match(pattern, k); ok && !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// regexMatches returns the keys of ms matching LabelRegex, sorted.
+func (r *NodeLabelController) regexMatches(ms ...map[string]string) []string {
+	if r.LabelRegex == nil {
+		return nil
+	}
+	var keys []string
+	for _, m := range ms {
+		for k := range m {
+			if r.LabelRegex.MatchString(k) && !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// patternMatches returns the keys of nodeLabels matched by the label key patterns to sync for
+// nodes of cloud, or by LabelRegex, sorted. Keys also listed literally are left out.
+func (r *NodeLabelController) patternMatches(cloud string, nodeLabels map[string]string) []string {
+	keys := r.labelsFor(cloud)
+	matches := make(map[string]string)
+	for _, k := range r.regexMatches(nodeLabels) {
+		if !slices.Contains(keys, k) {
+			matches[k] = ""
+		}
+	}
+	for _, p := range keys {
+		if !isKeyPattern(p) {
+			continue
+		}
+		for _, k := range matchingKeys(p, nodeLabels) {
+			if !slices.Contains(keys, k) {
+				matches[k] = ""
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(matches))
+}
+
+// managedKeyPatterns returns the glob patterns of the cloud tag keys owned by the controller on
+// instances of cloud on top of managedKeys: its label key patterns with TagPrefix prepended. The
+// tags of matched labels are keyed by the label key, as aliases and stripped affixes can't be
+// reversed.
+func (r *NodeLabelController) managedKeyPatterns(cloud string) []string {
+	var patterns []string
+	for _, p := range r.labelsFor(cloud) {
+		if isKeyPattern(p) {
+			patterns = append(patterns, r.TagPrefix+p)
+		}
+	}
+	return patterns
+}
+
+// matchesKeyPattern reports whether the cloud tag key matches any of managedKeyPatterns, or is
+// the key of a label matching LabelRegex, so it's managed on instances of cloud.
+func (r *NodeLabelController) matchesKeyPattern(cloud, key string) bool {
+	if labelKey, ok := strings.CutPrefix(key, r.TagPrefix); ok && r.LabelRegex != nil && r.LabelRegex.MatchString(labelKey) {
+		return true
+	}
+	return slices.ContainsFunc(r.managedKeyPatterns(cloud), func(p string) bool {
+		ok, _ := path.Match(p, key)
+		return ok
+	})
+}
+
+// tagValue returns the cloud tag value for a Kubernetes label value.
+func (r *NodeLabelController) tagValue(value string) string {
+	return stripAffixes(value, r.StripValuePrefixes, r.StripValueSuffixes)
+}
+
+// stripAffixes removes the first matching prefix and the first matching suffix from s.
+func stripAffixes(s string, prefixes, suffixes []string) string {
+	for _, p := range prefixes {
+		if trimmed, ok := strings.CutPrefix(s, p); ok && p != "" {
+			s = trimmed
+			break
+		}
+	}
+	for _, suffix := range suffixes {
+		if trimmed, ok := strings.CutSuffix(s, suffix); ok && suffix != "" {
+			s = trimmed
+			break
+		}
+	}
+	return s
+}
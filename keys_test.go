@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripAffixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		prefixes []string
+		suffixes []string
+		want     string
+	}{
+		{name: "no affixes", input: "env-managed", want: "env-managed"},
+		{name: "suffix", input: "env-managed", suffixes: []string{"-managed"}, want: "env"},
+		{name: "prefix", input: "corp-env", prefixes: []string{"corp-"}, want: "env"},
+		{name: "prefix and suffix", input: "corp-env-managed", prefixes: []string{"corp-"}, suffixes: []string{"-managed"}, want: "env"},
+		{name: "first matching suffix only", input: "env-managed-auto", suffixes: []string{"-auto", "-managed-auto", "-managed"}, want: "env-managed"},
+		{name: "no match", input: "env", prefixes: []string{"corp-"}, suffixes: []string{"-managed"}, want: "env"},
+		{name: "empty affixes are ignored", input: "env", prefixes: []string{""}, suffixes: []string{""}, want: "env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, stripAffixes(tt.input, tt.prefixes, tt.suffixes))
+		})
+	}
+}
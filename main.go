@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -18,72 +21,243 @@ import (
 const leaderElectionId = "node-label-controller"
 
 func main() {
-	var probesAddr string
-	var metricsAddr string
-	var pprofAddr string
-	var enableLeaderElection bool
-	var labelsStr string
-	var cloudProvider string
-	var jsonLogs bool
+	// validate the configuration without connecting to Kubernetes or the cloud, eg: in CI
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:], os.Stderr))
+	}
 
 	logger := ctrl.Log.WithName("main")
 
-	flag.StringVar(&probesAddr, "probes-addr", ":8080", "The address the /readyz and /healthz probes endpoint binds to.")
-	flag.StringVar(&metricsAddr, "metrics-addr", ":8081", "The address the metric endpoint binds to.")
-	flag.StringVar(&pprofAddr, "pprof-addr", "", "The address the pprof server endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Enable leader election.")
-	flag.StringVar(&labelsStr, "labels", "", "Comma-separated list of label keys to sync")
-	flag.StringVar(&cloudProvider, "cloud", "", "Cloud provider (aws or gcp)")
-	flag.BoolVar(&jsonLogs, "json", false, "Output logs in JSON format")
-	flag.Parse()
+	o, configErr := parseOptions(flag.CommandLine, os.Args[1:])
 
 	// setup logger. Use development mode by default or json output if --json is set
 	var opts []zap.Opts
-	opts = append(opts, zap.UseDevMode(!jsonLogs))
-	if jsonLogs {
+	opts = append(opts, zap.UseDevMode(!o.jsonLogs))
+	if o.jsonLogs {
 		opts = append(opts, zap.JSONEncoder())
 	}
 	ctrl.SetLogger(zap.New(opts...))
 
 	// validate flags
-	if labelsStr == "" {
-		logger.Error(fmt.Errorf("label-keys is required"), "unable to start manager")
+	if configErr != nil {
+		logger.Error(configErr, "invalid config file or environment")
+		os.Exit(1)
+	}
+	if err := o.validate(); err != nil {
+		logger.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	labels, _, err := o.labels()
+	if err != nil {
+		logger.Error(err, "invalid labels")
+		os.Exit(1)
+	}
+	annotations, err := o.annotations()
+	if err != nil {
+		logger.Error(err, "invalid annotations")
+		os.Exit(1)
+	}
+	cloudLabels, cloudKeyAliases, err := o.cloudLabels()
+	if err != nil {
+		logger.Error(err, "invalid cloud labels")
+		os.Exit(1)
+	}
+	labelRegex, err := o.labelRegex()
+	if err != nil {
+		logger.Error(err, "invalid label-regex")
+		os.Exit(1)
+	}
+	logger.Info("Keys to sync", "labelKeys", labels, "cloudLabelKeys", cloudLabels, "labelRegex", o.labelRegexStr, "annotationKeys", annotations)
+	if o.dryRun {
+		logger.Info("Dry-run mode, tag changes will only be logged")
+	}
+	if o.resyncPeriod > 0 {
+		logger.Info("All nodes will be reconciled on every resync of the node cache", "resyncPeriod", o.resyncPeriod)
+	}
+
+	httpClient, err := newCloudHTTPClient(o.cloudHTTPTimeout, o.cloudHTTPProxy)
+	if err != nil {
+		logger.Error(err, "invalid cloud HTTP client settings")
 		os.Exit(1)
 	}
-	labels := strings.Split(labelsStr, ",")
-	logger.Info("Label keys to sync", "labelKeys", labels)
 
-	if cloudProvider != "aws" && cloudProvider != "gcp" {
-		logger.Error(fmt.Errorf("cloud-provider must be either 'aws' or 'gcp'"), "unable to start manager")
+	keyAliases, err := o.keyAliases()
+	if err != nil {
+		logger.Error(err, "invalid key-aliases")
 		os.Exit(1)
 	}
+	if len(keyAliases) > 0 {
+		logger.Info("Tag key aliases", "keyAliases", keyAliases)
+	}
 
-	// get a kubeconfig for the manager to use to access the k8s API:
+	nodeSelector, err := o.nodeSelector()
+	if err != nil {
+		logger.Error(err, "invalid node-selector")
+		os.Exit(1)
+	}
+	if nodeSelector != nil {
+		logger.Info("Only tagging nodes matching the node selector", "nodeSelector", nodeSelector.String())
+	}
+
+	staticTags, err := parseKeyValuePairs(o.staticTagsStr)
+	if err != nil {
+		logger.Error(err, "invalid static-tags")
+		os.Exit(1)
+	}
+	if len(staticTags) > 0 {
+		logger.Info("Static tags", "staticTags", staticTags)
+	}
+
+	tagTemplates, err := o.tagTemplates()
+	if err != nil {
+		logger.Error(err, "invalid tag-template")
+		os.Exit(1)
+	}
+	if len(tagTemplates) > 0 {
+		logger.Info("Tag templates", "tagTemplates", []string(o.tagTemplatesList), "tagTemplateMissing", o.tagTemplateMissing)
+	}
+
+	sink, err := newTagSink(o.sinkSpec)
+	if err != nil {
+		logger.Error(err, "invalid sink")
+		os.Exit(1)
+	}
+
+	// get a kubeconfig to access the k8s API:
 	cfg, err := ctrl.GetConfig()
 	if err != nil {
 		logger.Error(err, "unable to get kubeconfig")
 		os.Exit(1)
 	}
 
-	// configure the controller-runtime manager
 	scheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(scheme)
 
+	ctx := ctrl.SetupSignalHandler()
+
+	// setup our controller. Its clients are set once we know whether to start the manager
+	controller := &NodeLabelController{
+		Labels:          labels,
+		CloudLabels:     cloudLabels,
+		LabelRegex:      labelRegex,
+		Annotations:     annotations,
+		Cloud:           o.cloudProvider,
+		AutoClouds:      o.autoClouds,
+		KeyAliases:      keyAliases,
+		CloudKeyAliases: cloudKeyAliases,
+
+		StripKeyPrefixes:   splitList(o.stripKeyPrefix),
+		StripKeySuffixes:   splitList(o.stripKeySuffix),
+		StripValuePrefixes: splitList(o.stripValuePrefix),
+		StripValueSuffixes: splitList(o.stripValueSuffix),
+
+		TagPrefix:               o.tagPrefix,
+		StaticTags:              staticTags,
+		MissingValue:            o.missingValue,
+		DisabledAnnotation:      o.disabledAnnotation,
+		NodeSelector:            nodeSelector,
+		OnDuplicateProviderID:   o.onDuplicate,
+		ManagedByTag:            o.managedByTag,
+		NodeUIDTag:              o.nodeUIDTag,
+		NodeNameTag:             o.nodeNameTag,
+		TagTemplates:            tagTemplates,
+		TagTemplateMissing:      o.tagTemplateMissing,
+		TagRegionFromProviderID: o.tagRegion,
+		ClusterNameTag:          o.clusterNameTag,
+		ClusterName: &clusterNameResolver{
+			Name:  o.clusterName,
+			Label: o.clusterNameLabel,
+		},
+		DryRun:     o.dryRun,
+		SampleRate: o.sampleRate,
+		HTTPClient: httpClient,
+
+		TwoPhaseDeleteInterval:       o.twoPhaseDelete,
+		CredentialsRecheckInterval:   o.credentialsRecheck,
+		CloudRateLimiter:             newRateLimiter(o.cloudRateLimit),
+		ReconcileRateLimiter:         newRateLimiter(o.reconcileQPS),
+		MaxConcurrentReconciles:      o.maxConcurrent,
+		ResyncPeriod:                 o.resyncPeriod,
+		Sink:                         sink,
+		AZToRegion:                   azToRegionFuncs[o.azToRegionFunc],
+		AWSRegion:                    o.awsRegion,
+		AWSEndpointURL:               o.awsEndpointURL,
+		AWSTagApplyOrder:             splitList(o.tagApplyOrder),
+		AWSAssumeRoleARN:             o.awsAssumeRoleARN,
+		AWSExternalID:                o.awsExternalID,
+		AWSMaxRetries:                o.maxRetries,
+		GCPMaxRetries:                o.maxRetries,
+		AWSDescribeInstances:         o.describeInstances,
+		AWSTagEBSVolumes:             o.tagEBSVolumes,
+		AWSTagENIs:                   o.tagENIs,
+		GCPSkipNonRunning:            o.gcpSkipNonRunning,
+		GCPImpersonateServiceAccount: o.gcpImpersonateSA,
+		GCPLabelDisks:                o.gcpLabelDisks,
+		GCPOverwriteUnmanaged:        o.gcpOverwriteUnmanaged,
+		GCPProjectAnnotation:         o.gcpProjectAnnotation,
+		GCPZoneAnnotation:            o.gcpZoneAnnotation,
+		GCPInstanceAnnotation:        o.gcpInstanceAnnotation,
+		CleanupOnDelete:              o.cleanupOnDelete,
+		CleanupFinalizer:             o.cleanupFinalizer,
+
+		ConsolidateDuplicateKeys: o.consolidateDuplicates,
+		MissingProviderIDRequeue: o.missingIDRequeue,
+	}
+
+	// the cloud clients are only needed when tags are applied through the cloud APIs
+	if sink == nil {
+		if err := controller.SetupCloudProvider(ctx); err != nil {
+			logger.Error(err, "unable to setup cloud provider")
+			os.Exit(1)
+		}
+	}
+
+	// a one-shot reconcile of named nodes reads them directly from the API server, there is no
+	// need to start the manager and its caches
+	if nodeNames := splitList(o.reconcileNodesStr); len(nodeNames) > 0 {
+		c, err := client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			logger.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		controller.Client = c
+		controller.ClusterName.Reader = c
+
+		err = reconcileNodes(ctrl.LoggerInto(ctx, logger), controller, nodeNames)
+		controller.summary.log(logger)
+		if err := closeSink(sink); err != nil {
+			logger.Error(err, "unable to close sink")
+			os.Exit(1)
+		}
+		if err != nil {
+			logger.Error(err, "unable to reconcile nodes")
+			os.Exit(1)
+		}
+		return
+	}
+
+	// configure the controller-runtime manager
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                 scheme,
-		HealthProbeBindAddress: probesAddr,
+		HealthProbeBindAddress: o.probesAddr,
 		Metrics: metricsserver.Options{
-			BindAddress: metricsAddr,
+			BindAddress: o.metricsAddr,
 		},
-		PprofBindAddress: pprofAddr,
-		LeaderElection:   enableLeaderElection,
+		PprofBindAddress: o.pprofAddr,
+		LeaderElection:   o.enableLeaderElection,
 		LeaderElectionID: leaderElectionId,
+		Cache:            cacheOptions(o.resyncPeriod),
 	})
 	if err != nil {
 		logger.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	controller.Client = mgr.GetClient()
+	controller.Recorder = mgr.GetEventRecorderFor("k8s-node-tagger")
+	controller.ClusterName.Reader = mgr.GetAPIReader()
+
 	// setup /healthz and /readyz checks on the manager
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		logger.Error(err, "unable to set up health check")
@@ -93,29 +267,136 @@ func main() {
 		logger.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
-
-	ctx := ctrl.SetupSignalHandler()
-
-	// setup our controller and start it
-	controller := &NodeLabelController{
-		Client: mgr.GetClient(),
-		Labels: labels,
-		Cloud:  cloudProvider,
+	if err := mgr.AddReadyzCheck("cloud-provider", controller.ReadyzCheck); err != nil {
+		logger.Error(err, "unable to set up ready check")
+		os.Exit(1)
 	}
-
-	if err := controller.SetupCloudProvider(ctx); err != nil {
-		logger.Error(err, "unable to setup cloud provider")
+	if err := mgr.AddReadyzCheck("cloud-credentials", controller.CredentialsCheck); err != nil {
+		logger.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
 
+	if o.preloadCloudState && sink == nil {
+		if err := mgr.Add(controller.preloadRunnable(mgr.GetAPIReader(), o.preloadConcurrency)); err != nil {
+			logger.Error(err, "unable to set up preload of cloud state")
+			os.Exit(1)
+		}
+	}
+
+	// start our controller
 	if err = controller.SetupWithManager(mgr); err != nil {
 		logger.Error(err, "unable to create controller")
 		os.Exit(1)
 	}
 
+	if o.sweepInterval > 0 {
+		err := mgr.Add(&sweeper{
+			r:           controller,
+			reader:      mgr.GetClient(),
+			interval:    o.sweepInterval,
+			concurrency: o.sweepConcurrency,
+			limiter:     newRateLimiter(o.sweepRate),
+		})
+		if err != nil {
+			logger.Error(err, "unable to set up sweep")
+			os.Exit(1)
+		}
+	}
+
 	logger.Info("starting")
-	if err := mgr.Start(ctx); err != nil {
+	err = mgr.Start(ctx)
+	// the manager returns once the signal handler cancelled ctx and the controllers stopped
+	controller.summary.log(logger)
+	if err := closeSink(sink); err != nil {
+		logger.Error(err, "unable to close sink")
+		os.Exit(1)
+	}
+	if err != nil {
 		logger.Error(err, "problem starting manager")
 		os.Exit(1)
 	}
 }
+
+// cacheOptions returns the options of the manager's cache with the given resync period, 0 for
+// controller-runtime's default.
+func cacheOptions(resyncPeriod time.Duration) cache.Options {
+	if resyncPeriod <= 0 {
+		return cache.Options{}
+	}
+	return cache.Options{SyncPeriod: &resyncPeriod}
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseLabelKeys parses a comma-separated list of label keys, each optionally followed by the
+// tag key to write it as, eg: "env,topology.kubernetes.io/region=Region". It returns the label
+// keys and the tag keys of the labels that have one.
+func parseLabelKeys(s string) ([]string, map[string]string, error) {
+	var keys []string
+	tagKeys := make(map[string]string)
+	for _, item := range splitList(s) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			keys = append(keys, item)
+			continue
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "" || v == "" {
+			return nil, nil, fmt.Errorf("invalid labelKey=tagKey pair %q", item)
+		}
+		keys = append(keys, k)
+		tagKeys[k] = v
+	}
+	return keys, tagKeys, nil
+}
+
+// parseAnnotationTags parses a comma-separated list of annotationKey:tagKey pairs, eg:
+// "finops.example.com/cost-center:CostCenter". The annotationKey=tagKey form of YAML maps in the
+// config file is accepted too. It returns the annotation keys in order, and the tag key of each.
+func parseAnnotationTags(s string) ([]string, map[string]string, error) {
+	var keys []string
+	tagKeys := make(map[string]string)
+	for _, item := range splitList(s) {
+		// annotation keys can contain neither ':' nor '=', the first one is the separator
+		i := strings.IndexAny(item, ":=")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("invalid annotationKey:tagKey pair %q", item)
+		}
+		k, v := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if k == "" || v == "" {
+			return nil, nil, fmt.Errorf("invalid annotationKey:tagKey pair %q", item)
+		}
+		if _, ok := tagKeys[k]; !ok {
+			keys = append(keys, k)
+		}
+		tagKeys[k] = v
+	}
+	return keys, tagKeys, nil
+}
+
+// parseKeyValuePairs parses a comma-separated list of key=value pairs, eg: "a=b,c=d".
+func parseKeyValuePairs(s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s == "" {
+		return pairs, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid key=value pair %q", pair)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
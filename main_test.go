@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyValuePairs(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "multiple pairs",
+			input: "topology.kubernetes.io/region=region, kubernetes.io/arch=arch",
+			want: map[string]string{
+				"topology.kubernetes.io/region": "region",
+				"kubernetes.io/arch":            "arch",
+			},
+		},
+		{
+			name:    "missing value",
+			input:   "topology.kubernetes.io/region",
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			input:   "=region",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyValuePairs(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseAnnotationTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantKeys    []string
+		wantTagKeys map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "empty",
+			input:       "",
+			wantTagKeys: map[string]string{},
+		},
+		{
+			name:     "multiple pairs",
+			input:    "finops.example.com/cost-center:CostCenter, example.com/owner=Owner",
+			wantKeys: []string{"finops.example.com/cost-center", "example.com/owner"},
+			wantTagKeys: map[string]string{
+				"finops.example.com/cost-center": "CostCenter",
+				"example.com/owner":              "Owner",
+			},
+		},
+		{
+			name:        "tag key with a colon",
+			input:       "example.com/owner:team:owner",
+			wantKeys:    []string{"example.com/owner"},
+			wantTagKeys: map[string]string{"example.com/owner": "team:owner"},
+		},
+		{
+			name:    "missing tag key",
+			input:   "example.com/owner",
+			wantErr: true,
+		},
+		{
+			name:    "empty annotation key",
+			input:   ":Owner",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, tagKeys, err := parseAnnotationTags(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKeys, keys)
+			assert.Equal(t, tt.wantTagKeys, tagKeys)
+		})
+	}
+}
+
+func TestCacheOptions(t *testing.T) {
+	assert.Nil(t, cacheOptions(0).SyncPeriod, "controller-runtime's default period is kept")
+
+	period := cacheOptions(30 * time.Minute).SyncPeriod
+	require.NotNil(t, period)
+	assert.Equal(t, 30*time.Minute, *period)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// maxErrorNodes caps the number of nodes exported by node_tagger_node_last_error to bound
+	// the metric's cardinality during cluster-wide failures.
+	maxErrorNodes = 100
+
+	// maxErrorLabelLength truncates the error label of node_tagger_node_last_error
+	maxErrorLabelLength = 128
+)
+
+var nodeLastError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_tagger_node_last_error",
+	Help: "Set to 1 with the error message when the node's last reconcile failed. Removed once the node reconciles successfully.",
+}, []string{"node", "error"})
+
+var configInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_tagger_config_info",
+	Help: "Always 1, labelled with the controller's configuration.",
+}, []string{"cloud", "dry_run", "monitored_labels", "monitored_annotations"})
+
+var leader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "node_tagger_leader",
+	Help: "Set to 1 while this replica holds leadership, 0 otherwise.",
+})
+
+var unmanagedCollisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_tagger_unmanaged_collisions_total",
+	Help: "Number of desired managed keys found as an existing tag or label the controller didn't write, counted once per key and instance.",
+}, []string{"cloud"})
+
+var tagsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_tagger_tags_created_total",
+	Help: "Number of cloud tags created or updated.",
+}, []string{"cloud"})
+
+var tagsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_tagger_tags_deleted_total",
+	Help: "Number of cloud tags deleted.",
+}, []string{"cloud"})
+
+var syncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_tagger_sync_errors_total",
+	Help: "Number of failed tag syncs of a node.",
+}, []string{"cloud"})
+
+var syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "node_tagger_sync_duration_seconds",
+	Help:    "Duration of the tag syncs of a node, including the cloud API calls.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"cloud"})
+
+var sanitizedKeys = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "node_tagger_sanitized_keys_total",
+	Help: "Number of GCP label keys changed to fit GCP's label key constraints.",
+})
+
+var truncatedValues = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "node_tagger_truncated_values_total",
+	Help: "Number of GCP label values truncated to fit GCP's 63 characters limit.",
+})
+
+var sanitizedValues = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "node_tagger_sanitized_values_total",
+	Help: "Number of GCP label values changed, eg: lowercased, rewritten or truncated, to fit GCP's label value constraints.",
+})
+
+func init() {
+	// register with controller-runtime's registry so our metrics are served on --metrics-addr
+	metrics.Registry.MustRegister(nodeLastError, configInfo, leader, unmanagedCollisions, tagsCreated, tagsDeleted, syncErrors, syncDuration, sanitizedKeys, sanitizedValues, truncatedValues)
+}
+
+// exportConfigInfo sets node_tagger_config_info from the controller's configuration, a series per
+// cloud tagged, eg: each cloud of --cloud=auto. dry_run is "true" when no tags are written and
+// "partial" when only a sample of the nodes' tags are written.
+func exportConfigInfo(r *NodeLabelController) {
+	dryRun := "false"
+	switch {
+	case r.DryRun:
+		dryRun = "true"
+	case r.SampleRate > 0 && r.SampleRate < 1:
+		dryRun = "partial"
+	}
+
+	configInfo.Reset()
+	for _, cloud := range r.clouds() {
+		configInfo.WithLabelValues(
+			cloud,
+			dryRun,
+			strconv.Itoa(len(r.labelsFor(cloud))),
+			strconv.Itoa(len(r.Annotations)),
+		).Set(1)
+	}
+}
+
+// leaderRunnable maintains node_tagger_leader. As a runnable that needs leader election, the
+// manager only starts it once this replica is elected and cancels it when leadership is lost.
+type leaderRunnable struct{}
+
+func (leaderRunnable) NeedLeaderElection() bool {
+	return true
+}
+
+func (leaderRunnable) Start(ctx context.Context) error {
+	leader.Set(1)
+	<-ctx.Done()
+	leader.Set(0)
+	return nil
+}
+
+// nodeErrorTracker maintains node_tagger_node_last_error. It remembers the error label exported
+// for each node so the series can be removed on success or replaced on a new error.
+type nodeErrorTracker struct {
+	mu     sync.Mutex
+	errors map[string]string // node -> error label
+}
+
+// set records err as the node's last reconcile error. Nodes beyond maxErrorNodes are not exported.
+func (t *nodeErrorTracker) set(node string, err error) {
+	msg := err.Error()
+	if len(msg) > maxErrorLabelLength {
+		msg = msg[:maxErrorLabelLength]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.errors == nil {
+		t.errors = make(map[string]string)
+	}
+	if prev, ok := t.errors[node]; ok {
+		nodeLastError.DeleteLabelValues(node, prev)
+	} else if len(t.errors) >= maxErrorNodes {
+		return
+	}
+
+	t.errors[node] = msg
+	nodeLastError.WithLabelValues(node, msg).Set(1)
+}
+
+// clear removes the node's last reconcile error.
+func (t *nodeErrorTracker) clear(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, ok := t.errors[node]; ok {
+		nodeLastError.DeleteLabelValues(node, prev)
+		delete(t.errors, node)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func TestExportConfigInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller *NodeLabelController
+		wantSeries [][]string
+	}{
+		{
+			name: "all nodes written",
+			controller: &NodeLabelController{
+				Cloud:       "aws",
+				Labels:      []string{"env", "team"},
+				Annotations: []string{"example.com/cost-center"},
+				SampleRate:  1,
+			},
+			wantSeries: [][]string{{"aws", "false", "2", "1"}},
+		},
+		{
+			name: "sampled nodes",
+			controller: &NodeLabelController{
+				Cloud:      "gcp",
+				Labels:     []string{"env"},
+				SampleRate: 0.5,
+			},
+			wantSeries: [][]string{{"gcp", "partial", "1", "0"}},
+		},
+		{
+			name: "dry run",
+			controller: &NodeLabelController{
+				Cloud:      "aws",
+				Labels:     []string{"env"},
+				DryRun:     true,
+				SampleRate: 0.5,
+			},
+			wantSeries: [][]string{{"aws", "true", "1", "0"}},
+		},
+		{
+			name: "auto",
+			controller: &NodeLabelController{
+				Cloud:       "auto",
+				AutoClouds:  []string{"aws", "gcp"},
+				Labels:      []string{"env"},
+				CloudLabels: map[string][]string{"gcp": {"env", "zone"}},
+			},
+			wantSeries: [][]string{{"aws", "false", "1", "0"}, {"gcp", "false", "2", "0"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exportConfigInfo(tt.controller)
+
+			// the previous configuration is replaced
+			assert.Equal(t, len(tt.wantSeries), testutil.CollectAndCount(configInfo))
+			for _, labels := range tt.wantSeries {
+				assert.Equal(t, 1.0, testutil.ToFloat64(configInfo.WithLabelValues(labels...)))
+			}
+		})
+	}
+}
+
+func TestLeaderRunnable(t *testing.T) {
+	var r manager.LeaderElectionRunnable = leaderRunnable{}
+	assert.True(t, r.NeedLeaderElection())
+
+	leader.Set(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- leaderRunnable{}.Start(ctx)
+	}()
+
+	// elected
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(leader) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// leadership lost
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, 0.0, testutil.ToFloat64(leader))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+const (
+	// maxOCITagKeyLength and maxOCITagValueLength are the OCI freeform tag key and value limits
+	maxOCITagKeyLength   = 100
+	maxOCITagValueLength = 256
+)
+
+// minimal interface we need for managing the freeform tags of OCI instances
+type ociClient interface {
+	GetInstanceTags(ctx context.Context, instanceID string) (map[string]string, string, error)
+	UpdateInstanceTags(ctx context.Context, instanceID string, tags map[string]string, etag string) error
+}
+
+var _ ociClient = (*ociAPIClient)(nil)
+
+// OCI client implementation using the OCI SDK
+type ociAPIClient struct {
+	compute  core.ComputeClient
+	identity identity.IdentityClient
+	tenancy  string
+}
+
+// loadOCIConfigProvider returns the OCI credentials as the OCI CLI does: instance or resource
+// principals when OCI_CLI_AUTH is instance_principal or resource_principal, or else the API
+// signing key of the OCI_CLI_PROFILE profile, DEFAULT by default, of the OCI_CONFIG_FILE config
+// file or ~/.oci/config.
+func loadOCIConfigProvider() (common.ConfigurationProvider, error) {
+	switch mode := os.Getenv("OCI_CLI_AUTH"); mode {
+	case "instance_principal":
+		return auth.InstancePrincipalConfigurationProvider()
+	case "resource_principal":
+		return auth.ResourcePrincipalConfigurationProvider()
+	case "", "api_key":
+	default:
+		return nil, fmt.Errorf("unsupported OCI_CLI_AUTH %q, expected api_key, instance_principal or resource_principal", mode)
+	}
+
+	profile := os.Getenv("OCI_CLI_PROFILE")
+	if profile == "" {
+		profile = "DEFAULT"
+	}
+	provider := common.CustomProfileConfigProvider(os.Getenv("OCI_CONFIG_FILE"), profile)
+	if ok, err := common.IsConfigurationProviderValid(provider); !ok {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// newOCIAPIClient returns a client of the OCI APIs of the region of provider, authenticated with
+// its credentials. A nil httpClient means the SDK's default client.
+func newOCIAPIClient(httpClient *http.Client, provider common.ConfigurationProvider) (*ociAPIClient, error) {
+	computeClient, err := core.NewComputeClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	identityClient, err := identity.NewIdentityClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	tenancy, err := provider.TenancyOCID()
+	if err != nil {
+		return nil, err
+	}
+	if httpClient != nil {
+		computeClient.HTTPClient = httpClient
+		identityClient.HTTPClient = httpClient
+	}
+	return &ociAPIClient{compute: computeClient, identity: identityClient, tenancy: tenancy}, nil
+}
+
+// isRetryableOCIError returns whether err is an OCI API error worth retrying later: throttling
+// and server errors.
+func isRetryableOCIError(err error) bool {
+	var oerr common.ServiceError
+	if !errors.As(err, &oerr) {
+		return false
+	}
+	return oerr.GetHTTPStatusCode() == http.StatusTooManyRequests || oerr.GetHTTPStatusCode() >= http.StatusInternalServerError
+}
+
+// isNotFoundOCIError returns whether err is an OCI API error of a missing resource, eg: a deleted
+// instance.
+func isNotFoundOCIError(err error) bool {
+	var oerr common.ServiceError
+	return errors.As(err, &oerr) && oerr.GetHTTPStatusCode() == http.StatusNotFound
+}
+
+// GetInstanceTags returns the freeform tags of the instance, and the ETag of the instance to
+// update them with.
+func (c *ociAPIClient) GetInstanceTags(ctx context.Context, instanceID string) (map[string]string, string, error) {
+	resp, err := c.compute.GetInstance(ctx, core.GetInstanceRequest{InstanceId: common.String(instanceID)})
+	if err != nil {
+		return nil, "", err
+	}
+	var etag string
+	if resp.Etag != nil {
+		etag = *resp.Etag
+	}
+	return resp.FreeformTags, etag, nil
+}
+
+// UpdateInstanceTags replaces the freeform tags of the instance, failing when it changed since
+// its etag was read.
+func (c *ociAPIClient) UpdateInstanceTags(ctx context.Context, instanceID string, tags map[string]string, etag string) error {
+	req := core.UpdateInstanceRequest{
+		InstanceId:            common.String(instanceID),
+		UpdateInstanceDetails: core.UpdateInstanceDetails{FreeformTags: tags},
+	}
+	if etag != "" {
+		req.IfMatch = common.String(etag)
+	}
+	_, err := c.compute.UpdateInstance(ctx, req)
+	return err
+}
+
+// checkTenancy fetches the tenancy of the credentials, eg: to verify them.
+func (c *ociAPIClient) checkTenancy(ctx context.Context) error {
+	_, err := c.identity.GetTenancy(ctx, identity.GetTenancyRequest{TenancyId: common.String(c.tenancy)})
+	return err
+}
+
+// parseOCIProviderID returns the instance OCID of an OCI provider ID, either the OCID itself or
+// prefixed by oci://, eg: oci://ocid1.instance.oc1.iad.<unique ID>.
+func parseOCIProviderID(providerID string) (string, error) {
+	id := strings.TrimPrefix(providerID, "oci://")
+	parts := strings.Split(id, ".")
+	if len(parts) < 5 || parts[0] != "ocid1" || parts[1] != "instance" || parts[len(parts)-1] == "" {
+		return "", fmt.Errorf("invalid OCI provider ID format: %q", providerID)
+	}
+	return id, nil
+}
+
+// isOCIProviderID returns whether providerID references an OCI instance.
+func isOCIProviderID(providerID string) bool {
+	return strings.HasPrefix(providerID, "oci://") || strings.HasPrefix(providerID, "ocid1.instance.")
+}
+
+// sanitizeTagsForOCI sanitizes the keys and values of tags for OCI.
+func sanitizeTagsForOCI(tags map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(tags))
+	for k, v := range tags {
+		sanitized[sanitizeKeyForOCI(k)] = sanitizeValueForOCI(v)
+	}
+	return sanitized
+}
+
+// sanitizeKeyForOCI replaces the periods and spaces OCI doesn't allow in freeform tag keys with
+// underscores and truncates the key to maxOCITagKeyLength.
+func sanitizeKeyForOCI(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '.' || unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, key)
+	if runes := []rune(key); len(runes) > maxOCITagKeyLength {
+		key = string(runes[:maxOCITagKeyLength])
+	}
+	return key
+}
+
+// sanitizeValueForOCI truncates the value to maxOCITagValueLength.
+func sanitizeValueForOCI(value string) string {
+	if runes := []rune(value); len(runes) > maxOCITagValueLength {
+		value = string(runes[:maxOCITagValueLength])
+	}
+	return value
+}
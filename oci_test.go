@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type mockOCIClient struct {
+	tags        map[string]string
+	updatedTags map[string]string
+	etag        string
+}
+
+func (m *mockOCIClient) GetInstanceTags(ctx context.Context, instanceID string) (map[string]string, string, error) {
+	return maps.Clone(m.tags), "etag-1", nil
+}
+
+func (m *mockOCIClient) UpdateInstanceTags(ctx context.Context, instanceID string, tags map[string]string, etag string) error {
+	m.updatedTags = tags
+	m.etag = etag
+	return nil
+}
+
+func TestParseOCIProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{providerID: "oci://ocid1.instance.oc1.iad.anuwcljrexample", want: "ocid1.instance.oc1.iad.anuwcljrexample"},
+		{providerID: "ocid1.instance.oc1.phx.anyhqljrexample", want: "ocid1.instance.oc1.phx.anyhqljrexample"},
+		{providerID: "oci://ocid1.instance.oc1..", wantErr: true},
+		{providerID: "oci://ocid1.volume.oc1.iad.abc", wantErr: true},
+		{providerID: "aws:///us-east-1a/i-1234567890abcdef0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.providerID, func(t *testing.T) {
+			got, err := parseOCIProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	cloud, err := detectCloudFromProviderID("ocid1.instance.oc1.phx.anyhqljrexample")
+	require.NoError(t, err)
+	assert.Equal(t, "oci", cloud)
+	assert.Equal(t, "oci/ocid1.instance.oc1.iad.abc", instanceKey("oci://ocid1.instance.oc1.iad.abc"))
+}
+
+func TestSanitizeTagsForOCI(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"example_com/team":                      "a b",
+		"my_key":                                "prod",
+		strings.Repeat("k", maxOCITagKeyLength): strings.Repeat("v", maxOCITagValueLength),
+	}, sanitizeTagsForOCI(map[string]string{
+		"example.com/team":       "a b",
+		"my key":                 "prod",
+		strings.Repeat("k", 150): strings.Repeat("v", 300),
+	}))
+}
+
+func TestLoadOCIConfigProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	path := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(path, []byte(`[DEFAULT]
+user=ocid1.user.oc1..default
+fingerprint=aa:bb
+key_file=`+keyFile+`
+tenancy=ocid1.tenancy.oc1..example
+region=us-ashburn-1
+
+# the profile of the node tagger
+[TAGGER]
+user = ocid1.user.oc1..tagger
+fingerprint = cc:dd
+key_file = `+keyFile+`
+tenancy = ocid1.tenancy.oc1..example
+region = us-phoenix-1
+`), 0o600))
+	t.Setenv("OCI_CONFIG_FILE", path)
+
+	t.Setenv("OCI_CLI_PROFILE", "TAGGER")
+	provider, err := loadOCIConfigProvider()
+	require.NoError(t, err)
+	user, err := provider.UserOCID()
+	require.NoError(t, err)
+	assert.Equal(t, "ocid1.user.oc1..tagger", user)
+	region, err := provider.Region()
+	require.NoError(t, err)
+	assert.Equal(t, "us-phoenix-1", region)
+
+	t.Setenv("OCI_CLI_PROFILE", "")
+	provider, err = loadOCIConfigProvider()
+	require.NoError(t, err)
+	user, err = provider.UserOCID()
+	require.NoError(t, err)
+	assert.Equal(t, "ocid1.user.oc1..default", user)
+
+	t.Setenv("OCI_CLI_AUTH", "security_token")
+	_, err = loadOCIConfigProvider()
+	assert.EqualError(t, err, `unsupported OCI_CLI_AUTH "security_token", expected api_key, instance_principal or resource_principal`)
+}
+
+func TestOCIAPIClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	provider := common.NewRawConfigurationProvider("ocid1.tenancy.oc1..tenancy", "ocid1.user.oc1..user", "us-ashburn-1", "aa:bb",
+		string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil)
+
+	rt := &recordingTransport{body: `{"id": "ocid1.instance.oc1.iad.abc", "freeformTags": {"env": "prod"}}`}
+	c, err := newOCIAPIClient(&http.Client{Transport: rt}, provider)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tags, _, err := c.GetInstanceTags(ctx, "ocid1.instance.oc1.iad.abc")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, tags)
+	require.NoError(t, c.UpdateInstanceTags(ctx, "ocid1.instance.oc1.iad.abc", map[string]string{"env": "staging"}, "etag-1"))
+
+	require.Len(t, rt.requests, 2)
+	assert.Equal(t, "https://iaas.us-ashburn-1.oraclecloud.com/20160918/instances/ocid1.instance.oc1.iad.abc", rt.requests[0].URL.String())
+	put := rt.requests[1]
+	assert.Equal(t, http.MethodPut, put.Method)
+	assert.Equal(t, "etag-1", put.Header.Get("If-Match"))
+	assert.JSONEq(t, `{"freeformTags":{"env":"staging"}}`, rt.bodies[1])
+	assert.Contains(t, put.Header.Get("Authorization"), `keyId="ocid1.tenancy.oc1..tenancy/ocid1.user.oc1..user/aa:bb"`)
+}
+
+func TestOCIErrors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	provider := common.NewRawConfigurationProvider("ocid1.tenancy.oc1..tenancy", "ocid1.user.oc1..user", "us-ashburn-1", "aa:bb",
+		string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil)
+
+	status := http.StatusTooManyRequests
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"code": "Error", "message": "error"}`))
+	})
+	c, err := newOCIAPIClient(&http.Client{Transport: handlerTransport{handler}}, provider)
+	require.NoError(t, err)
+
+	_, _, err = c.GetInstanceTags(context.Background(), "ocid1.instance.oc1.iad.abc")
+	assert.True(t, isRetryableOCIError(err))
+	assert.False(t, isNotFoundOCIError(err))
+
+	status = http.StatusNotFound
+	_, _, err = c.GetInstanceTags(context.Background(), "ocid1.instance.oc1.iad.abc")
+	assert.False(t, isRetryableOCIError(err))
+	assert.True(t, isNotFoundOCIError(fmt.Errorf("unable to get instance: %w", err)))
+	assert.False(t, isRetryableOCIError(errors.New("connection refused")))
+}
+
+func TestReconcileOCI(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "example.com/team": "a"}, "oci://ocid1.instance.oc1.iad.abc")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the value of env changed, zone is no longer set and the unmanaged tags are kept
+	mock := &mockOCIClient{tags: map[string]string{"env": "staging", "zone": "ad-1", "owner": "ops"}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env", "example.com/team", "zone"}, Cloud: "oci", OCIClient: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "example_com/team": "a", "owner": "ops"}, mock.updatedTags)
+	assert.Equal(t, "etag-1", mock.etag)
+
+	// up to date
+	mock.tags, mock.updatedTags = mock.updatedTags, nil
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Nil(t, mock.updatedTags)
+}
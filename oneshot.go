@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxOneShotRequeues caps the requeues of a node in a one-shot reconcile, eg: of a node
+// throttled by the cloud provider, before it's reported as failed.
+const maxOneShotRequeues = 5
+
+// reconcileNodes reconciles the named nodes once, for --reconcile-nodes. Nodes that don't exist
+// are logged and skipped. Nodes whose reconcile asks to be requeued, eg: after a retryable cloud
+// API error, are reconciled again after the requested delay, up to maxOneShotRequeues times. The
+// errors of all nodes that failed to reconcile are returned.
+func reconcileNodes(ctx context.Context, r *NodeLabelController, names []string) error {
+	logger := ctrl.LoggerFrom(ctx)
+
+	var errs []error
+	for _, name := range names {
+		key := client.ObjectKey{Name: name}
+
+		var node corev1.Node
+		if err := r.Get(ctx, key, &node); err != nil {
+			if apierrors.IsNotFound(err) {
+				logger.Info("Node not found, skipping", "node", name)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("unable to fetch node %s: %v", name, err))
+			continue
+		}
+
+		if err := reconcileNode(ctx, r, key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconcile node %s: %v", name, err))
+			continue
+		}
+		logger.Info("Reconciled node", "node", name)
+	}
+	return errors.Join(errs...)
+}
+
+// reconcileNode reconciles the node of key until it no longer asks to be requeued.
+func reconcileNode(ctx context.Context, r *NodeLabelController, key client.ObjectKey) error {
+	for requeues := 0; ; requeues++ {
+		res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil {
+			return err
+		}
+		if res.RequeueAfter <= 0 && !res.Requeue {
+			return nil
+		}
+		if requeues >= maxOneShotRequeues {
+			return fmt.Errorf("still pending after %d requeues", requeues)
+		}
+
+		ctrl.LoggerFrom(ctx).Info("Requeueing node", "node", key.Name, "requeueAfter", res.RequeueAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(res.RequeueAfter):
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// instanceRecordingEC2Client is a mockEC2Client that records the instances tags were created for
+type instanceRecordingEC2Client struct {
+	mockEC2Client
+	tagged []string
+}
+
+func (m *instanceRecordingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.tagged = append(m.tagged, params.Resources...)
+	return m.mockEC2Client.CreateTags(ctx, params, optFns...)
+}
+
+func TestReconcileNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-node1"),
+		createNode("node2", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-node2"),
+		createNode("node3", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-node3"),
+	).Build()
+
+	tests := []struct {
+		name          string
+		nodes         []string
+		describeErr   error
+		wantInstances []string
+		wantErr       bool
+	}{
+		{
+			name:          "named subset",
+			nodes:         []string{"node1", "node3"},
+			wantInstances: []string{"i-node1", "i-node3"},
+		},
+		{
+			name:          "nonexistent node is skipped",
+			nodes:         []string{"missing", "node2"},
+			wantInstances: []string{"i-node2"},
+		},
+		{
+			name:        "reconcile errors are returned",
+			nodes:       []string{"node1"},
+			describeErr: errors.New("throttled"),
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &instanceRecordingEC2Client{mockEC2Client: mockEC2Client{describeErr: tt.describeErr}}
+			r := &NodeLabelController{
+				Client:    k8s,
+				Labels:    []string{"env"},
+				Cloud:     "aws",
+				EC2Client: mock,
+			}
+
+			err := reconcileNodes(context.Background(), r, tt.nodes)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "node1")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantInstances, mock.tagged)
+			assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.createdTags)
+		})
+	}
+}
+
+func TestReconcileNodesRequeue(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	rateLimited := &googleapi.Error{Code: http.StatusTooManyRequests, Message: "Rate Limit Exceeded"}
+
+	newController := func(setLabelsErrs ...error) (*NodeLabelController, *mockGCEClient) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+		mock := &mockGCEClient{instance: &gce.Instance{Name: "instance-1"}, setLabelsErrs: setLabelsErrs}
+		return &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "gcp", GCEClient: mock, cloudRetryBaseDelay: time.Millisecond}, mock
+	}
+
+	t.Run("throttled node is reconciled again", func(t *testing.T) {
+		r, mock := newController(rateLimited, rateLimited)
+
+		require.NoError(t, reconcileNodes(context.Background(), r, []string{"node1"}))
+		assert.Equal(t, 3, mock.setLabelsCalls)
+		assert.Equal(t, map[string]string{"env": "prod"}, mock.labels)
+	})
+
+	t.Run("requeues are capped", func(t *testing.T) {
+		errs := make([]error, maxOneShotRequeues+1)
+		for i := range errs {
+			errs[i] = rateLimited
+		}
+		r, mock := newController(errs...)
+
+		assert.ErrorContains(t, reconcileNodes(context.Background(), r, []string{"node1"}), "still pending")
+		assert.Equal(t, maxOneShotRequeues+1, mock.setLabelsCalls)
+		assert.Nil(t, mock.labels)
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/config/clouds"
+)
+
+const (
+	// maxOpenStackMetadataLength is the maximum length of Nova metadata keys and values
+	maxOpenStackMetadataLength = 255
+)
+
+// openStackUUIDPattern matches the UUIDs of Nova instances
+var openStackUUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// minimal interface we need for managing the metadata of Nova instances
+type openstackClient interface {
+	GetServerMetadata(ctx context.Context, serverID string) (map[string]string, error)
+	UpdateServerMetadata(ctx context.Context, serverID string, metadata map[string]string) error
+	DeleteServerMetadata(ctx context.Context, serverID, key string) error
+}
+
+var _ openstackClient = (*openstackAPIClient)(nil)
+
+// OpenStack client implementation using Gophercloud
+type openstackAPIClient struct {
+	httpClient *http.Client
+	auth       *openstackAuth
+	mu         sync.Mutex
+	provider   *gophercloud.ProviderClient
+	compute    *gophercloud.ServiceClient
+}
+
+// openstackAuth is the Keystone authentication of the client, and the selection of the compute
+// endpoint of the service catalog.
+type openstackAuth struct {
+	Options   gophercloud.AuthOptions
+	Endpoint  gophercloud.EndpointOpts
+	TLSConfig *tls.Config
+}
+
+// loadOpenStackAuth reads the Keystone authentication of the OS_CLOUD cloud of clouds.yaml, or
+// else of the OS_* environment variables of the OpenStack CLI, as Gophercloud does.
+func loadOpenStackAuth() (*openstackAuth, error) {
+	if os.Getenv("OS_CLOUD") != "" {
+		opts, endpoint, tlsConfig, err := clouds.Parse()
+		if err != nil {
+			return nil, err
+		}
+		opts.AllowReauth = true
+		return &openstackAuth{Options: opts, Endpoint: endpoint, TLSConfig: tlsConfig}, nil
+	}
+
+	opts, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	opts.AllowReauth = true
+	iface := os.Getenv("OS_INTERFACE")
+	if iface == "" {
+		iface = "public"
+	}
+	return &openstackAuth{
+		Options: opts,
+		Endpoint: gophercloud.EndpointOpts{
+			Region:       os.Getenv("OS_REGION_NAME"),
+			Availability: gophercloud.Availability(strings.TrimSuffix(iface, "URL")),
+		},
+	}, nil
+}
+
+// newOpenStackAPIClient returns a client of the Nova API authenticated with auth. A nil
+// httpClient means http.DefaultClient.
+func newOpenStackAPIClient(httpClient *http.Client, auth *openstackAuth) *openstackAPIClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &openstackAPIClient{httpClient: httpClient, auth: auth}
+}
+
+// isRetryableOpenStackError returns whether err is an OpenStack API error worth retrying later:
+// throttling and server errors.
+func isRetryableOpenStackError(err error) bool {
+	var oerr gophercloud.ErrUnexpectedResponseCode
+	if !errors.As(err, &oerr) {
+		return false
+	}
+	return oerr.Actual == http.StatusTooManyRequests || oerr.Actual >= http.StatusInternalServerError
+}
+
+// isNotFoundOpenStackError returns whether err is an OpenStack API error of a missing
+// resource, eg: a deleted instance.
+func isNotFoundOpenStackError(err error) bool {
+	return gophercloud.ResponseCodeIs(err, http.StatusNotFound)
+}
+
+func (c *openstackAPIClient) GetServerMetadata(ctx context.Context, serverID string) (map[string]string, error) {
+	compute, err := c.computeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return servers.Metadata(ctx, compute, serverID).Extract()
+}
+
+// UpdateServerMetadata creates or updates the metadata keys of the server, leaving its other
+// keys alone.
+func (c *openstackAPIClient) UpdateServerMetadata(ctx context.Context, serverID string, metadata map[string]string) error {
+	compute, err := c.computeClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = servers.UpdateMetadata(ctx, compute, serverID, servers.MetadataOpts(metadata)).Extract()
+	return err
+}
+
+func (c *openstackAPIClient) DeleteServerMetadata(ctx context.Context, serverID, key string) error {
+	compute, err := c.computeClient(ctx)
+	if err != nil {
+		return err
+	}
+	return servers.DeleteMetadatum(ctx, compute, serverID, key).ExtractErr()
+}
+
+// checkToken issues a token, eg: to verify the credentials.
+func (c *openstackAPIClient) checkToken(ctx context.Context) error {
+	if _, err := c.computeClient(ctx); err != nil {
+		return err
+	}
+	return c.provider.Reauthenticate(ctx, c.provider.Token())
+}
+
+// computeClient returns the client of the compute endpoint of the service catalog, issuing a
+// Keystone token on the first call. Gophercloud renews the token once it's rejected.
+func (c *openstackAPIClient) computeClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.compute != nil {
+		return c.compute, nil
+	}
+
+	provider, err := openstack.NewClient(c.auth.Options.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient = *c.httpClient
+	if t, ok := provider.HTTPClient.Transport.(*http.Transport); ok && c.auth.TLSConfig != nil {
+		t = t.Clone()
+		t.TLSClientConfig = c.auth.TLSConfig
+		provider.HTTPClient.Transport = t
+	}
+	if err := openstack.Authenticate(ctx, provider, c.auth.Options); err != nil {
+		return nil, fmt.Errorf("unable to issue Keystone token: %w", err)
+	}
+	compute, err := openstack.NewComputeV2(provider, c.auth.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	c.provider, c.compute = provider, compute
+	return compute, nil
+}
+
+// parseOpenStackProviderID returns the instance UUID of an OpenStack provider ID of the form
+// openstack:///<instance-uuid>, or openstack://<region>/<instance-uuid>.
+func parseOpenStackProviderID(providerID string) (string, error) {
+	rest, ok := strings.CutPrefix(providerID, "openstack://")
+	if !ok {
+		return "", fmt.Errorf("invalid OpenStack provider ID format: %q", providerID)
+	}
+	_, id, ok := strings.Cut(rest, "/")
+	if !ok || !openStackUUIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid OpenStack instance ID in provider ID %q", providerID)
+	}
+	return id, nil
+}
+
+// sanitizeMetadataForOpenStack sanitizes the keys and values of metadata for Nova.
+func sanitizeMetadataForOpenStack(metadata map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if runes := []rune(v); len(runes) > maxOpenStackMetadataLength {
+			v = string(runes[:maxOpenStackMetadataLength])
+		}
+		sanitized[sanitizeKeyForOpenStack(k)] = v
+	}
+	return sanitized
+}
+
+// sanitizeKeyForOpenStack replaces the characters Nova doesn't allow in metadata keys with
+// underscores and truncates the key to maxOpenStackMetadataLength. Letters, digits, spaces,
+// dashes, underscores, colons and periods are allowed.
+func sanitizeKeyForOpenStack(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || strings.ContainsRune(" -_:.", r) {
+			return r
+		}
+		return '_'
+	}, key)
+	if len(key) > maxOpenStackMetadataLength {
+		key = key[:maxOpenStackMetadataLength]
+	}
+	return key
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type mockOpenStackClient struct {
+	metadata    map[string]string
+	updated     map[string]string
+	deletedKeys []string
+}
+
+func (m *mockOpenStackClient) GetServerMetadata(ctx context.Context, serverID string) (map[string]string, error) {
+	return m.metadata, nil
+}
+
+func (m *mockOpenStackClient) UpdateServerMetadata(ctx context.Context, serverID string, metadata map[string]string) error {
+	m.updated = metadata
+	return nil
+}
+
+func (m *mockOpenStackClient) DeleteServerMetadata(ctx context.Context, serverID, key string) error {
+	m.deletedKeys = append(m.deletedKeys, key)
+	return nil
+}
+
+func TestParseOpenStackProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       string
+		wantErr    bool
+	}{
+		{providerID: "openstack:///2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e", want: "2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e"},
+		{providerID: "openstack://RegionOne/2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e", want: "2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e"},
+		{providerID: "openstack:///", wantErr: true},
+		{providerID: "openstack:///instance-1", wantErr: true},
+		{providerID: "openstack://2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e", wantErr: true},
+		{providerID: "openstack:///2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e/extra", wantErr: true},
+		{providerID: "aws:///us-east-1a/i-1234567890abcdef0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.providerID, func(t *testing.T) {
+			got, err := parseOpenStackProviderID(tt.providerID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "openstack/2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e", instanceKey("openstack://RegionOne/2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e"))
+}
+
+func TestSanitizeMetadataForOpenStack(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"example.com_team": "a/b",
+		"node-role_worker": "",
+		strings.Repeat("k", maxOpenStackMetadataLength): strings.Repeat("v", maxOpenStackMetadataLength),
+	}, sanitizeMetadataForOpenStack(map[string]string{
+		"example.com/team":       "a/b",
+		"node-role/worker":       "",
+		strings.Repeat("k", 300): strings.Repeat("v", 300),
+	}))
+}
+
+func TestLoadOpenStackAuth(t *testing.T) {
+	for _, k := range []string{"OS_CLOUD", "OS_AUTH_URL", "OS_APPLICATION_CREDENTIAL_ID", "OS_APPLICATION_CREDENTIAL_SECRET", "OS_PASSWORD", "OS_USERNAME", "OS_USERID",
+		"OS_DOMAIN_NAME", "OS_DOMAIN_ID", "OS_PROJECT_ID", "OS_PROJECT_NAME", "OS_REGION_NAME", "OS_INTERFACE"} {
+		t.Setenv(k, "")
+	}
+
+	_, err := loadOpenStackAuth()
+	assert.ErrorContains(t, err, "OS_AUTH_URL")
+
+	t.Setenv("OS_AUTH_URL", "https://keystone.example.com:5000/v3/")
+	t.Setenv("OS_USERNAME", "tagger")
+	t.Setenv("OS_PASSWORD", "secret")
+	t.Setenv("OS_PROJECT_NAME", "k8s")
+	t.Setenv("OS_REGION_NAME", "RegionOne")
+	_, err = loadOpenStackAuth()
+	assert.ErrorContains(t, err, "OS_PROJECT_ID")
+
+	t.Setenv("OS_DOMAIN_NAME", "Default")
+	auth, err := loadOpenStackAuth()
+	require.NoError(t, err)
+	assert.Equal(t, gophercloud.AuthOptions{
+		IdentityEndpoint: "https://keystone.example.com:5000/v3/",
+		Username:         "tagger",
+		Password:         "secret",
+		TenantName:       "k8s",
+		DomainName:       "Default",
+		AllowReauth:      true,
+	}, auth.Options)
+	assert.Equal(t, gophercloud.EndpointOpts{Region: "RegionOne", Availability: gophercloud.AvailabilityPublic}, auth.Endpoint)
+
+	t.Setenv("OS_INTERFACE", "internalURL")
+	auth, err = loadOpenStackAuth()
+	require.NoError(t, err)
+	assert.Equal(t, gophercloud.AvailabilityInternal, auth.Endpoint.Availability)
+
+	// the cloud of clouds.yaml takes precedence over the environment
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`clouds:
+  tagger:
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+      application_credential_id: app-id
+      application_credential_secret: app-secret
+    auth_type: v3applicationcredential
+    region_name: RegionTwo
+`), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+	t.Setenv("OS_CLOUD", "tagger")
+	t.Setenv("OS_REGION_NAME", "")
+	t.Setenv("OS_INTERFACE", "")
+	auth, err = loadOpenStackAuth()
+	require.NoError(t, err)
+	assert.Equal(t, "app-id", auth.Options.ApplicationCredentialID)
+	assert.Equal(t, "app-secret", auth.Options.ApplicationCredentialSecret)
+	assert.True(t, auth.Options.AllowReauth)
+	assert.Equal(t, "RegionTwo", auth.Endpoint.Region)
+}
+
+func TestOpenStackAPIClient(t *testing.T) {
+	var requests []string
+	tokens := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		encoded, _ := json.Marshal(body)
+		requests = append(requests, req.Method+" "+req.URL.EscapedPath()+" "+string(encoded))
+
+		if req.URL.Path == "/v3/auth/tokens" {
+			tokens++
+			w.Header().Set("X-Subject-Token", "token-"+strconv.Itoa(tokens))
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": map[string]any{
+				"expires_at": time.Now().Add(time.Hour),
+				"catalog": []any{
+					map[string]any{"type": "identity", "endpoints": []any{map[string]any{"interface": "public", "region": "RegionOne", "url": srv.URL + "/v3"}}},
+					map[string]any{"type": "compute", "endpoints": []any{
+						map[string]any{"interface": "internal", "region": "RegionOne", "url": srv.URL + "/internal"},
+						map[string]any{"interface": "public", "region": "RegionTwo", "url": srv.URL + "/two"},
+						map[string]any{"interface": "public", "region": "RegionOne", "url": srv.URL + "/compute/v2.1/"},
+					}},
+				},
+			}})
+			return
+		}
+
+		// the first token is rejected, eg: revoked
+		if req.Header.Get("X-Auth-Token") != "token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/metadata"):
+			_, _ = w.Write([]byte(`{"metadata": {"env": "prod"}}`))
+		case strings.Contains(req.URL.Path, "/servers/throttled/"):
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message": "rate limited"}`))
+		case req.Method == http.MethodPost:
+			_, _ = w.Write([]byte(`{"metadata": {"env": "staging"}}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	c := newOpenStackAPIClient(srv.Client(), &openstackAuth{
+		Options:  gophercloud.AuthOptions{IdentityEndpoint: srv.URL + "/v3", ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "app-secret", AllowReauth: true},
+		Endpoint: gophercloud.EndpointOpts{Region: "RegionOne", Availability: gophercloud.AvailabilityPublic},
+	})
+	ctx := context.Background()
+
+	metadata, err := c.GetServerMetadata(ctx, "server-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, metadata)
+	require.NoError(t, c.UpdateServerMetadata(ctx, "server-1", map[string]string{"env": "staging"}))
+	require.NoError(t, c.DeleteServerMetadata(ctx, "server-1", "example.com_team"))
+	assert.Equal(t, []string{
+		`POST /v3/auth/tokens {"auth":{"identity":{"application_credential":{"id":"app-id","secret":"app-secret"},"methods":["application_credential"]}}}`,
+		"GET /compute/v2.1/servers/server-1/metadata null",
+		`POST /v3/auth/tokens {"auth":{"identity":{"application_credential":{"id":"app-id","secret":"app-secret"},"methods":["application_credential"]}}}`,
+		"GET /compute/v2.1/servers/server-1/metadata null",
+		`POST /compute/v2.1/servers/server-1/metadata {"metadata":{"env":"staging"}}`,
+		"DELETE /compute/v2.1/servers/server-1/metadata/example.com_team null",
+	}, requests)
+
+	err = c.DeleteServerMetadata(ctx, "throttled", "env")
+	assert.ErrorContains(t, err, "rate limited")
+	assert.True(t, isRetryableOpenStackError(err))
+	assert.False(t, isNotFoundOpenStackError(err))
+	assert.False(t, isRetryableOpenStackError(errors.New("connection refused")))
+}
+
+func TestReconcileOpenStack(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "example.com/team": "a"}, "openstack:///2d6bb7e8-6c4c-4f1c-a1d4-3d2b8a1b0c9e")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	// the value of env changed, zone is no longer set and the unmanaged metadata is left alone
+	mock := &mockOpenStackClient{metadata: map[string]string{"env": "staging", "zone": "nova", "owner": "ops"}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env", "example.com/team", "zone"}, Cloud: "openstack", OpenStackClient: mock}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "example.com_team": "a"}, mock.updated)
+	assert.Equal(t, []string{"zone"}, mock.deletedKeys)
+
+	// up to date
+	mock.metadata = map[string]string{"env": "prod", "example.com_team": "a", "owner": "ops"}
+	mock.updated, mock.deletedKeys = nil, nil
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Nil(t, mock.updated)
+	assert.Nil(t, mock.deletedKeys)
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// options are the controller's settings, from command line flags and an optional --config file.
+type options struct {
+	configFile            string
+	probesAddr            string
+	metricsAddr           string
+	pprofAddr             string
+	enableLeaderElection  bool
+	labelsStr             string
+	annotationsStr        string
+	annotationTagsStr     string
+	awsLabelsStr          string
+	gcpLabelsStr          string
+	azureLabelsStr        string
+	cloudProvider         string
+	jsonLogs              bool
+	clusterNameTag        string
+	clusterName           string
+	clusterNameLabel      string
+	sampleRate            float64
+	cloudHTTPTimeout      time.Duration
+	cloudHTTPProxy        string
+	aliasWellKnownKeys    bool
+	keyAliasesStr         string
+	twoPhaseDelete        time.Duration
+	credentialsRecheck    time.Duration
+	sinkSpec              string
+	azToRegionFunc        string
+	reconcileNodesStr     string
+	gcpSkipNonRunning     bool
+	gcpLabelDisks         bool
+	gcpOverwriteUnmanaged bool
+	consolidateDuplicates bool
+	cleanupOnDelete       bool
+	cleanupFinalizer      string
+	tagPrefix             string
+	managedByTag          string
+	staticTagsStr         string
+	missingValue          string
+	disabledAnnotation    string
+	nodeSelectorStr       string
+	labelRegexStr         string
+	onDuplicate           string
+	missingIDRequeue      time.Duration
+	tagRegion             bool
+	dryRun                bool
+	preloadCloudState     bool
+	preloadConcurrency    int
+	maxConcurrent         int
+	cloudRateLimit        float64
+	reconcileQPS          float64
+	maxRetries            int
+	describeInstances     bool
+	tagEBSVolumes         bool
+	tagENIs               bool
+	awsAssumeRoleARN      string
+	awsExternalID         string
+	awsRegion             string
+	awsEndpointURL        string
+	tagApplyOrder         string
+	sweepInterval         time.Duration
+	resyncPeriod          time.Duration
+	sweepConcurrency      int
+	sweepRate             float64
+	gcpProjectAnnotation  string
+	gcpZoneAnnotation     string
+	gcpInstanceAnnotation string
+	stripKeyPrefix        string
+	nodeUIDTag            string
+	nodeNameTag           string
+	tagTemplatesList      repeatedFlag
+	autoClouds            listFlag
+	gcpImpersonateSA      string
+	tagTemplateMissing    string
+	stripKeySuffix        string
+	stripValuePrefix      string
+	stripValueSuffix      string
+}
+
+// register defines the flags of the options on fs.
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "YAML file of flag name: value settings, eg: 'labels: [env, team]'. Flags set on the command line take precedence")
+	fs.StringVar(&o.probesAddr, "probes-addr", ":8080", "The address the /readyz and /healthz probes endpoint binds to.")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", ":8081", "The address the metric endpoint binds to.")
+	fs.StringVar(&o.pprofAddr, "pprof-addr", "", "The address the pprof server endpoint binds to.")
+	fs.BoolVar(&o.enableLeaderElection, "enable-leader-election", false, "Enable leader election.")
+	fs.StringVar(&o.labelsStr, "labels", "", "Comma-separated list of label keys to sync. Use labelKey=tagKey to write a label under a different tag key. Glob patterns, eg: topology.kubernetes.io/*, sync every matching label under its own key on AWS")
+	fs.StringVar(&o.labelRegexStr, "label-regex", "", "Regular expression selecting more label keys to sync under their own key, eg: '^example\\.com/'. It's unanchored, use ^ and $ to match whole keys. Only supported on AWS and GCP")
+	fs.StringVar(&o.awsLabelsStr, "aws-labels", "", "Comma-separated list of label keys to sync for AWS nodes, optionally as labelKey=tagKey. Overrides -labels")
+	fs.StringVar(&o.gcpLabelsStr, "gcp-labels", "", "Comma-separated list of label keys to sync for GCP nodes, optionally as labelKey=tagKey. Overrides -labels")
+	fs.StringVar(&o.azureLabelsStr, "azure-labels", "", "Comma-separated list of label keys to sync for Azure nodes, optionally as labelKey=tagKey. Overrides -labels")
+	fs.StringVar(&o.annotationsStr, "annotations", "", "Comma-separated list of annotation keys to sync")
+	fs.StringVar(&o.annotationTagsStr, "annotation-tags", "", "Comma-separated list of annotationKey:tagKey pairs of annotations to sync under an explicit tag key, eg: example.com/cost-center:CostCenter")
+	fs.StringVar(&o.cloudProvider, "cloud", "", "Cloud provider (aws, gcp, azure, do for DigitalOcean, oci for Oracle Cloud or openstack), or auto to detect the cloud of each node from its provider ID")
+	fs.Var(&o.autoClouds, "auto-clouds", "With -cloud auto, the clouds whose nodes are tagged, eg: aws,gcp. Nodes of other clouds are skipped. Defaults to all supported clouds")
+	fs.BoolVar(&o.jsonLogs, "json", false, "Output logs in JSON format")
+	fs.StringVar(&o.nodeUIDTag, "tag-node-uid", "", "Cloud tag key to stamp with the node's metadata.uid, eg: k8s-node-uid. Disabled when empty")
+	fs.StringVar(&o.nodeNameTag, "node-name-tag", "", "Cloud tag key to stamp with the node's name, eg: k8s-node-name. Disabled when empty")
+	fs.Var(&o.tagTemplatesList, "tag-template", "Tag whose value combines node labels and annotations, as key=template, eg: env-team={label:env}-{label:team}. References are {label:<key>} or {annotation:<key>}. Repeatable. Separate the templates with newlines in the environment variable")
+	fs.StringVar(&o.tagTemplateMissing, "tag-template-missing", tagTemplateMissingSkip, "How to render tag templates referencing a label or annotation missing from the node: 'skip' doesn't write the tag, 'empty' substitutes an empty string")
+	fs.StringVar(&o.clusterNameTag, "cluster-name-tag", "", "Cloud tag key to stamp with the cluster name. Disabled when empty")
+	fs.StringVar(&o.clusterName, "cluster-name", "", "Static cluster name for -cluster-name-tag. When empty the node's -cluster-name-label label is used, falling back to the kube-system namespace UID")
+	fs.StringVar(&o.clusterNameLabel, "cluster-name-label", defaultClusterNameLabel, "Node label to read the cluster name from for -cluster-name-tag")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Log the tag changes the controller would make without applying them")
+	fs.Float64Var(&o.sampleRate, "sample-rate", 1.0, "Fraction of nodes (0-1], selected deterministically by node name, whose tags are written. Changes for other nodes are only logged")
+	fs.DurationVar(&o.cloudHTTPTimeout, "cloud-http-timeout", 0, "Timeout for HTTP requests to the cloud provider API. 0 uses the SDK default")
+	fs.StringVar(&o.cloudHTTPProxy, "cloud-http-proxy", "", "Proxy URL for HTTP requests to the cloud provider API. Defaults to the HTTPS_PROXY/NO_PROXY environment")
+	fs.BoolVar(&o.aliasWellKnownKeys, "alias-well-known-keys", false, "Write well-known Kubernetes label keys as short tag keys, eg: topology.kubernetes.io/region as region")
+	fs.StringVar(&o.keyAliasesStr, "key-aliases", "", "Comma-separated list of labelKey=tagKey aliases. Overrides the -alias-well-known-keys defaults")
+	fs.DurationVar(&o.credentialsRecheck, "credentials-recheck-interval", 0, "How often the readiness check probes the cloud credentials again once verified. It fails after 3 consecutive failed probes. 0 never probes them again")
+	fs.DurationVar(&o.twoPhaseDelete, "two-phase-delete", 0, "Only delete a managed tag once it is observed as removed on two reconciles at least this far apart. 0 deletes immediately")
+	fs.StringVar(&o.sinkSpec, "sink", "cloud", "Where to apply tag updates: 'cloud' calls the cloud provider APIs, 'file:<path>' appends them as JSON lines to a file for an external tool to apply")
+	fs.StringVar(&o.azToRegionFunc, "az-to-region-func", "suffix", "How to derive an AWS instance's region from its availability zone, to tag it with an EC2 client for that region: 'suffix' drops the zone suffix, eg: us-east-1a -> us-east-1, 'none' tags all instances through the controller's home region")
+	fs.StringVar(&o.reconcileNodesStr, "reconcile-nodes", "", "Comma-separated list of node names to reconcile once, then exit without starting the controller")
+	fs.BoolVar(&o.consolidateDuplicates, "consolidate-duplicate-keys", false, "Delete AWS tags whose key only differs in case from a managed key, eg: Env next to env, keeping the managed key")
+	fs.BoolVar(&o.preloadCloudState, "preload-cloud-state", false, "Fetch the current cloud tags of all nodes' instances once elected leader, so the first reconcile of each node can skip it")
+	fs.IntVar(&o.maxConcurrent, "max-concurrent-reconciles", 1, "Maximum number of nodes reconciled concurrently. Consider the cloud API rate limits, and -cloud-rate-limit, when raising it on large clusters")
+	fs.IntVar(&o.preloadConcurrency, "preload-concurrency", 10, "Maximum number of concurrent cloud API requests of -preload-cloud-state")
+	fs.IntVar(&o.maxRetries, "max-retries", 3, fmt.Sprintf("Maximum number of retries, with exponential backoff, of AWS and GCP API calls failing with throttling, quota or server errors. At most %d", maxCloudCallRetries))
+	fs.BoolVar(&o.describeInstances, "aws-describe-instances", false, "Read the tags of EC2 instances with DescribeInstances rather than DescribeTags, which also returns their volume and network interface IDs")
+	fs.BoolVar(&o.tagEBSVolumes, "tag-ebs-volumes", false, "Also sync the managed tags to the EBS volumes of AWS instances deleted on their termination, eg: their root volume")
+	fs.BoolVar(&o.tagENIs, "tag-enis", false, "Also sync the managed tags to the network interfaces attached to AWS instances. Network interfaces attached after a node's reconcile are tagged on its next reconcile, eg: by -resync-period")
+	fs.StringVar(&o.awsRegion, "aws-region", "", "AWS region of the default EC2 client, eg: when IMDS is blocked. Defaults to the SDK's region detection, eg: AWS_REGION or IMDS")
+	fs.StringVar(&o.awsEndpointURL, "aws-endpoint-url", "", "Endpoint URL of the EC2 API, eg: http://localhost:4566 for LocalStack. Defaults to the SDK's endpoint resolution")
+	fs.StringVar(&o.tagApplyOrder, "tag-apply-order", "", "Comma-separated list of AWS tag keys, as written to the instance, created one call each in this order before the other tags, eg: for ABAC policies that require some tags to exist before others can be set")
+	fs.StringVar(&o.awsAssumeRoleARN, "aws-assume-role-arn", "", "ARN of an IAM role to assume through STS to tag the EC2 instances, eg: when they're in another account than the controller")
+	fs.StringVar(&o.gcpImpersonateSA, "gcp-impersonate-service-account", "", "Email of a GCP service account to impersonate to label the GCE instances, eg: node-tagger@my-project.iam.gserviceaccount.com. The controller's credentials need the Service Account Token Creator role on it")
+	fs.StringVar(&o.awsExternalID, "aws-external-id", "", "External ID passed when assuming -aws-assume-role-arn, if the role's trust policy requires one")
+	fs.Float64Var(&o.cloudRateLimit, "cloud-rate-limit", 0, "Maximum number of tag syncs per second through the cloud provider API, shared by all reconciles. 0 disables the limit")
+	fs.Float64Var(&o.reconcileQPS, "global-reconcile-qps", 0, "Maximum number of reconciles per second, of all nodes. Unlike -cloud-rate-limit it also limits reconciles that don't sync tags. 0 disables the limit")
+	fs.DurationVar(&o.resyncPeriod, "resync-period", 0, "Resync period of the node cache. All nodes are reconciled on every resync, to correct drift of cloud tags changed outside of the controller. 0 keeps controller-runtime's default period of about 10h and ignores resyncs")
+	fs.DurationVar(&o.sweepInterval, "sweep-interval", 0, "Interval of sweeps that reconcile all nodes, to correct drift of cloud tags changed outside of the controller. 0 disables the sweep")
+	fs.IntVar(&o.sweepConcurrency, "sweep-concurrency", 1, "Maximum number of concurrent reconciles of a sweep")
+	fs.Float64Var(&o.sweepRate, "sweep-rate", 1, "Maximum number of reconciles per second of a sweep, on top of -cloud-rate-limit. 0 disables the limit")
+	fs.BoolVar(&o.gcpOverwriteUnmanaged, "gcp-overwrite-unmanaged", false, "Overwrite existing unmanaged GCP labels that a managed key collides with once sanitized, eg: a team-name label for the team.name key. By default they're left alone")
+	fs.StringVar(&o.stripKeyPrefix, "strip-key-prefix", "", "Comma-separated list of prefixes to strip from label and annotation keys before writing them as tag keys. The first match is stripped")
+	fs.StringVar(&o.stripKeySuffix, "strip-key-suffix", "", "Comma-separated list of suffixes to strip from label and annotation keys before writing them as tag keys, eg: -managed. The first match is stripped")
+	fs.StringVar(&o.stripValuePrefix, "strip-value-prefix", "", "Comma-separated list of prefixes to strip from label and annotation values. The first match is stripped")
+	fs.StringVar(&o.stripValueSuffix, "strip-value-suffix", "", "Comma-separated list of suffixes to strip from label and annotation values. The first match is stripped")
+	fs.StringVar(&o.tagPrefix, "tag-prefix", "", "Prefix prepended to every cloud tag key written by the controller, eg: k8s: to write the env label as k8s:env. Only prefixed tags are managed, unprefixed tags of other tools are left alone")
+	fs.DurationVar(&o.missingIDRequeue, "missing-provider-id-requeue", 15*time.Second, "How long to wait before reconciling a node without a spec.providerID again, eg: a new node whose provider ID isn't set yet. It doubles with every consecutive reconcile, up to 5m. 0 waits for the node's next change")
+	fs.StringVar(&o.onDuplicate, "on-duplicate-provider-id", duplicateProviderIDNewest, "How to reconcile nodes whose provider ID references the same instance as another node's: 'newest' only reconciles the node with the latest heartbeat, 'skip' none of them, 'error' fails their reconciles")
+	fs.StringVar(&o.disabledAnnotation, "disabled-annotation", defaultDisabledAnnotation, "Node annotation opting a node out of tagging when set to true, eg: for nodes whose tags are managed by another process. Disabled when empty")
+	fs.StringVar(&o.nodeSelectorStr, "node-selector", "", "Label selector of the nodes to tag, eg: node-pool=batch or 'env in (prod, staging)'. The other nodes are ignored like disabled nodes. All nodes are tagged when empty")
+	fs.StringVar(&o.missingValue, "missing-value", "", "Tag value written for the labels and annotations missing from a node instead of deleting their tags, eg: unknown. Missing keys' tags are deleted when empty")
+	fs.StringVar(&o.staticTagsStr, "static-tags", "", "Comma-separated list of key=value tags applied to every instance, eg: cluster=prod-us-east. They take precedence over label and annotation tags of the same key")
+	fs.StringVar(&o.managedByTag, "managed-by-tag", "", "Cloud tag key listing the tag keys written by the controller to an instance, so tags of keys later removed from the configuration are still deleted, eg: k8s-node-tagger. Not supported on GCP. Disabled when empty")
+	fs.BoolVar(&o.tagRegion, "tag-region-from-provider-id", false, "Stamp a region tag with the node's topology.kubernetes.io/region label, or the region derived from the zone of its AWS or GCP provider ID when the label is missing. The label's tag key is used when it's synced")
+	fs.BoolVar(&o.cleanupOnDelete, "cleanup-on-delete", false, "Remove the managed tags from a node's instance when the node is deleted")
+	fs.StringVar(&o.cleanupFinalizer, "cleanup-finalizer", "", "Finalizer added to nodes with -cleanup-on-delete, so their managed tags are removed even if they're deleted while the controller is down, eg: example.com/node-tagger-cleanup. Requires the patch permission on nodes. Remove it from the nodes before unsetting it, see the README")
+	fs.StringVar(&o.gcpProjectAnnotation, "gcp-project-annotation", "", "Node annotation overriding the GCP project of the node's provider ID")
+	fs.StringVar(&o.gcpZoneAnnotation, "gcp-zone-annotation", "", "Node annotation overriding the GCP zone of the node's provider ID")
+	fs.StringVar(&o.gcpInstanceAnnotation, "gcp-instance-annotation", "", "Node annotation overriding the GCP instance name of the node's provider ID")
+	fs.BoolVar(&o.gcpLabelDisks, "gcp-label-disks", false, "Also sync the managed labels to the zonal disks attached to GCP instances")
+	fs.BoolVar(&o.gcpSkipNonRunning, "gcp-skip-non-running", false, "Skip updating the labels of GCP instances that aren't RUNNING, eg: TERMINATED or SUSPENDED instances")
+}
+
+// envPrefix prefixes the environment variables setting flags, eg: NODE_TAGGER_LABELS for --labels
+const envPrefix = "NODE_TAGGER_"
+
+// parseOptions parses args with fs, then applies the environment variables and the --config file
+// if one is set, in decreasing order of precedence. The options are returned along with
+// environment and config file errors, so callers can still honour eg: --json.
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
+	o := &options{}
+	o.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := loadEnv(fs); err != nil {
+		return o, err
+	}
+	if o.configFile != "" {
+		if err := loadConfigFile(fs, o.configFile); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// envName returns the environment variable setting the flag name, eg: NODE_TAGGER_METRICS_ADDR
+// for --metrics-addr.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv sets the flags of fs from their environment variable. Flags set on the command line
+// are left as is.
+func loadEnv(fs *flag.FlagSet) error {
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || setOnCommandLine[f.Name] {
+			return
+		}
+		values := []string{value}
+		if _, ok := f.Value.(*repeatedFlag); ok {
+			values = slices.DeleteFunc(strings.Split(value, "\n"), func(v string) bool { return strings.TrimSpace(v) == "" })
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid environment variable %s: %v", envName(f.Name), err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// loadConfigFile sets the flags of fs from a YAML file mapping flag names to values. Lists are
+// joined with commas and maps are written as key=value pairs, except for the repeatable flags
+// which are set once per item. Flags already set on the command line or by the environment are
+// left as is.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+
+	alreadySet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if name == "config" || fs.Lookup(name) == nil {
+			errs = append(errs, fmt.Errorf("unknown config key %q", name))
+			continue
+		}
+		if alreadySet[name] {
+			continue
+		}
+
+		var items []string
+		if isRepeatableFlag(fs.Lookup(name)) {
+			items, err = configItems(values[name])
+		} else {
+			var value string
+			value, err = configValue(values[name])
+			items = []string{value}
+		}
+		for _, item := range items {
+			if err != nil {
+				break
+			}
+			err = fs.Set(name, item)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid config key %q: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configValue converts a config file value to the string form of its flag.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any, map[string]any:
+		items, err := configItems(v)
+		return strings.Join(items, ","), err
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// configItems converts a config file value to the values of a repeatable flag: the items of a
+// list, the key=value pairs of a map, or else the value itself.
+func configItems(v any) ([]string, error) {
+	switch v := v.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		}
+		return items, nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			s, err := configScalar(v[k])
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, k+"="+s)
+		}
+		return pairs, nil
+	}
+	s, err := configValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// configScalar converts a list item or map value of the config file to a string.
+func configScalar(v any) (string, error) {
+	switch v.(type) {
+	case []any, map[string]any:
+		return "", fmt.Errorf("nested lists and maps are not supported")
+	}
+	return configValue(v)
+}
+
+// validate checks the options without connecting to Kubernetes or the cloud provider. All
+// problems are returned, not just the first one.
+func (o *options) validate() error {
+	var errs []error
+
+	cloudLabels, cloudTagKeys, err := o.cloudLabels()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid cloud labels: %v", err))
+	}
+	annotations, err := o.annotations()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid annotation-tags: %v", err))
+	}
+	if o.labelsStr == "" && o.labelRegexStr == "" && len(annotations) == 0 && len(cloudLabels) == 0 && len(o.tagTemplatesList) == 0 {
+		errs = append(errs, fmt.Errorf("at least one of labels or annotations is required"))
+	}
+	labelKeys, tagKeys, err := o.labels()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid labels: %v", err))
+		tagKeys = make(map[string]string)
+	}
+	for _, cloud := range []string{"gcp", "azure", "do", "oci", "openstack"} {
+		if !o.handlesCloud(cloud) {
+			continue
+		}
+		keys, ok := cloudLabels[cloud]
+		if !ok {
+			keys = labelKeys
+		}
+		if slices.ContainsFunc(keys, isKeyPattern) {
+			errs = append(errs, fmt.Errorf("label key patterns are not supported on %s", cloud))
+		}
+	}
+	if o.tagPrefix != "" {
+		if o.handlesCloud("aws") && isReservedAWSTagKey(o.tagPrefix) {
+			errs = append(errs, fmt.Errorf("tag-prefix %q uses the reserved AWS prefix %q", o.tagPrefix, reservedAWSTagPrefix))
+		}
+		if o.handlesCloud("gcp") && validateGCPLabelKey(sanitizeKeyForGCP(o.tagPrefix+"x")) != nil {
+			errs = append(errs, fmt.Errorf("tag-prefix %q must start with a letter on GCP", o.tagPrefix))
+		}
+	}
+	if _, err := o.labelRegex(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid label-regex: %v", err))
+	}
+	if o.labelRegexStr != "" && slices.ContainsFunc([]string{"azure", "do", "oci", "openstack"}, o.handlesCloud) {
+		errs = append(errs, fmt.Errorf("label-regex is only supported on AWS and GCP"))
+	}
+	for _, cloud := range slices.Sorted(maps.Keys(cloudLabels)) {
+		labelKeys = append(labelKeys, cloudLabels[cloud]...)
+		maps.Copy(tagKeys, cloudTagKeys[cloud])
+	}
+	for _, k := range labelKeys {
+		if isKeyPattern(k) {
+			if _, err := path.Match(k, ""); err != nil {
+				errs = append(errs, fmt.Errorf("invalid label key pattern %q: %v", k, err))
+			}
+			if _, ok := tagKeys[k]; ok {
+				errs = append(errs, fmt.Errorf("label key pattern %q can't have a tag key", k))
+			}
+			continue
+		}
+		if msgs := validation.IsQualifiedName(k); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid label key %q: %s", k, strings.Join(msgs, "; ")))
+		}
+	}
+	for _, k := range slices.DeleteFunc([]string{o.gcpProjectAnnotation, o.gcpZoneAnnotation, o.gcpInstanceAnnotation}, func(k string) bool { return k == "" }) {
+		if msgs := validation.IsQualifiedName(k); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid GCP override annotation key %q: %s", k, strings.Join(msgs, "; ")))
+		}
+	}
+	if o.disabledAnnotation != "" {
+		if msgs := validation.IsQualifiedName(o.disabledAnnotation); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid disabled-annotation %q: %s", o.disabledAnnotation, strings.Join(msgs, "; ")))
+		}
+	}
+	if _, err := o.nodeSelector(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid node-selector: %v", err))
+	}
+	if o.cleanupFinalizer != "" {
+		if !o.cleanupOnDelete {
+			errs = append(errs, fmt.Errorf("cleanup-finalizer requires cleanup-on-delete"))
+		}
+		if msgs := validation.IsQualifiedName(o.cleanupFinalizer); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid cleanup-finalizer %q: %s", o.cleanupFinalizer, strings.Join(msgs, "; ")))
+		}
+	}
+	for _, k := range annotations {
+		if msgs := validation.IsQualifiedName(k); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid annotation key %q: %s", k, strings.Join(msgs, "; ")))
+		}
+	}
+
+	if !slices.Contains(supportedClouds, o.cloudProvider) && o.cloudProvider != cloudAuto {
+		errs = append(errs, fmt.Errorf("cloud must be one of 'aws', 'gcp', 'azure', 'do', 'oci', 'openstack' or 'auto'"))
+	}
+	if len(o.autoClouds) > 0 && o.cloudProvider != cloudAuto {
+		errs = append(errs, fmt.Errorf("auto-clouds requires cloud auto"))
+	}
+	for _, cloud := range o.autoClouds {
+		if !slices.Contains(supportedClouds, cloud) {
+			errs = append(errs, fmt.Errorf("invalid auto-clouds: unsupported cloud %q", cloud))
+		}
+	}
+	if _, err := o.tagTemplates(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tag-template: %v", err))
+	}
+	if !slices.Contains([]string{tagTemplateMissingSkip, tagTemplateMissingEmpty}, o.tagTemplateMissing) {
+		errs = append(errs, fmt.Errorf("tag-template-missing must be either 'skip' or 'empty'"))
+	}
+	if !slices.Contains([]string{duplicateProviderIDNewest, duplicateProviderIDSkip, duplicateProviderIDError}, o.onDuplicate) {
+		errs = append(errs, fmt.Errorf("on-duplicate-provider-id must be one of 'newest', 'skip' or 'error'"))
+	}
+	if o.managedByTag != "" && o.handlesCloud("gcp") {
+		errs = append(errs, fmt.Errorf("managed-by-tag is not supported on GCP"))
+	}
+	if o.managedByTag != "" && o.handlesCloud("do") {
+		errs = append(errs, fmt.Errorf("managed-by-tag is not supported on DigitalOcean"))
+	}
+
+	if o.clusterNameTag != "" && o.clusterName == "" && o.clusterNameLabel != "" {
+		if msgs := validation.IsQualifiedName(o.clusterNameLabel); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid cluster-name-label %q: %s", o.clusterNameLabel, strings.Join(msgs, "; ")))
+		}
+	}
+
+	if o.sampleRate <= 0 || o.sampleRate > 1 {
+		errs = append(errs, fmt.Errorf("sample-rate must be in the range (0, 1]"))
+	}
+
+	if o.cloudHTTPTimeout < 0 {
+		errs = append(errs, fmt.Errorf("cloud-http-timeout must not be negative"))
+	}
+	if _, err := newCloudHTTPClient(o.cloudHTTPTimeout, o.cloudHTTPProxy); err != nil {
+		errs = append(errs, fmt.Errorf("invalid cloud-http-proxy: %v", err))
+	}
+
+	if _, err := o.keyAliases(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid key-aliases: %v", err))
+	}
+	if staticTags, err := parseKeyValuePairs(o.staticTagsStr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid static-tags: %v", err))
+	} else {
+		// the tags written by the controller itself would silently override, or be overridden
+		// by, a static tag of the same key
+		for _, tag := range []struct{ flag, key string }{
+			{"managed-by-tag", o.managedByTag},
+			{"cluster-name-tag", o.clusterNameTag},
+			{"tag-node-uid", o.nodeUIDTag},
+			{"node-name-tag", o.nodeNameTag},
+		} {
+			if _, ok := staticTags[tag.key]; ok && tag.key != "" {
+				errs = append(errs, fmt.Errorf("static-tags key %q is already used by %s", tag.key, tag.flag))
+			}
+		}
+	}
+
+	if o.missingIDRequeue < 0 {
+		errs = append(errs, fmt.Errorf("missing-provider-id-requeue must not be negative"))
+	}
+	if o.twoPhaseDelete < 0 {
+		errs = append(errs, fmt.Errorf("two-phase-delete must not be negative"))
+	}
+	if o.credentialsRecheck < 0 {
+		errs = append(errs, fmt.Errorf("credentials-recheck-interval must not be negative"))
+	}
+
+	if o.maxConcurrent < 1 {
+		errs = append(errs, fmt.Errorf("max-concurrent-reconciles must be at least 1"))
+	}
+	if o.preloadConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("preload-concurrency must be at least 1"))
+	}
+
+	if o.maxRetries < 0 {
+		errs = append(errs, fmt.Errorf("max-retries must not be negative"))
+	} else if o.maxRetries > maxCloudCallRetries {
+		errs = append(errs, fmt.Errorf("max-retries must be at most %d", maxCloudCallRetries))
+	}
+	if o.awsAssumeRoleARN != "" && !arn.IsARN(o.awsAssumeRoleARN) {
+		errs = append(errs, fmt.Errorf("invalid aws-assume-role-arn %q", o.awsAssumeRoleARN))
+	}
+	if o.awsEndpointURL != "" {
+		if u, err := url.Parse(o.awsEndpointURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid aws-endpoint-url %q", o.awsEndpointURL))
+		}
+	}
+	if o.awsExternalID != "" && o.awsAssumeRoleARN == "" {
+		errs = append(errs, fmt.Errorf("aws-external-id requires aws-assume-role-arn"))
+	}
+	if o.gcpImpersonateSA != "" && !strings.Contains(o.gcpImpersonateSA, "@") {
+		errs = append(errs, fmt.Errorf("invalid gcp-impersonate-service-account %q, expected a service account email", o.gcpImpersonateSA))
+	}
+
+	if o.cloudRateLimit < 0 {
+		errs = append(errs, fmt.Errorf("cloud-rate-limit must not be negative"))
+	}
+	if o.reconcileQPS < 0 {
+		errs = append(errs, fmt.Errorf("global-reconcile-qps must not be negative"))
+	}
+
+	if o.resyncPeriod < 0 {
+		errs = append(errs, fmt.Errorf("resync-period must not be negative"))
+	}
+	if o.sweepInterval < 0 {
+		errs = append(errs, fmt.Errorf("sweep-interval must not be negative"))
+	}
+	if o.sweepConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("sweep-concurrency must be at least 1"))
+	}
+	if o.sweepRate < 0 {
+		errs = append(errs, fmt.Errorf("sweep-rate must not be negative"))
+	}
+
+	if _, ok := azToRegionFuncs[o.azToRegionFunc]; !ok {
+		errs = append(errs, fmt.Errorf("az-to-region-func must be either 'suffix' or 'none'"))
+	}
+
+	if _, _, err := parseSinkSpec(o.sinkSpec); err != nil {
+		errs = append(errs, fmt.Errorf("invalid sink: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// labels returns the label keys of --labels and the tag keys of its labelKey=tagKey entries.
+func (o *options) labels() ([]string, map[string]string, error) {
+	return parseLabelKeys(o.labelsStr)
+}
+
+// annotations returns the keys of --annotations followed by those of --annotation-tags.
+func (o *options) annotations() ([]string, error) {
+	keys, _, err := parseAnnotationTags(o.annotationTagsStr)
+	if err != nil {
+		return nil, err
+	}
+	annotations := splitList(o.annotationsStr)
+	for _, k := range keys {
+		if !slices.Contains(annotations, k) {
+			annotations = append(annotations, k)
+		}
+	}
+	return annotations, nil
+}
+
+// cloudLabels returns the label keys of the per-cloud label flags that are set, and the tag keys
+// of their labelKey=tagKey entries, by cloud.
+func (o *options) cloudLabels() (map[string][]string, map[string]map[string]string, error) {
+	cloudLabels := make(map[string][]string)
+	cloudTagKeys := make(map[string]map[string]string)
+	var errs []error
+	for cloud, labels := range map[string]string{"aws": o.awsLabelsStr, "gcp": o.gcpLabelsStr, "azure": o.azureLabelsStr} {
+		if labels == "" {
+			continue
+		}
+		keys, tagKeys, err := parseLabelKeys(labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s-labels: %v", cloud, err))
+			continue
+		}
+		cloudLabels[cloud] = keys
+		if len(tagKeys) > 0 {
+			cloudTagKeys[cloud] = tagKeys
+		}
+	}
+	return cloudLabels, cloudTagKeys, errors.Join(errs...)
+}
+
+// labelRegex returns the compiled --label-regex, nil when empty.
+func (o *options) labelRegex() (*regexp.Regexp, error) {
+	if o.labelRegexStr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(o.labelRegexStr)
+}
+
+// tagTemplates returns the parsed --tag-template tags.
+func (o *options) tagTemplates() ([]tagTemplate, error) {
+	templates := make([]tagTemplate, 0, len(o.tagTemplatesList))
+	for _, s := range o.tagTemplatesList {
+		t, err := parseTagTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// handlesCloud reports whether nodes of cloud are tagged: the configured cloud, or one of the
+// auto-clouds with cloud auto.
+func (o *options) handlesCloud(cloud string) bool {
+	if o.cloudProvider == cloudAuto {
+		return len(o.autoClouds) == 0 || slices.Contains(o.autoClouds, cloud)
+	}
+	return o.cloudProvider == cloud
+}
+
+// listFlag is a flag that can be repeated, each value being a comma-separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, splitList(s)...)
+	return nil
+}
+
+// repeatedFlag is a flag that can be repeated, each value being a single item. Unlike listFlag,
+// the values aren't split on commas, eg: for tag templates containing commas.
+type repeatedFlag []string
+
+func (l *repeatedFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *repeatedFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// isRepeatableFlag reports whether every Set of f adds to its values rather than replacing them.
+func isRepeatableFlag(f *flag.Flag) bool {
+	switch f.Value.(type) {
+	case *listFlag, *repeatedFlag:
+		return true
+	}
+	return false
+}
+
+// nodeSelector returns the parsed --node-selector, nil when empty.
+func (o *options) nodeSelector() (labels.Selector, error) {
+	if o.nodeSelectorStr == "" {
+		return nil, nil
+	}
+	return labels.Parse(o.nodeSelectorStr)
+}
+
+// keyAliases returns the tag key aliases of --alias-well-known-keys, --key-aliases, the
+// labelKey=tagKey entries of --labels and --annotation-tags, in increasing order of precedence.
+func (o *options) keyAliases() (map[string]string, error) {
+	keyAliases := make(map[string]string)
+	if o.aliasWellKnownKeys {
+		maps.Copy(keyAliases, defaultKeyAliases)
+	}
+	overrides, err := parseKeyValuePairs(o.keyAliasesStr)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(keyAliases, overrides)
+	if _, tagKeys, err := o.labels(); err == nil {
+		maps.Copy(keyAliases, tagKeys)
+	}
+	if _, tagKeys, err := parseAnnotationTags(o.annotationTagsStr); err == nil {
+		maps.Copy(keyAliases, tagKeys)
+	}
+	return keyAliases, nil
+}
+
+// runValidate implements the validate command: it parses and validates the options in args
+// and reports the result to out. It returns the process exit code.
+func runValidate(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+
+	o, err := parseOptions(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(out, "invalid configuration:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(out, "  - %s\n", line)
+		}
+		return 1
+	}
+
+	fmt.Fprintln(out, "configuration is valid")
+	return 0
+}
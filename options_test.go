@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRunValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		args       []string
+		wantCode   int
+		wantOutput []string
+	}{
+		{
+			name: "valid config",
+			config: `
+labels: [env, team]
+annotations:
+  - example.com/cost-center
+cloud: aws
+sample-rate: 0.5
+two-phase-delete: 10m
+key-aliases:
+  topology.kubernetes.io/zone: zone
+`,
+			wantCode:   0,
+			wantOutput: []string{"configuration is valid"},
+		},
+		{
+			name:       "missing keys and cloud",
+			config:     `json: true`,
+			wantCode:   1,
+			wantOutput: []string{"at least one of labels or annotations is required", "cloud must be one of 'aws', 'gcp', 'azure', 'do', 'oci', 'openstack' or 'auto'"},
+		},
+		{
+			name: "invalid label and annotation keys",
+			config: `
+labels: ["env", "not a key"]
+annotations: ["example.com/a/b"]
+cloud: gcp
+`,
+			wantCode:   1,
+			wantOutput: []string{`invalid label key "not a key"`, `invalid annotation key "example.com/a/b"`},
+		},
+		{
+			name: "label key patterns",
+			config: `
+labels: ["topology.kubernetes.io/*", "[zone", "example.com/*=example"]
+cloud: gcp
+`,
+			wantCode: 1,
+			wantOutput: []string{
+				"label key patterns are not supported on gcp",
+				`invalid label key pattern "[zone"`,
+				`label key pattern "example.com/*" can't have a tag key`,
+			},
+		},
+		{
+			name: "invalid values",
+			config: `
+labels: [env]
+cloud: mainframe
+sample-rate: 2
+key-aliases: "env"
+az-to-region-func: guess
+sink: s3:bucket
+cloud-http-proxy: "://proxy"
+sweep-concurrency: 0
+cloud-rate-limit: -1
+global-reconcile-qps: -1
+static-tags: "cluster"
+on-duplicate-provider-id: all
+missing-provider-id-requeue: -1s
+credentials-recheck-interval: -1m
+disabled-annotation: "not an annotation"
+node-selector: "node-pool in batch"
+label-regex: "^example\\.com/("
+max-retries: -1
+aws-assume-role-arn: node-tagger
+aws-endpoint-url: localhost
+max-concurrent-reconciles: 0
+cleanup-finalizer: "not a finalizer"
+`,
+			wantCode: 1,
+			wantOutput: []string{
+				"cloud must be one of 'aws', 'gcp', 'azure', 'do', 'oci', 'openstack' or 'auto'",
+				"sample-rate must be in the range (0, 1]",
+				"invalid key-aliases",
+				"az-to-region-func must be either 'suffix' or 'none'",
+				`unsupported sink: "s3:bucket"`,
+				"invalid cloud-http-proxy",
+				"sweep-concurrency must be at least 1",
+				"cloud-rate-limit must not be negative",
+				"global-reconcile-qps must not be negative",
+				`invalid static-tags: invalid key=value pair "cluster"`,
+				"on-duplicate-provider-id must be one of 'newest', 'skip' or 'error'",
+				"missing-provider-id-requeue must not be negative",
+				"credentials-recheck-interval must not be negative",
+				`invalid disabled-annotation "not an annotation"`,
+				"invalid node-selector",
+				"invalid label-regex",
+				"max-retries must not be negative",
+				`invalid aws-assume-role-arn "node-tagger"`,
+				`invalid aws-endpoint-url "localhost"`,
+				"max-concurrent-reconciles must be at least 1",
+				"cleanup-finalizer requires cleanup-on-delete",
+				`invalid cleanup-finalizer "not a finalizer"`,
+			},
+		},
+		{
+			name: "unknown key and wrong type",
+			config: `
+labels: [env]
+cloud: aws
+lables: [team]
+two-phase-delete: soon
+`,
+			wantCode:   1,
+			wantOutput: []string{`unknown config key "lables"`, `invalid config key "two-phase-delete"`},
+		},
+		{
+			name: "cloud specific labels",
+			config: `
+aws-labels: [env]
+gcp-labels: ["not a key"]
+cloud: aws
+`,
+			wantCode:   1,
+			wantOutput: []string{`invalid label key "not a key"`},
+		},
+		{
+			name: "labels with tag keys",
+			config: `
+labels: ["topology.kubernetes.io/region=Region", "env", "=team", "not a key=key"]
+cloud: aws
+`,
+			wantCode:   1,
+			wantOutput: []string{`invalid labels: invalid labelKey=tagKey pair "=team"`},
+		},
+		{
+			name: "annotation tags",
+			config: `
+annotation-tags:
+  finops.example.com/cost-center: CostCenter
+cloud: aws
+`,
+			wantCode:   0,
+			wantOutput: []string{"configuration is valid"},
+		},
+		{
+			name: "invalid annotation tags",
+			config: `
+annotation-tags: ["example.com/a/b:Owner", "example.com/owner"]
+cloud: aws
+`,
+			wantCode:   1,
+			wantOutput: []string{`invalid annotation-tags: invalid annotationKey:tagKey pair "example.com/owner"`},
+		},
+		{
+			name: "external ID without role",
+			config: `
+labels: [env]
+cloud: aws
+aws-external-id: secret
+`,
+			wantCode:   1,
+			wantOutput: []string{"aws-external-id requires aws-assume-role-arn"},
+		},
+		{
+			name: "invalid impersonated service account",
+			config: `
+labels: [env]
+cloud: gcp
+gcp-impersonate-service-account: node-tagger
+`,
+			wantCode:   1,
+			wantOutput: []string{`invalid gcp-impersonate-service-account "node-tagger", expected a service account email`},
+		},
+		{
+			name: "auto-detected cloud",
+			config: `
+labels: [env]
+cloud: auto
+`,
+			wantCode:   0,
+			wantOutput: []string{"configuration is valid"},
+		},
+		{
+			name: "managed-by tag with auto-detected cloud",
+			config: `
+labels: [env]
+cloud: auto
+managed-by-tag: k8s-node-tagger
+`,
+			wantCode:   1,
+			wantOutput: []string{"managed-by-tag is not supported on GCP"},
+		},
+		{
+			name: "managed-by tag on GCP",
+			config: `
+labels: [env]
+cloud: gcp
+managed-by-tag: k8s-node-tagger
+`,
+			wantCode:   1,
+			wantOutput: []string{"managed-by-tag is not supported on GCP"},
+		},
+		{
+			name: "too many retries",
+			config: `
+labels: [env]
+cloud: aws
+max-retries: 100
+`,
+			wantCode:   1,
+			wantOutput: []string{"max-retries must be at most 20"},
+		},
+		{
+			name: "label regex on Azure",
+			config: `
+labels: [env]
+cloud: azure
+label-regex: "^team/"
+`,
+			wantCode:   1,
+			wantOutput: []string{"label-regex is only supported on AWS and GCP"},
+		},
+		{
+			name: "static tags overlapping generated tags",
+			config: `
+labels: [env]
+cloud: aws
+static-tags:
+  cluster: prod-us-east
+  k8s-node-tagger: me
+  k8s-node-name: me
+managed-by-tag: k8s-node-tagger
+cluster-name-tag: cluster
+node-name-tag: k8s-node-name
+`,
+			wantCode: 1,
+			wantOutput: []string{
+				`static-tags key "k8s-node-tagger" is already used by managed-by-tag`,
+				`static-tags key "cluster" is already used by cluster-name-tag`,
+				`static-tags key "k8s-node-name" is already used by node-name-tag`,
+			},
+		},
+		{
+			name:       "malformed yaml",
+			config:     "labels: [env",
+			wantCode:   1,
+			wantOutput: []string{"unable to parse config file"},
+		},
+		{
+			name:       "command line flags take precedence",
+			config:     "labels: [env]\ncloud: mainframe\n",
+			args:       []string{"--cloud", "gcp"},
+			wantCode:   0,
+			wantOutput: []string{"configuration is valid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", writeConfigFile(t, tt.config)}, tt.args...)
+
+			var out bytes.Buffer
+			code := runValidate(args, &out)
+			assert.Equal(t, tt.wantCode, code, out.String())
+			for _, want := range tt.wantOutput {
+				assert.Contains(t, out.String(), want)
+			}
+		})
+	}
+}
+
+func TestRunValidateMissingConfigFile(t *testing.T) {
+	var out bytes.Buffer
+	code := runValidate([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, &out)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out.String(), "unable to read config file")
+}
+
+func TestParseOptionsConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+labels: [env, team]
+cloud: gcp
+sample-rate: 0.25
+two-phase-delete: 1m
+gcp-skip-non-running: true
+key-aliases:
+  kubernetes.io/arch: arch
+  kubernetes.io/os: os
+`)
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path, "--labels", "env"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env", o.labelsStr)
+	assert.Equal(t, "gcp", o.cloudProvider)
+	assert.Equal(t, 0.25, o.sampleRate)
+	assert.Equal(t, time.Minute, o.twoPhaseDelete)
+	assert.True(t, o.gcpSkipNonRunning)
+	assert.Equal(t, "kubernetes.io/arch=arch,kubernetes.io/os=os", o.keyAliasesStr)
+	assert.NoError(t, o.validate())
+}
+
+func TestParseOptionsConfigFilePrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+labels: [env, team]
+annotations: [example.com/cost-center]
+cloud: azure
+tag-prefix: "k8s:"
+static-tags:
+  cluster: prod-us-east
+  owner: platform
+dry-run: true
+`)
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+	require.NoError(t, err)
+	assert.Equal(t, "env,team", o.labelsStr)
+	assert.Equal(t, "example.com/cost-center", o.annotationsStr)
+	assert.Equal(t, "azure", o.cloudProvider)
+	assert.Equal(t, "k8s:", o.tagPrefix)
+	assert.Equal(t, "cluster=prod-us-east,owner=platform", o.staticTagsStr)
+	assert.True(t, o.dryRun)
+	assert.NoError(t, o.validate())
+
+	// flags set on the command line win, even when set to their zero value
+	o, err = parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path, "--cloud", "aws", "--tag-prefix", "", "--static-tags", "cluster=dev", "--dry-run=false"})
+	require.NoError(t, err)
+	assert.Equal(t, "env,team", o.labelsStr)
+	assert.Equal(t, "aws", o.cloudProvider)
+	assert.Empty(t, o.tagPrefix)
+	assert.Equal(t, "cluster=dev", o.staticTagsStr)
+	assert.False(t, o.dryRun)
+}
+
+func TestOptionsTagPrefix(t *testing.T) {
+	tests := []struct {
+		cloud   string
+		prefix  string
+		wantErr string
+	}{
+		{cloud: "aws", prefix: "k8s:"},
+		{cloud: "aws", prefix: "k8s/"},
+		{cloud: "aws", prefix: "AWS:k8s:", wantErr: `tag-prefix "AWS:k8s:" uses the reserved AWS prefix "aws:"`},
+		{cloud: "gcp", prefix: "aws:"},
+		{cloud: "gcp", prefix: "K8S:"},
+		{cloud: "gcp", prefix: "_k8s-", wantErr: `tag-prefix "_k8s-" must start with a letter on GCP`},
+		{cloud: "aws", prefix: "_k8s-"},
+		{cloud: "auto", prefix: "1-", wantErr: `tag-prefix "1-" must start with a letter on GCP`},
+		{cloud: "azure", prefix: "k8s/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cloud+" "+tt.prefix, func(t *testing.T) {
+			o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", tt.cloud, "--tag-prefix", tt.prefix})
+			require.NoError(t, err)
+			err = o.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseOptionsEnv(t *testing.T) {
+	t.Setenv("NODE_TAGGER_LABELS", "env,team")
+	t.Setenv("NODE_TAGGER_CLOUD", "gcp")
+	t.Setenv("NODE_TAGGER_METRICS_ADDR", ":9090")
+	t.Setenv("NODE_TAGGER_DRY_RUN", "true")
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "env,team", o.labelsStr)
+	assert.Equal(t, "gcp", o.cloudProvider)
+	assert.Equal(t, ":9090", o.metricsAddr)
+	assert.True(t, o.dryRun)
+	assert.NoError(t, o.validate())
+
+	t.Run("flags override the environment", func(t *testing.T) {
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws", "--dry-run=false"})
+		require.NoError(t, err)
+		assert.Equal(t, "env", o.labelsStr)
+		assert.Equal(t, "aws", o.cloudProvider)
+		assert.False(t, o.dryRun)
+		assert.Equal(t, ":9090", o.metricsAddr)
+	})
+
+	t.Run("the environment overrides the config file", func(t *testing.T) {
+		path := writeConfigFile(t, "labels: [zone]\nannotations: [example.com/cost-center]\n")
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+		require.NoError(t, err)
+		assert.Equal(t, "env,team", o.labelsStr)
+		assert.Equal(t, "example.com/cost-center", o.annotationsStr)
+	})
+
+	t.Run("config file from the environment", func(t *testing.T) {
+		t.Setenv("NODE_TAGGER_CONFIG", writeConfigFile(t, "annotations: [example.com/cost-center]\n"))
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "example.com/cost-center", o.annotationsStr)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("NODE_TAGGER_SAMPLE_RATE", "half")
+		_, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+		assert.ErrorContains(t, err, "invalid environment variable NODE_TAGGER_SAMPLE_RATE")
+	})
+}
+
+func TestExampleConfigMap(t *testing.T) {
+	data, err := os.ReadFile("examples/configmap.yaml")
+	require.NoError(t, err)
+	var cm corev1.ConfigMap
+	require.NoError(t, yaml.Unmarshal(data, &cm))
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", writeConfigFile(t, cm.Data["config.yaml"])})
+	require.NoError(t, err)
+	assert.NoError(t, o.validate())
+}
+
+func TestParseOptionsTagTemplates(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"--cloud", "aws",
+		"--tag-template", "env-team={label:env}-{label:team}",
+		"--tag-template", "owners={annotation:example.com/owner},{label:team}",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, o.validate())
+	templates, err := o.tagTemplates()
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, []string{"env-team", "owners"}, []string{templates[0].Key, templates[1].Key})
+
+	t.Run("config file", func(t *testing.T) {
+		path := writeConfigFile(t, "cloud: aws\ntag-template:\n  env-team: '{label:env}-{label:team}'\n  owners: '{annotation:example.com/owner},{label:team}'\ntag-template-missing: empty\n")
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+		require.NoError(t, err)
+		assert.NoError(t, o.validate())
+		assert.Equal(t, repeatedFlag{"env-team={label:env}-{label:team}", "owners={annotation:example.com/owner},{label:team}"}, o.tagTemplatesList)
+		assert.Equal(t, tagTemplateMissingEmpty, o.tagTemplateMissing)
+	})
+
+	t.Run("config file list", func(t *testing.T) {
+		path := writeConfigFile(t, "cloud: aws\ntag-template:\n  - 'env-team={label:env},{label:team}'\n  - 'site=dc1'\n")
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+		require.NoError(t, err)
+		assert.Equal(t, repeatedFlag{"env-team={label:env},{label:team}", "site=dc1"}, o.tagTemplatesList)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("NODE_TAGGER_TAG_TEMPLATE", "env-team={label:env},{label:team}\nsite=dc1\n")
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--cloud", "aws"})
+		require.NoError(t, err)
+		assert.Equal(t, repeatedFlag{"env-team={label:env},{label:team}", "site=dc1"}, o.tagTemplatesList)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--cloud", "aws", "--tag-template", "env-team={env}", "--tag-template-missing", "fail"})
+		require.NoError(t, err)
+		assert.EqualError(t, o.validate(), "invalid tag-template: invalid reference \"{env}\" in template \"{env}\", expected {label:<key>} or {annotation:<key>}\n"+
+			"tag-template-missing must be either 'skip' or 'empty'")
+	})
+}
+
+func TestParseOptionsAutoClouds(t *testing.T) {
+	// the managed-by tag is supported as GCP and DigitalOcean nodes aren't tagged
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "auto", "--auto-clouds", "aws,azure", "--managed-by-tag", "managed-by"})
+	require.NoError(t, err)
+	assert.NoError(t, o.validate())
+	assert.Equal(t, listFlag{"aws", "azure"}, o.autoClouds)
+	assert.True(t, o.handlesCloud("azure"))
+	assert.False(t, o.handlesCloud("gcp"))
+
+	o, err = parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws", "--auto-clouds", "gcp,vsphere"})
+	require.NoError(t, err)
+	assert.EqualError(t, o.validate(), "auto-clouds requires cloud auto\n"+
+		"invalid auto-clouds: unsupported cloud \"vsphere\"")
+}
+
+func TestParseOptionsMaxConcurrentReconciles(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, o.maxConcurrent, "nodes are reconciled one at a time by default")
+
+	o, err = parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws", "--max-concurrent-reconciles", "8"})
+	require.NoError(t, err)
+	assert.Equal(t, 8, o.maxConcurrent)
+	assert.NoError(t, o.validate())
+
+	path := writeConfigFile(t, "labels: [env]\ncloud: aws\nmax-concurrent-reconciles: 16\n")
+	o, err = parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+	require.NoError(t, err)
+	assert.Equal(t, 16, o.maxConcurrent)
+}
+
+func TestParseOptionsResyncPeriod(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws"})
+	require.NoError(t, err)
+	assert.Zero(t, o.resyncPeriod)
+
+	o, err = parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--labels", "env", "--cloud", "aws", "--resync-period", "1h"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, o.resyncPeriod)
+	assert.NoError(t, o.validate())
+
+	o.resyncPeriod = -time.Minute
+	assert.ErrorContains(t, o.validate(), "resync-period must not be negative")
+}
+
+func TestOptionsLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      string
+		keyAliases  string
+		wantKeys    []string
+		wantTagKeys map[string]string
+		wantAliases map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "bare keys",
+			labels:      "env,team",
+			wantKeys:    []string{"env", "team"},
+			wantTagKeys: map[string]string{},
+			wantAliases: map[string]string{},
+		},
+		{
+			name:        "tag keys",
+			labels:      "topology.kubernetes.io/region=Region, env",
+			wantKeys:    []string{"topology.kubernetes.io/region", "env"},
+			wantTagKeys: map[string]string{"topology.kubernetes.io/region": "Region"},
+			wantAliases: map[string]string{"topology.kubernetes.io/region": "Region"},
+		},
+		{
+			name:        "tag keys take precedence over key aliases",
+			labels:      "topology.kubernetes.io/region=Region",
+			keyAliases:  "topology.kubernetes.io/region=region,kubernetes.io/os=os",
+			wantKeys:    []string{"topology.kubernetes.io/region"},
+			wantTagKeys: map[string]string{"topology.kubernetes.io/region": "Region"},
+			wantAliases: map[string]string{"topology.kubernetes.io/region": "Region", "kubernetes.io/os": "os"},
+		},
+		{
+			name:    "missing tag key",
+			labels:  "env=",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &options{labelsStr: tt.labels, keyAliasesStr: tt.keyAliases}
+
+			keys, tagKeys, err := o.labels()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKeys, keys)
+			assert.Equal(t, tt.wantTagKeys, tagKeys)
+
+			aliases, err := o.keyAliases()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAliases, aliases)
+		})
+	}
+}
+
+func TestOptionsAnnotationTags(t *testing.T) {
+	o := &options{
+		annotationsStr:    "example.com/owner",
+		annotationTagsStr: "finops.example.com/cost-center:CostCenter,example.com/owner:Owner",
+		keyAliasesStr:     "finops.example.com/cost-center=cost-center",
+	}
+
+	annotations, err := o.annotations()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com/owner", "finops.example.com/cost-center"}, annotations)
+
+	aliases, err := o.keyAliases()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"finops.example.com/cost-center": "CostCenter", "example.com/owner": "Owner"}, aliases)
+
+	o.annotationTagsStr = "example.com/owner"
+	_, err = o.annotations()
+	assert.ErrorContains(t, err, `invalid annotationKey:tagKey pair "example.com/owner"`)
+}
+
+func TestOptionsCloudLabels(t *testing.T) {
+	o := &options{
+		awsLabelsStr: "topology.kubernetes.io/region=Region,env",
+		gcpLabelsStr: "env",
+	}
+
+	labels, tagKeys, err := o.cloudLabels()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"aws": {"topology.kubernetes.io/region", "env"},
+		"gcp": {"env"},
+	}, labels)
+	assert.Equal(t, map[string]map[string]string{
+		"aws": {"topology.kubernetes.io/region": "Region"},
+	}, tagKeys)
+
+	o.azureLabelsStr = "env="
+	_, _, err = o.cloudLabels()
+	assert.ErrorContains(t, err, `azure-labels: invalid labelKey=tagKey pair "env="`)
+}
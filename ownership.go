@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// maxManagedByValueLength is the maximum length of the managed-by tag's value, the tag value
+// limit of both AWS and Azure
+const maxManagedByValueLength = 256
+
+// tagOwnership records the tag keys the controller has written to each instance, to tell them
+// apart from pre-existing unmanaged tags that use the same key. It's kept in memory only, so
+// ownership is forgotten on restart and reclaimed once a tag is written or found up to date.
+type tagOwnership struct {
+	mu       sync.Mutex
+	owned    map[string]map[string]bool // instance key -> tag key
+	collided map[string]map[string]bool // instance key -> tag key
+}
+
+// owns reports whether the controller has written key to instance.
+func (o *tagOwnership) owns(instance, key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.owned[instance][key]
+}
+
+// claim records keys as written to instance.
+func (o *tagOwnership) claim(instance string, keys ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.owned == nil {
+		o.owned = make(map[string]map[string]bool)
+	}
+	if o.owned[instance] == nil {
+		o.owned[instance] = make(map[string]bool)
+	}
+	for _, k := range keys {
+		o.owned[instance][k] = true
+		delete(o.collided[instance], k)
+	}
+	if len(o.collided[instance]) == 0 {
+		delete(o.collided, instance)
+	}
+}
+
+// release forgets keys written to instance, eg: once they're deleted.
+func (o *tagOwnership) release(instance string, keys ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, k := range keys {
+		delete(o.owned[instance], k)
+	}
+	if len(o.owned[instance]) == 0 {
+		delete(o.owned, instance)
+	}
+}
+
+// collide records that a managed key was found on instance as a tag the controller didn't write,
+// and reports whether that collision is new. Claiming the key forgets it.
+func (o *tagOwnership) collide(instance, key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.collided[instance][key] {
+		return false
+	}
+	if o.collided == nil {
+		o.collided = make(map[string]map[string]bool)
+	}
+	if o.collided[instance] == nil {
+		o.collided[instance] = make(map[string]bool)
+	}
+	o.collided[instance][key] = true
+	return true
+}
+
+// forget drops everything recorded of instance and its attached resources, eg: once its node is
+// deleted.
+func (o *tagOwnership) forget(instance string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, m := range []map[string]map[string]bool{o.owned, o.collided} {
+		for k := range m {
+			if k == instance || strings.HasPrefix(k, instance+"/") {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// unmanagedKeys returns the keys of overwritten, existing tags about to be overwritten, that the
+// managed-by tag value managedBy doesn't list. Keys are compared once mapped by sanitize, eg: to
+// the cloud's sanitized keys. The tags written by the controller are only known from the
+// managed-by tag, so none are returned without ManagedByTag.
+func (r *NodeLabelController) unmanagedKeys(managedBy string, sanitize func(string) string, overwritten []string) []string {
+	if r.ManagedByTag == "" {
+		return nil
+	}
+	written := map[string]bool{sanitize(r.managedByTagKey()): true}
+	for _, k := range parseManagedByValue(managedBy) {
+		written[sanitize(k)] = true
+	}
+
+	var keys []string
+	for _, k := range overwritten {
+		if !written[sanitize(k)] {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// recordUnmanagedCollisions counts and logs keys, managed keys of owner found as existing tags the
+// controller didn't write, once per collision.
+func (r *NodeLabelController) recordUnmanagedCollisions(ctx context.Context, cloud, owner string, keys []string, logValues []any) {
+	for _, k := range keys {
+		if !r.ownership.collide(owner, k) {
+			continue
+		}
+		unmanagedCollisions.WithLabelValues(cloud).Inc()
+		ctrl.LoggerFrom(ctx).Info("Managed key collides with an existing unmanaged tag", slices.Concat(logValues, []any{"key", k})...)
+	}
+}
+
+// managedByValue returns the value of the managed-by tag listing the tag keys written to an
+// instance: the sorted keys, separated by spaces.
+func managedByValue(keys []string) string {
+	return strings.Join(slices.Sorted(slices.Values(keys)), " ")
+}
+
+// parseManagedByValue returns the tag keys listed by a managed-by tag value.
+func parseManagedByValue(value string) []string {
+	return strings.Fields(value)
+}
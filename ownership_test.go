@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagOwnership(t *testing.T) {
+	var o tagOwnership
+	assert.False(t, o.owns("aws/i-1", "env"))
+
+	o.claim("aws/i-1", "env", "team")
+	assert.True(t, o.owns("aws/i-1", "env"))
+	assert.True(t, o.owns("aws/i-1", "team"))
+	assert.False(t, o.owns("aws/i-2", "env"))
+
+	o.release("aws/i-1", "env")
+	assert.False(t, o.owns("aws/i-1", "env"))
+	assert.True(t, o.owns("aws/i-1", "team"))
+
+	// instances without owned keys are forgotten
+	o.release("aws/i-1", "team")
+	assert.Empty(t, o.owned)
+}
+
+func TestTagOwnershipCollisions(t *testing.T) {
+	var o tagOwnership
+	assert.True(t, o.collide("gcp/p/z/i-1", "team-name"))
+	assert.False(t, o.collide("gcp/p/z/i-1", "team-name"))
+	assert.True(t, o.collide("gcp/p/z/i-1/disks/d-1", "team-name"))
+
+	// a claimed key no longer collides, until it's found unmanaged again
+	o.claim("gcp/p/z/i-1", "team-name")
+	assert.True(t, o.collide("gcp/p/z/i-1", "team-name"))
+
+	// the instance and its attached resources are forgotten together
+	o.forget("gcp/p/z/i-1")
+	assert.Empty(t, o.owned)
+	assert.Empty(t, o.collided)
+}
+
+func TestManagedByValue(t *testing.T) {
+	v := managedByValue([]string{"team", "env", "example.com/owner"})
+	assert.Equal(t, "env example.com/owner team", v)
+	assert.Equal(t, []string{"env", "example.com/owner", "team"}, parseManagedByValue(v))
+	assert.Empty(t, parseManagedByValue(""))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// preloadCache holds cloud state fetched by PreloadCloudState, keyed by instance key. Each entry
+// is consumed by the first reconcile of its instance, later reconciles read the cloud API as usual
+// so stale state is never used twice.
+type preloadCache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]V
+}
+
+func (c *preloadCache[V]) put(instance string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]V)
+	}
+	c.entries[instance] = v
+}
+
+// take returns and removes the entry of instance.
+func (c *preloadCache[V]) take(instance string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.entries[instance]
+	delete(c.entries, instance)
+	return v, ok
+}
+
+// awsPreloadBatchSize is the maximum number of instances whose tags are fetched by a single,
+// paginated, DescribeTags request of PreloadCloudState
+const awsPreloadBatchSize = 200
+
+// PreloadCloudState fetches the current tags of the instances of all nodes with up to
+// concurrency requests in flight, so the first reconcile of each node doesn't have to. AWS tags
+// are fetched in batches of instances of the same region. Failures to fetch an instance are
+// logged and left to its reconcile.
+func (r *NodeLabelController) PreloadCloudState(ctx context.Context, reader client.Reader, concurrency int) error {
+	logger := ctrl.LoggerFrom(ctx)
+
+	var nodes corev1.NodeList
+	if err := reader.List(ctx, &nodes, r.nodeListOptions()...); err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	providerIDs := make(map[string][]string)
+	for _, node := range nodes.Items {
+		providerID := r.providerID(&node)
+		if cloud, err := detectCloudFromProviderID(providerID); err == nil && r.handlesCloud(cloud) {
+			providerIDs[cloud] = append(providerIDs[cloud], providerID)
+		}
+	}
+
+	var jobs []func() error
+	if r.cloudSetUp("aws") {
+		jobs = append(jobs, r.awsPreloadJobs(ctx, providerIDs["aws"])...)
+	}
+	if r.cloudSetUp("gcp") {
+		for _, providerID := range providerIDs["gcp"] {
+			jobs = append(jobs, func() error {
+				instance, err := r.fetchGCEInstance(ctx, providerID)
+				if err != nil {
+					return fmt.Errorf("unable to preload %s: %v", providerID, err)
+				}
+				r.gceInstanceCache.put(instanceKey(providerID), instance)
+				return nil
+			})
+		}
+	}
+
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := job(); err != nil {
+				logger.Error(err, "unable to preload cloud state")
+			}
+		}()
+	}
+	wg.Wait()
+
+	logger.Info("Preloaded cloud state", "nodes", len(nodes.Items))
+	return nil
+}
+
+// preloadRunnable returns a runnable of PreloadCloudState for the manager. It needs leader
+// election, so standby replicas don't spend API quota on it, and reconciles wait for it to finish
+// so they find the preloaded state.
+func (r *NodeLabelController) preloadRunnable(reader client.Reader, concurrency int) manager.Runnable {
+	r.preloaded = make(chan struct{})
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		defer close(r.preloaded)
+		return r.PreloadCloudState(ctx, reader, concurrency)
+	})
+}
+
+// waitForPreload blocks until the preload runnable finished, if there is one.
+func (r *NodeLabelController) waitForPreload(ctx context.Context) error {
+	if r.preloaded == nil {
+		return nil
+	}
+	select {
+	case <-r.preloaded:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// awsPreloadJobs returns the jobs fetching the tags of the instances behind providerIDs, in
+// batches of up to awsPreloadBatchSize instances of the same region. With --tag-ebs-volumes or
+// --tag-enis the instances are described, to also fetch their attached resources.
+func (r *NodeLabelController) awsPreloadJobs(ctx context.Context, providerIDs []string) []func() error {
+	var clients []ec2Client
+	byClient := make(map[ec2Client][]string)
+	for _, providerID := range providerIDs {
+		svc, err := r.ec2ClientFor(providerID)
+		if err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "unable to preload cloud state", "providerID", providerID)
+			continue
+		}
+		if _, ok := byClient[svc]; !ok {
+			clients = append(clients, svc)
+		}
+		byClient[svc] = append(byClient[svc], providerID)
+	}
+
+	var jobs []func() error
+	for _, svc := range clients {
+		for batch := range slices.Chunk(byClient[svc], awsPreloadBatchSize) {
+			jobs = append(jobs, func() error {
+				ids := make([]string, 0, len(batch))
+				providerIDs := make(map[string]string, len(batch))
+				for _, providerID := range batch {
+					ids = append(ids, path.Base(providerID))
+					providerIDs[path.Base(providerID)] = providerID
+				}
+				if r.AWSTagEBSVolumes || r.AWSTagENIs {
+					instances, err := r.describeAWSInstances(ctx, svc, ids)
+					if err != nil {
+						return fmt.Errorf("unable to preload %d instances: %v", len(batch), err)
+					}
+					// missing instances are left to their reconcile
+					for id, instance := range instances {
+						if providerID, ok := providerIDs[id]; ok {
+							r.awsInstanceCache.put(instanceKey(providerID), instance)
+						}
+					}
+					return nil
+				}
+
+				tags, err := r.describeAWSTags(ctx, svc, ids)
+				if err != nil {
+					return fmt.Errorf("unable to preload the tags of %d instances: %v", len(batch), err)
+				}
+
+				// instances without tags are cached too, they don't need to be fetched again
+				byInstance := make(map[string]*awsInstance, len(batch))
+				for id := range providerIDs {
+					byInstance[id] = &awsInstance{}
+				}
+				for _, tag := range tags {
+					id := aws.ToString(tag.ResourceId)
+					if instance, ok := byInstance[id]; ok {
+						instance.tags = append(instance.tags, tag)
+					}
+				}
+				for id, instance := range byInstance {
+					r.awsInstanceCache.put(instanceKey(providerIDs[id]), instance)
+				}
+				return nil
+			})
+		}
+	}
+	return jobs
+}
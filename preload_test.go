@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gce "google.golang.org/api/compute/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// countingEC2Client is a concurrency safe ec2Client that counts DescribeTags and DescribeInstances
+// calls, in total and per instance
+type countingEC2Client struct {
+	mu        sync.Mutex
+	calls     int
+	describes map[string]int
+	created   map[string][]types.Tag
+}
+
+func (m *countingEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+	var tags []types.TagDescription
+	for _, instanceID := range params.Filters[0].Values {
+		m.describes[instanceID]++
+		tags = append(tags, types.TagDescription{ResourceId: aws.String(instanceID), Key: aws.String("env"), Value: aws.String("staging")})
+	}
+	return &ec2.DescribeTagsOutput{Tags: tags}, nil
+}
+
+// DescribeInstances returns the instances with an eni-<instance ID> network interface.
+func (m *countingEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+	ids := params.InstanceIds
+	if len(params.Filters) > 0 {
+		ids = params.Filters[0].Values
+	}
+	var instances []types.Instance
+	for _, instanceID := range ids {
+		m.describes[instanceID]++
+		instances = append(instances, types.Instance{
+			InstanceId:        aws.String(instanceID),
+			Tags:              []types.Tag{{Key: aws.String("env"), Value: aws.String("staging")}},
+			NetworkInterfaces: []types.InstanceNetworkInterface{{NetworkInterfaceId: aws.String("eni-" + instanceID)}},
+		})
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: instances}}}, nil
+}
+
+func (m *countingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.created[params.Resources[0]] = params.Tags
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *countingEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// countingGCEClient is a concurrency safe gceClient that counts GetInstance calls per instance
+type countingGCEClient struct {
+	mu     sync.Mutex
+	gets   map[string]int
+	labels map[string]map[string]string
+}
+
+func (m *countingGCEClient) GetInstance(ctx context.Context, project, zone, instance string) (*gce.Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gets[instance]++
+	return &gce.Instance{Name: instance, Labels: map[string]string{"env": "staging"}}, nil
+}
+
+func (m *countingGCEClient) SetLabels(ctx context.Context, project, zone, instance string, req *gce.InstancesSetLabelsRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.labels[instance] = req.Labels
+	return nil
+}
+
+func (m *countingGCEClient) GetDisk(ctx context.Context, project, zone, disk string) (*gce.Disk, error) {
+	return nil, fmt.Errorf("disk %s not found", disk)
+}
+
+func (m *countingGCEClient) SetDiskLabels(ctx context.Context, project, zone, disk string, req *gce.ZoneSetLabelsRequest) error {
+	return fmt.Errorf("disk %s not found", disk)
+}
+
+func TestPreloadCloudStateAWS(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-node1"),
+		createNode("node2", map[string]string{"env": "prod"}, "aws:///us-east-1b/i-node2"),
+		createNode("node3", map[string]string{"env": "prod"}, "aws:///us-east-1c/i-node3"),
+		// nodes of other clouds or without a provider ID are skipped
+		createNode("gcp-node", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1"),
+		createNode("no-provider-id", map[string]string{"env": "prod"}, ""),
+	).Build()
+
+	mock := &countingEC2Client{describes: map[string]int{}, created: map[string][]types.Tag{}}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "aws",
+		EC2Client: mock,
+	}
+
+	require.NoError(t, r.PreloadCloudState(context.Background(), k8s, 2))
+	assert.Equal(t, map[string]int{"i-node1": 1, "i-node2": 1, "i-node3": 1}, mock.describes)
+	assert.Equal(t, 1, mock.calls)
+
+	// the first reconcile of each node uses the preloaded tags
+	for _, name := range []string{"node1", "node2", "node3"} {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: name}})
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.created["i-"+name])
+	}
+	assert.Equal(t, map[string]int{"i-node1": 1, "i-node2": 1, "i-node3": 1}, mock.describes)
+
+	// later reconciles read the cloud API again
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, mock.describes["i-node1"])
+}
+
+func TestPreloadCloudStateAWSENIs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-node1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &countingEC2Client{describes: map[string]int{}, created: map[string][]types.Tag{}}
+	r := &NodeLabelController{
+		Client:     k8s,
+		Labels:     []string{"env"},
+		Cloud:      "aws",
+		EC2Client:  mock,
+		AWSTagENIs: true,
+	}
+
+	// the instance and its network interfaces are preloaded by DescribeInstances
+	require.NoError(t, r.PreloadCloudState(context.Background(), k8s, 1))
+	assert.Equal(t, map[string]int{"i-node1": 1}, mock.describes)
+
+	// the first reconcile only fetches the tags of the network interfaces
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"i-node1": 1, "eni-i-node1": 1}, mock.describes)
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.created["i-node1"])
+	assert.Equal(t, []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}, mock.created["eni-i-node1"])
+}
+
+func TestPreloadCloudStateAWSBatches(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	var nodes []client.Object
+	for i := range awsPreloadBatchSize + 50 {
+		nodes = append(nodes, createNode(fmt.Sprintf("node%d", i), nil, fmt.Sprintf("aws:///us-east-1a/i-node%d", i)))
+	}
+	nodes = append(nodes, createNode("west", nil, "aws:///us-west-2a/i-west"))
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodes...).Build()
+
+	clients := map[string]*countingEC2Client{}
+	r := &NodeLabelController{
+		Client:     k8s,
+		Labels:     []string{"env"},
+		Cloud:      "aws",
+		AZToRegion: azToRegionFuncs["suffix"],
+		NewEC2Client: func(region string) ec2Client {
+			clients[region] = &countingEC2Client{describes: map[string]int{}, created: map[string][]types.Tag{}}
+			return clients[region]
+		},
+	}
+
+	// instances are fetched in batches of the same region
+	require.NoError(t, r.PreloadCloudState(context.Background(), k8s, 1))
+	assert.Equal(t, 2, clients["us-east-1"].calls)
+	assert.Len(t, clients["us-east-1"].describes, awsPreloadBatchSize+50)
+	assert.Equal(t, 1, clients["us-west-2"].calls)
+
+	instance, ok := r.awsInstanceCache.take("aws/i-west")
+	require.True(t, ok)
+	assert.Equal(t, []types.TagDescription{{ResourceId: aws.String("i-west"), Key: aws.String("env"), Value: aws.String("staging")}}, instance.tags)
+}
+
+func TestPreloadCloudStateGCP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &countingGCEClient{gets: map[string]int{}, labels: map[string]map[string]string{}}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "gcp",
+		GCEClient: mock,
+	}
+
+	require.NoError(t, r.PreloadCloudState(context.Background(), k8s, 1))
+	assert.Equal(t, 1, mock.gets["instance-1"])
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, mock.gets[path.Base(node.Spec.ProviderID)])
+	assert.Equal(t, map[string]string{"env": "prod"}, mock.labels["instance-1"])
+}
+
+func TestPreloadRunnable(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod"}, "gce://my-project/us-central1-a/instance-1")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	mock := &countingGCEClient{gets: map[string]int{}, labels: map[string]map[string]string{}}
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env"},
+		Cloud:     "gcp",
+		GCEClient: mock,
+	}
+	runnable := r.preloadRunnable(k8s, 1)
+	_, ok := runnable.(manager.LeaderElectionRunnable)
+	assert.False(t, ok, "runnables without NeedLeaderElection need leader election")
+
+	// reconciles wait for the preload
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, mock.gets["instance-1"])
+
+	require.NoError(t, runnable.Start(context.Background()))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, mock.gets["instance-1"])
+	assert.Equal(t, map[string]string{"env": "prod"}, mock.labels["instance-1"])
+}
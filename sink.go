@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// tagUpdate describes the desired managed tags of a node's cloud instance.
+type tagUpdate struct {
+	Time       time.Time         `json:"time"`
+	Node       string            `json:"node"`
+	ProviderID string            `json:"providerID"`
+	Tags       map[string]string `json:"tags"`
+
+	// ManagedKeys are the tag keys owned by the controller. Managed keys missing from Tags
+	// should be deleted from the instance, all other tags must be preserved.
+	ManagedKeys []string `json:"managedKeys"`
+
+	// ManagedKeyPatterns are glob patterns of further tag keys owned by the controller, as
+	// matched by Go's path.Match, eg: topology.kubernetes.io/* for --labels=topology.kubernetes.io/*
+	ManagedKeyPatterns []string `json:"managedKeyPatterns,omitempty"`
+
+	// DryRun requests the update only be logged
+	DryRun bool `json:"-"`
+}
+
+// tagSink applies tag updates. The cloud provider APIs are the default sink, --sink=file:<path>
+// hands updates to an external process instead, eg: in air-gapped environments.
+type tagSink interface {
+	Apply(ctx context.Context, update tagUpdate) error
+}
+
+// newTagSink returns the sink for a --sink value. A nil sink means the cloud provider APIs.
+func newTagSink(spec string) (tagSink, error) {
+	kind, path, err := parseSinkSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "file" {
+		return newFileSink(path)
+	}
+	return nil, nil
+}
+
+// parseSinkSpec parses a --sink value into the sink kind, cloud or file, and its argument.
+func parseSinkSpec(spec string) (string, string, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "cloud":
+		return "cloud", "", nil
+	case "file":
+		if arg == "" {
+			return "", "", fmt.Errorf("file sink requires a path, eg: file:/var/run/node-tagger/updates.jsonl")
+		}
+		return kind, arg, nil
+	}
+	return "", "", fmt.Errorf("unsupported sink: %q", spec)
+}
+
+var _ tagSink = (*cloudSink)(nil)
+
+// cloudSink applies tag updates through the controller's cloud provider clients.
+type cloudSink struct {
+	r *NodeLabelController
+}
+
+func (s *cloudSink) Apply(ctx context.Context, update tagUpdate) error {
+	if s.r.CloudRateLimiter != nil {
+		if err := s.r.CloudRateLimiter.Wait(ctx); err != nil {
+			return fmt.Errorf("cloud rate limiter: %v", err)
+		}
+	}
+	return s.r.syncTags(ctx, update.ProviderID, update.Tags, update.DryRun)
+}
+
+var _ tagSink = (*fileSink)(nil)
+
+// fileSink appends tag updates as JSON lines to a file, for an external tool to apply.
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sink file: %v", err)
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Apply(ctx context.Context, update tagUpdate) error {
+	if update.DryRun {
+		ctrl.LoggerFrom(ctx).Info("Skipping file sink write", "providerID", update.ProviderID, "tags", update.Tags)
+		return nil
+	}
+
+	line, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to encode tag update: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write tag update to %s: %v", s.f.Name(), err)
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+// Close flushes the written updates to disk and closes the file.
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return fmt.Errorf("failed to sync %s: %v", s.f.Name(), err)
+	}
+	return s.f.Close()
+}
+
+// closeSink closes sink if it holds resources, eg: the file of a file sink.
+func closeSink(sink tagSink) error {
+	if c, ok := sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNewTagSink(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		spec     string
+		wantFile bool
+		wantErr  bool
+	}{
+		{name: "empty defaults to cloud", spec: ""},
+		{name: "cloud", spec: "cloud"},
+		{name: "file", spec: "file:" + filepath.Join(dir, "updates.jsonl"), wantFile: true},
+		{name: "file without path", spec: "file:", wantErr: true},
+		{name: "file in missing directory", spec: "file:" + filepath.Join(dir, "missing", "updates.jsonl"), wantErr: true},
+		{name: "unsupported", spec: "s3:bucket", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := newTagSink(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if !tt.wantFile {
+				assert.Nil(t, sink)
+				return
+			}
+			require.IsType(t, &fileSink{}, sink)
+			require.NoError(t, closeSink(sink))
+		})
+	}
+}
+
+func readTagUpdates(t *testing.T, path string) []tagUpdate {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var updates []tagUpdate
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var u tagUpdate
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &u))
+		updates = append(updates, u)
+	}
+	require.NoError(t, scanner.Err())
+	return updates
+}
+
+func TestReconcileFileSink(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "other": "value"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+	sink, err := newFileSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// no cloud clients are configured, the file sink must not need them
+	r := &NodeLabelController{
+		Client: k8s,
+		Labels: []string{"env", "team"},
+		Cloud:  "aws",
+		Sink:   sink,
+	}
+
+	for range 2 {
+		_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+		require.NoError(t, err)
+	}
+
+	updates := readTagUpdates(t, path)
+	require.Len(t, updates, 2)
+	for _, u := range updates {
+		assert.Equal(t, "node1", u.Node)
+		assert.Equal(t, "aws:///us-east-1a/i-1234567890abcdef0", u.ProviderID)
+		assert.Equal(t, map[string]string{"env": "prod"}, u.Tags)
+		assert.Equal(t, []string{"env", "team"}, u.ManagedKeys)
+		assert.False(t, u.Time.IsZero())
+		assert.Nil(t, u.ManagedKeyPatterns)
+	}
+}
+
+func TestReconcileFileSinkKeyPatterns(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "topology.kubernetes.io/zone": "us-east-1a", "topology.kubernetes.io/region": "us-east-1"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+	sink, err := newFileSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	r := &NodeLabelController{
+		Client:    k8s,
+		Labels:    []string{"env", "topology.kubernetes.io/*", "example.com/*"},
+		Cloud:     "aws",
+		TagPrefix: "k8s:",
+		Sink:      sink,
+	}
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}})
+	require.NoError(t, err)
+
+	updates := readTagUpdates(t, path)
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]string{
+		"k8s:env":                           "prod",
+		"k8s:topology.kubernetes.io/zone":   "us-east-1a",
+		"k8s:topology.kubernetes.io/region": "us-east-1",
+	}, updates[0].Tags)
+	assert.Equal(t, []string{"k8s:env"}, updates[0].ManagedKeys)
+	assert.Equal(t, []string{"k8s:topology.kubernetes.io/*", "k8s:example.com/*"}, updates[0].ManagedKeyPatterns)
+}
+
+func TestFileSinkDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+	sink, err := newFileSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	err = sink.Apply(context.Background(), tagUpdate{Node: "node1", Tags: map[string]string{"env": "prod"}, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, readTagUpdates(t, path))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// cloudSummary counts the operations performed for a cloud provider. Reconciled counts distinct
+// nodes, Errors failed reconciles.
+type cloudSummary struct {
+	Reconciled  int `json:"reconciled"`
+	TagsCreated int `json:"tagsCreated"`
+	TagsDeleted int `json:"tagsDeleted"`
+	Errors      int `json:"errors"`
+}
+
+// syncSummary counts the operations performed by the controller since it started, by cloud, to
+// log a summary at shutdown.
+type syncSummary struct {
+	mu     sync.Mutex
+	clouds map[string]*cloudSummary
+
+	// nodes are the names of the nodes reconciled, by cloud
+	nodes map[string]map[string]struct{}
+}
+
+// cloud returns the counters of cloud. s.mu must be held.
+func (s *syncSummary) cloud(cloud string) *cloudSummary {
+	if s.clouds == nil {
+		s.clouds = make(map[string]*cloudSummary)
+	}
+	c, ok := s.clouds[cloud]
+	if !ok {
+		c = &cloudSummary{}
+		s.clouds[cloud] = c
+	}
+	return c
+}
+
+// reconciled records a reconcile of node and whether it failed. Nodes are counted once per
+// cloud, however often they're reconciled.
+func (s *syncSummary) reconciled(cloud, node string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nodes == nil {
+		s.nodes = make(map[string]map[string]struct{})
+	}
+	if s.nodes[cloud] == nil {
+		s.nodes[cloud] = make(map[string]struct{})
+	}
+	s.nodes[cloud][node] = struct{}{}
+
+	c := s.cloud(cloud)
+	c.Reconciled = len(s.nodes[cloud])
+	if err != nil {
+		c.Errors++
+	}
+}
+
+// tagsChanged records tags created or updated, and deleted, on an instance.
+func (s *syncSummary) tagsChanged(cloud string, created, deleted int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.cloud(cloud)
+	c.TagsCreated += created
+	c.TagsDeleted += deleted
+}
+
+// snapshot returns a copy of the counters by cloud, and their totals. A node reconciled under
+// several clouds, eg: whose provider ID moved, counts once in the total.
+func (s *syncSummary) snapshot() (map[string]cloudSummary, cloudSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total cloudSummary
+	nodes := make(map[string]struct{})
+	clouds := make(map[string]cloudSummary, len(s.clouds))
+	for cloud, c := range s.clouds {
+		clouds[cloud] = *c
+		for node := range s.nodes[cloud] {
+			nodes[node] = struct{}{}
+		}
+		total.TagsCreated += c.TagsCreated
+		total.TagsDeleted += c.TagsDeleted
+		total.Errors += c.Errors
+	}
+	total.Reconciled = len(nodes)
+	return clouds, total
+}
+
+// log logs the counters, eg: at shutdown.
+func (s *syncSummary) log(logger logr.Logger) {
+	clouds, total := s.snapshot()
+	logger.Info("Summary",
+		"reconciled", total.Reconciled,
+		"tagsCreated", total.TagsCreated,
+		"tagsDeleted", total.TagsDeleted,
+		"errors", total.Errors,
+		"clouds", clouds,
+	)
+}
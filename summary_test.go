@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileSummary(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "a"}, "aws:///us-east-1a/i-1234567890abcdef0")
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	mock := &mockEC2Client{currentTags: []types.TagDescription{
+		{Key: aws.String("zone"), Value: aws.String("us-east-1a")},
+	}}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env", "team", "zone"}, Cloud: "aws", EC2Client: mock}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	mock.describeErr = errors.New("throttled")
+	_, err = r.Reconcile(context.Background(), req)
+	require.Error(t, err)
+
+	// the node is counted once, however often it's reconciled
+	clouds, total := r.summary.snapshot()
+	want := cloudSummary{Reconciled: 1, TagsCreated: 2, TagsDeleted: 1, Errors: 1}
+	assert.Equal(t, want, total)
+	assert.Equal(t, map[string]cloudSummary{"aws": want}, clouds)
+}
+
+func TestSyncSummaryByCloud(t *testing.T) {
+	var s syncSummary
+	s.reconciled("aws", "node1", nil)
+	s.reconciled("aws", "node2", nil)
+	s.tagsChanged("aws", 3, 0)
+	s.reconciled("gcp", "node3", errors.New("failed"))
+	s.reconciled("gcp", "node3", nil)
+	s.tagsChanged("gcp", 1, 2)
+	// a node whose provider ID moved from AWS to GCP
+	s.reconciled("gcp", "node1", nil)
+
+	clouds, total := s.snapshot()
+	assert.Equal(t, cloudSummary{Reconciled: 3, TagsCreated: 4, TagsDeleted: 2, Errors: 1}, total)
+	assert.Equal(t, map[string]cloudSummary{
+		"aws": {Reconciled: 2, TagsCreated: 3},
+		"gcp": {Reconciled: 2, TagsCreated: 1, TagsDeleted: 2, Errors: 1},
+	}, clouds)
+}
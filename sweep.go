@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sweeper periodically reconciles all nodes, to correct drift of cloud tags changed outside of
+// the controller that no node event would trigger a reconcile for. As a runnable that needs
+// leader election, it only runs on the leader.
+type sweeper struct {
+	r      *NodeLabelController
+	reader client.Reader
+
+	// interval between the start of two sweeps
+	interval time.Duration
+
+	// concurrency is the maximum number of reconciles of a sweep in flight
+	concurrency int
+
+	// limiter paces the start of the reconciles of a sweep, no limit when nil. The reconciles also
+	// wait on the controller's CloudRateLimiter, shared with event-driven reconciles.
+	limiter *rate.Limiter
+}
+
+func (s *sweeper) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *sweeper) Start(ctx context.Context) error {
+	logger := ctrl.LoggerFrom(ctx).WithName("sweep")
+	ctx = ctrl.LoggerInto(ctx, logger)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.sweep(ctx); err != nil {
+				logger.Error(err, "sweep failed")
+			}
+		}
+	}
+}
+
+// sweep reconciles all nodes once. Failed reconciles are logged and left to the next sweep.
+func (s *sweeper) sweep(ctx context.Context) error {
+	logger := ctrl.LoggerFrom(ctx)
+
+	var nodes corev1.NodeList
+	if err := s.reader.List(ctx, &nodes, s.r.nodeListOptions()...); err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	start := time.Now()
+	sem := make(chan struct{}, max(s.concurrency, 1))
+	var wg sync.WaitGroup
+	for _, node := range nodes.Items {
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			req := ctrl.Request{NamespacedName: client.ObjectKey{Name: node.Name}}
+			if _, err := s.r.Reconcile(ctx, req); err != nil {
+				logger.Error(err, "unable to reconcile node", "node", node.Name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	logger.Info("Swept nodes", "nodes", len(nodes.Items), "duration", time.Since(start))
+	return ctx.Err()
+}
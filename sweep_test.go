@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// taggedCountingEC2Client is an ec2Client, safe for concurrent use, that counts the instances
+// tags were created for
+type taggedCountingEC2Client struct {
+	mu     sync.Mutex
+	tagged int
+}
+
+func (m *taggedCountingEC2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	return &ec2.DescribeTagsOutput{}, nil
+}
+
+func (m *taggedCountingEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{}, nil
+}
+
+func (m *taggedCountingEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tagged += len(params.Resources)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *taggedCountingEC2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// sweepNodes returns a fake client with n nodes, each on its own instance
+func sweepNodes(t *testing.T, n int) client.Client {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := range n {
+		builder.WithObjects(createNode(fmt.Sprintf("node%d", i), map[string]string{"env": "prod"}, fmt.Sprintf("aws:///us-east-1a/i-%d", i)))
+	}
+	return builder.Build()
+}
+
+func TestSweep(t *testing.T) {
+	k8s := sweepNodes(t, 6)
+	mock := &concurrencyTrackingEC2Client{}
+	r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock}
+
+	s := &sweeper{r: r, reader: k8s, concurrency: 2}
+	require.NoError(t, s.sweep(context.Background()))
+
+	assert.Equal(t, 2, mock.maxInFlight)
+	assert.Equal(t, 0, mock.inFlight)
+}
+
+func TestSweepRateLimit(t *testing.T) {
+	const nodes = 4
+	// the first reconcile starts right away, the others wait 1/20s for their turn
+	const wantMinDuration = (nodes - 1) * time.Second / 20
+
+	t.Run("sweep rate", func(t *testing.T) {
+		k8s := sweepNodes(t, nodes)
+		mock := &taggedCountingEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock}
+
+		s := &sweeper{r: r, reader: k8s, concurrency: nodes, limiter: newRateLimiter(20)}
+		start := time.Now()
+		require.NoError(t, s.sweep(context.Background()))
+
+		assert.GreaterOrEqual(t, time.Since(start), wantMinDuration-10*time.Millisecond)
+		assert.Equal(t, nodes, mock.tagged)
+	})
+
+	t.Run("shared cloud rate limiter", func(t *testing.T) {
+		k8s := sweepNodes(t, nodes)
+		mock := &taggedCountingEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, CloudRateLimiter: newRateLimiter(20)}
+
+		s := &sweeper{r: r, reader: k8s, concurrency: nodes}
+		start := time.Now()
+		require.NoError(t, s.sweep(context.Background()))
+
+		assert.GreaterOrEqual(t, time.Since(start), wantMinDuration-10*time.Millisecond)
+		assert.Equal(t, nodes, mock.tagged)
+	})
+}
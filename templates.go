@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The behaviors of TagTemplateMissing for templates referencing a label or annotation missing
+// from a node.
+const (
+	// tagTemplateMissingSkip doesn't write the templated tag, deleting it if it exists
+	tagTemplateMissingSkip = "skip"
+	// tagTemplateMissingEmpty substitutes an empty string for the missing reference
+	tagTemplateMissingEmpty = "empty"
+)
+
+// tagTemplate is a tag whose value combines node labels and annotations, eg: env-team with the
+// template {label:env}-{label:team}.
+type tagTemplate struct {
+	// Key is the cloud tag key, without TagPrefix
+	Key string
+
+	parts []templatePart
+}
+
+// templatePart is a literal string, or a reference to a label or annotation of the node when
+// kind is set.
+type templatePart struct {
+	literal string
+	kind    string
+	key     string
+}
+
+// parseTagTemplate parses a key=template pair, eg: env-team={label:env}-{label:team}. References
+// are {label:<key>} or {annotation:<key>}, the rest of the template is copied as is.
+func parseTagTemplate(s string) (tagTemplate, error) {
+	key, template, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || template == "" {
+		return tagTemplate{}, fmt.Errorf("invalid key=template pair %q", s)
+	}
+
+	t := tagTemplate{Key: key}
+	for rest := template; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return tagTemplate{}, fmt.Errorf("unclosed reference in template %q", template)
+		}
+		kind, ref, _ := strings.Cut(rest[start+1:start+end], ":")
+		if (kind != "label" && kind != "annotation") || ref == "" {
+			return tagTemplate{}, fmt.Errorf("invalid reference %q in template %q, expected {label:<key>} or {annotation:<key>}", rest[start:start+end+1], template)
+		}
+		t.parts = append(t.parts, templatePart{kind: kind, key: ref})
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+// refs returns the keys of the labels or annotations, per kind, referenced by t.
+func (t tagTemplate) refs(kind string) []string {
+	var keys []string
+	for _, p := range t.parts {
+		if p.kind == kind {
+			keys = append(keys, p.key)
+		}
+	}
+	return keys
+}
+
+// render returns the value of t for node, and false when a reference is missing from node and
+// missing is tagTemplateMissingSkip.
+func (t tagTemplate) render(node *corev1.Node, missing string) (string, bool) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.kind == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		values := node.Labels
+		if p.kind == "annotation" {
+			values = node.Annotations
+		}
+		v, ok := values[p.key]
+		if !ok && missing != tagTemplateMissingEmpty {
+			return "", false
+		}
+		b.WriteString(v)
+	}
+	return b.String(), true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseTagTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    tagTemplate
+		wantErr string
+	}{
+		{
+			name: "labels",
+			s:    "env-team={label:env}-{label:team}",
+			want: tagTemplate{Key: "env-team", parts: []templatePart{{kind: "label", key: "env"}, {literal: "-"}, {kind: "label", key: "team"}}},
+		},
+		{
+			name: "annotation and literals",
+			s:    "owner=team {annotation:example.com/owner}!",
+			want: tagTemplate{Key: "owner", parts: []templatePart{{literal: "team "}, {kind: "annotation", key: "example.com/owner"}, {literal: "!"}}},
+		},
+		{
+			name: "literal only",
+			s:    "site=dc1",
+			want: tagTemplate{Key: "site", parts: []templatePart{{literal: "dc1"}}},
+		},
+		{name: "missing template", s: "env-team", wantErr: `invalid key=template pair "env-team"`},
+		{name: "empty key", s: "={label:env}", wantErr: `invalid key=template pair "={label:env}"`},
+		{name: "unclosed reference", s: "env-team={label:env", wantErr: `unclosed reference in template "{label:env"`},
+		{name: "unknown kind", s: "zone={taint:zone}", wantErr: `invalid reference "{taint:zone}" in template "{taint:zone}", expected {label:<key>} or {annotation:<key>}`},
+		{name: "empty reference", s: "zone={label:}", wantErr: `invalid reference "{label:}" in template "{label:}", expected {label:<key>} or {annotation:<key>}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTagTemplate(tt.s)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTagTemplateRender(t *testing.T) {
+	tmpl, err := parseTagTemplate("env-team={label:env}-{label:team}-{annotation:example.com/owner}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env", "team"}, tmpl.refs("label"))
+	assert.Equal(t, []string{"example.com/owner"}, tmpl.refs("annotation"))
+
+	node := createNode("node1", map[string]string{"env": "prod", "team": "db"}, "")
+	node.Annotations = map[string]string{"example.com/owner": "ops"}
+	got, ok := tmpl.render(node, tagTemplateMissingSkip)
+	assert.True(t, ok)
+	assert.Equal(t, "prod-db-ops", got)
+
+	delete(node.Labels, "team")
+	_, ok = tmpl.render(node, tagTemplateMissingSkip)
+	assert.False(t, ok)
+
+	got, ok = tmpl.render(node, tagTemplateMissingEmpty)
+	assert.True(t, ok)
+	assert.Equal(t, "prod--ops", got)
+}
+
+func TestReconcileTagTemplates(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+
+	tmpl, err := parseTagTemplate("env-team={label:env}-{label:team}")
+	require.NoError(t, err)
+
+	t.Run("resolved", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod", "team": "db"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		mock := &mockEC2Client{}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, TagTemplates: []tagTemplate{tmpl}}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("env-team"), Value: aws.String("prod-db")},
+		}, mock.createdTags)
+	})
+
+	t.Run("missing reference", func(t *testing.T) {
+		node := createNode("node1", map[string]string{"env": "prod"}, "aws:///us-east-1a/i-1234567890abcdef0")
+		k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+		// the templated tag is skipped, and deleted as it's managed
+		mock := &mockEC2Client{currentTags: []types.TagDescription{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("env-team"), Value: aws.String("prod-db")},
+		}}
+		r := &NodeLabelController{Client: k8s, Labels: []string{"env"}, Cloud: "aws", EC2Client: mock, TagTemplates: []tagTemplate{tmpl}}
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Nil(t, mock.createdTags)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env-team"), Value: aws.String("prod-db")}}, mock.deletedTags)
+
+		// or rendered with an empty string
+		mock.deletedTags = nil
+		r.TagTemplateMissing = tagTemplateMissingEmpty
+		_, err = r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Tag{{Key: aws.String("env-team"), Value: aws.String("prod-")}}, mock.createdTags)
+		assert.Nil(t, mock.deletedTags)
+	})
+
+	t.Run("referenced labels are monitored", func(t *testing.T) {
+		r := &NodeLabelController{Labels: []string{"env"}, TagTemplates: []tagTemplate{tmpl}}
+		assert.Equal(t, []string{"env", "team"}, r.monitoredLabels())
+
+		oldNode := createNode("node1", map[string]string{"env": "prod", "team": "db"}, "")
+		newNode := createNode("node1", map[string]string{"env": "prod", "team": "web"}, "")
+		assert.True(t, shouldProcessNodeUpdate(oldNode, newNode, r.monitoredLabels(), r.monitoredAnnotations()))
+	})
+}